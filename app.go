@@ -9,6 +9,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,6 +17,7 @@ import (
 
 	"stego/internal/app"
 	"stego/internal/config"
+	"stego/internal/crypto"
 	"stego/internal/log"
 	"stego/internal/models"
 )
@@ -26,12 +28,16 @@ type App struct {
 	tasks  *app.TaskManager
 	logger *log.Store
 	info   models.AppInfo
+
+	textMu sync.Mutex
+	texts  map[string]string
 }
 
 func NewApp() *App {
 	buildHash := computeBuildHash()
 
 	return &App{
+		texts: map[string]string{},
 		info: models.AppInfo{
 			Name:      "stego",
 			Version:   "1.0",
@@ -118,6 +124,54 @@ func (a *App) SaveConfig(configMap map[string]string) error {
 	return err
 }
 
+func (a *App) CalibrateKDF() (models.KDFCalibration, error) {
+	if a.cfg == nil {
+		return models.KDFCalibration{}, errors.New("config store not initialized")
+	}
+	res, values, err := app.CalibrateKDF(a.cfg.GetAllWithDefaults())
+	if err != nil {
+		return models.KDFCalibration{}, err
+	}
+	if err := a.cfg.SaveAll(values); err != nil {
+		return models.KDFCalibration{}, err
+	}
+	if a.logger != nil {
+		_ = a.logger.Add("INFO", "config", "KDF 校准完成",
+			fmt.Sprintf("t=%d m=%dKiB p=%d 预计耗时: %dms", res.Time, res.MemoryKiB, res.Threads, res.DurationMs))
+	}
+	return res, nil
+}
+
+func (a *App) GenerateKeyPair() (models.KeyPair, error) {
+	id, err := crypto.GenerateX25519Identity()
+	if err != nil {
+		return models.KeyPair{}, err
+	}
+	pair := models.KeyPair{
+		PublicKey:  id.Recipient().String(),
+		PrivateKey: id.String(),
+	}
+	if a.logger != nil {
+		_ = a.logger.Add("INFO", "keys", "生成密钥对", "公钥: "+pair.PublicKey)
+	}
+	return pair, nil
+}
+
+func (a *App) GenerateSigningKeyPair() (models.KeyPair, error) {
+	k, err := crypto.GenerateSigningKey()
+	if err != nil {
+		return models.KeyPair{}, err
+	}
+	pair := models.KeyPair{
+		PublicKey:  k.Public().String(),
+		PrivateKey: k.String(),
+	}
+	if a.logger != nil {
+		_ = a.logger.Add("INFO", "keys", "生成签名密钥对", "公钥: "+pair.PublicKey)
+	}
+	return pair, nil
+}
+
 func (a *App) GetAppInfo() models.AppInfo {
 	return a.info
 }
@@ -141,7 +195,7 @@ func (a *App) StartEncrypt(req models.EncryptRequest) string {
 				_ = a.logger.Add("INFO", module, action, details)
 			}
 		}
-		err := app.RunEncrypt(ctx, a.cfg.GetAllWithDefaults(), req, func(p models.ProgressEvent) {
+		res, err := app.RunEncrypt(ctx, a.cfg.GetAllWithDefaults(), req, func(p models.ProgressEvent) {
 			p.TaskID = taskID
 			runtime.EventsEmit(a.ctx, "encryptProgress", p)
 		}, taskID, perf)
@@ -152,7 +206,7 @@ func (a *App) StartEncrypt(req models.EncryptRequest) string {
 			}
 		} else {
 			if a.logger != nil {
-				_ = a.logger.Add("INFO", "encrypt", "加密任务完成", fmt.Sprintf("任务ID: %s, 输出目录: %s", taskID, req.OutputDir))
+				_ = a.logger.Add("INFO", "encrypt", "加密任务完成", fmt.Sprintf("任务ID: %s, 输出: %s", taskID, res.OutputPath))
 			}
 		}
 
@@ -188,8 +242,13 @@ func (a *App) StartDecrypt(req models.DecryptRequest) string {
 				_ = a.logger.Add("INFO", module, action, details)
 			}
 		}
-		err := app.RunDecrypt(ctx, a.cfg.GetAllWithDefaults(), req, func(p models.ProgressEvent) {
+		res, err := app.RunDecrypt(ctx, a.cfg.GetAllWithDefaults(), req, func(p models.ProgressEvent) {
 			p.TaskID = taskID
+			if p.DecryptResult != nil && p.DecryptResult.PayloadType == app.PayloadText {
+				a.textMu.Lock()
+				a.texts[taskID] = p.DecryptResult.Text
+				a.textMu.Unlock()
+			}
 			runtime.EventsEmit(a.ctx, "decryptProgress", p)
 		}, taskID, perf)
 
@@ -199,7 +258,11 @@ func (a *App) StartDecrypt(req models.DecryptRequest) string {
 			}
 		} else {
 			if a.logger != nil {
-				_ = a.logger.Add("INFO", "decrypt", "解密任务完成", fmt.Sprintf("任务ID: %s, 输出目录: %s", taskID, req.OutputDir))
+				output := res.OutputPath
+				if res.PayloadType == app.PayloadText {
+					output = "(文本)"
+				}
+				_ = a.logger.Add("INFO", "decrypt", "解密任务完成", fmt.Sprintf("任务ID: %s, 输出: %s, 签名: %s", taskID, output, res.SignatureStatus))
 			}
 		}
 
@@ -216,6 +279,19 @@ func (a *App) CancelDecrypt(taskID string) error {
 	return err
 }
 
+// GetDecryptedText returns the text payload recovered by a finished decrypt
+// task. The text is handed out once and then forgotten.
+func (a *App) GetDecryptedText(taskID string) (string, error) {
+	a.textMu.Lock()
+	defer a.textMu.Unlock()
+	text, ok := a.texts[taskID]
+	if !ok {
+		return "", errors.New("no text payload for task")
+	}
+	delete(a.texts, taskID)
+	return text, nil
+}
+
 func (a *App) StartGenerateCarrier(req models.GenerateRequest) string {
 	taskID := uuid.NewString()
 
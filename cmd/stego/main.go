@@ -0,0 +1,509 @@
+// Command stego is the headless front end to the same pipeline the desktop
+// app runs, for scripting on servers and in CI.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"stego/internal/app"
+	"stego/internal/config"
+	"stego/internal/log"
+	"stego/internal/models"
+	"stego/internal/server"
+)
+
+const (
+	exitOK        = 0
+	exitFailure   = 1
+	exitUsage     = 2
+	exitCorrupted = 3
+	exitAuth      = 4
+	exitCapacity  = 5
+	exitKDFLimit  = 6
+	exitCancelled = 130
+)
+
+const usage = `usage: stego <command> [flags]
+
+commands:
+  encrypt    hide a file, folder or text message in a carrier image
+  decrypt    recover the payload from an image
+  generate   create noise carrier images
+  inspect    show capacity, embedding flags and container metadata
+  serve      expose the pipeline as a localhost HTTP JSON API
+
+Passwords are read from $STEGO_PASSWORD, -password-env, -password-file or
+-password-stdin, never from the command line. Run "stego <command> -h" for
+the flags of each command.
+
+exit status: 0 ok, 1 failure, 2 usage, 3 corrupted data, 4 wrong or missing
+password or key, 5 carrier too small, 6 key derivation over the limit,
+130 cancelled
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	cmds := map[string]func(context.Context, *cli, []string) error{
+		"encrypt":  runEncrypt,
+		"decrypt":  runDecrypt,
+		"generate": runGenerate,
+		"inspect":  runInspect,
+		"serve":    runServe,
+	}
+	name := args[0]
+	if name == "-h" || name == "--help" || name == "help" {
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	}
+	cmd, ok := cmds[name]
+	if !ok {
+		fmt.Fprintf(stderr, "stego: unknown command %q\n\n%s", name, usage)
+		return exitUsage
+	}
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+	err := cmd(ctx, c, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "stego %s: %v\n", name, err)
+	}
+	return exitCode(err)
+}
+
+type usageError struct{ error }
+
+func exitCode(err error) int {
+	var ue usageError
+	if errors.As(err, &ue) {
+		return exitUsage
+	}
+	switch app.ErrorCode(err) {
+	case "":
+		return exitOK
+	case models.ErrorCodeCancelled:
+		return exitCancelled
+	case models.ErrorCodeCorrupted:
+		return exitCorrupted
+	case models.ErrorCodeWrongPassword, models.ErrorCodeKeyRequired:
+		return exitAuth
+	case models.ErrorCodeCapacity:
+		return exitCapacity
+	case models.ErrorCodeKDFLimit:
+		return exitKDFLimit
+	default:
+		return exitFailure
+	}
+}
+
+// cli carries the standard streams and the flags every command shares.
+type cli struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+
+	configPath    string
+	quiet         bool
+	verbose       bool
+	passwordEnv   string
+	passwordFile  string
+	passwordStdin bool
+
+	args []string
+}
+
+func (c *cli) flagSet(name string, withPassword bool) *flag.FlagSet {
+	fs := flag.NewFlagSet("stego "+name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.StringVar(&c.configPath, "config", os.Getenv("STEGO_CONFIG"), "config database (default: data/config.db next to the executable, if present)")
+	fs.BoolVar(&c.quiet, "q", false, "do not print progress")
+	fs.BoolVar(&c.verbose, "v", false, "print timing details")
+	if withPassword {
+		fs.StringVar(&c.passwordEnv, "password-env", "STEGO_PASSWORD", "environment variable holding the password")
+		fs.StringVar(&c.passwordFile, "password-file", "", "read the password from the first line of `file`")
+		fs.BoolVar(&c.passwordStdin, "password-stdin", false, "read the password from the first line of stdin")
+	}
+	return fs
+}
+
+// parse accepts flags before and after positional arguments, which are
+// collected in c.args.
+func (c *cli) parse(fs *flag.FlagSet, args []string) error {
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return err
+			}
+			return usageError{err}
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return nil
+		}
+		c.args = append(c.args, args[0])
+		args = args[1:]
+	}
+}
+
+func (c *cli) config() (map[string]string, error) {
+	store, _, err := c.store()
+	if err != nil {
+		return nil, err
+	}
+	return store.GetAllWithDefaults(), nil
+}
+
+// store opens the settings database the desktop app uses so its defaults
+// apply here too, and returns its path. Without one, an in-memory store with
+// the built-in defaults is used and the path is empty.
+func (c *cli) store() (*config.Store, string, error) {
+	path := c.configPath
+	if path == "" {
+		exe, err := os.Executable()
+		if err == nil {
+			candidate := filepath.Join(filepath.Dir(exe), "data", "config.db")
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+			}
+		}
+	}
+	if path == "" {
+		return config.NewInMemoryStore(), "", nil
+	}
+	store, err := config.NewStore(path)
+	return store, path, err
+}
+
+func (c *cli) password() (string, error) {
+	switch {
+	case c.passwordStdin && c.passwordFile != "":
+		return "", usageError{errors.New("-password-stdin and -password-file are mutually exclusive")}
+	case c.passwordStdin:
+		return readFirstLine(c.stdin)
+	case c.passwordFile != "":
+		f, err := os.Open(c.passwordFile)
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = f.Close() }()
+		return readFirstLine(f)
+	case c.passwordEnv != "":
+		return os.Getenv(c.passwordEnv), nil
+	}
+	return "", nil
+}
+
+func readFirstLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *cli) emit() func(models.ProgressEvent) {
+	last := -1
+	return func(p models.ProgressEvent) {
+		if c.quiet || p.Error != "" || p.Progress == last && !p.Done {
+			return
+		}
+		last = p.Progress
+		line := fmt.Sprintf("[%3d%%] %s", p.Progress, p.Message)
+		if p.BytesTotal > 0 && p.BytesPerSec > 0 {
+			line += fmt.Sprintf(" %.1f MiB/s", p.BytesPerSec/(1<<20))
+			if p.ETASeconds > 0 {
+				line += fmt.Sprintf(", %s left", time.Duration(p.ETASeconds*float64(time.Second)).Round(time.Second))
+			}
+		}
+		fmt.Fprintln(c.stderr, line)
+	}
+}
+
+func (c *cli) perfLogger() app.PerfLogger {
+	if !c.verbose {
+		return nil
+	}
+	return func(module, action, details string) {
+		fmt.Fprintf(c.stderr, "  %s %s %s\n", module, action, details)
+	}
+}
+
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+func runEncrypt(ctx context.Context, c *cli, args []string) error {
+	var req models.EncryptRequest
+	var recipients stringList
+	var noScatter bool
+	fs := c.flagSet("encrypt", true)
+	fs.StringVar(&req.DataSourcePath, "in", "", "file or folder to hide")
+	fs.StringVar(&req.Text, "text", "", "hide this text message instead of a file")
+	fs.StringVar(&req.CarrierImagePath, "carrier", "", "carrier image (default: pick one from -carrier-dir)")
+	fs.StringVar(&req.CarrierDir, "carrier-dir", "", "directory to pick a carrier image from")
+	fs.BoolVar(&req.PreferLargestImage, "largest", false, "pick the largest suitable carrier")
+	fs.StringVar(&req.OutputDir, "out", "", "output directory")
+	fs.StringVar(&req.OutputFileName, "name", "", "output file name")
+	fs.StringVar(&req.KeyfilePath, "keyfile", "", "keyfile mixed into the password")
+	fs.Var(&recipients, "recipient", "encrypt to a public key instead of the password (repeatable)")
+	fs.StringVar(&req.SigningKey, "sign", "", "signing secret key")
+	fs.IntVar(&req.ShareCount, "shares", 0, "split the key into this many shares")
+	fs.IntVar(&req.ShareThreshold, "threshold", 0, "shares required to decrypt")
+	fs.StringVar(&req.Cipher, "cipher", "", "AES-GCM, ChaCha20-Poly1305 or XChaCha20-Poly1305")
+	fs.StringVar(&req.ECC, "ecc", "", "error correction, e.g. 32, RS(128,96) or LT(50)")
+	fs.StringVar(&req.Compression, "compression", "", "none, deflate, zstd or xz")
+	fs.BoolVar(&noScatter, "no-scatter", false, "embed sequentially instead of scattering")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if len(c.args) > 0 {
+		return usageError{fmt.Errorf("unexpected arguments: %v", c.args)}
+	}
+	if req.DataSourcePath == "" && req.Text == "" {
+		return usageError{errors.New("one of -in or -text is required")}
+	}
+	cfg, err := c.config()
+	if err != nil {
+		return err
+	}
+	if req.Password, err = c.password(); err != nil {
+		return err
+	}
+	req.Recipients = recipients
+	if noScatter {
+		scatter := false
+		req.Scatter = &scatter
+	}
+	res, err := app.RunEncrypt(ctx, cfg, req, c.emit(), "cli", c.perfLogger())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.stdout, res.OutputPath)
+	for _, s := range res.Shares {
+		fmt.Fprintln(c.stdout, s)
+	}
+	return nil
+}
+
+func runDecrypt(ctx context.Context, c *cli, args []string) error {
+	var req models.DecryptRequest
+	var shares stringList
+	fs := c.flagSet("decrypt", true)
+	fs.StringVar(&req.ImagePath, "image", "", "image to decrypt (or pass it as the argument)")
+	fs.StringVar(&req.OutputDir, "out", "", "output directory")
+	fs.StringVar(&req.KeyfilePath, "keyfile", "", "keyfile used at encryption")
+	fs.StringVar(&req.Identity, "identity", "", "recipient identity (secret key)")
+	fs.Var(&shares, "share", "key share (repeatable)")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if err := c.imageArg(&req.ImagePath); err != nil {
+		return err
+	}
+	cfg, err := c.config()
+	if err != nil {
+		return err
+	}
+	if req.Password, err = c.password(); err != nil {
+		return err
+	}
+	req.Shares = shares
+	res, err := app.RunDecrypt(ctx, cfg, req, c.emit(), "cli", c.perfLogger())
+	if err != nil {
+		return err
+	}
+	if res.SignatureStatus != "" && res.SignatureStatus != "none" && !c.quiet {
+		signer := res.SignerName
+		if signer == "" {
+			signer = res.SignerKeyID
+		}
+		fmt.Fprintf(c.stderr, "signature: %s %s\n", res.SignatureStatus, signer)
+	}
+	if res.PayloadType == app.PayloadText {
+		_, err := io.WriteString(c.stdout, res.Text)
+		return err
+	}
+	fmt.Fprintln(c.stdout, res.OutputPath)
+	return nil
+}
+
+func runGenerate(ctx context.Context, c *cli, args []string) error {
+	var req models.GenerateRequest
+	var sizeMB float64
+	var noNoise bool
+	fs := c.flagSet("generate", false)
+	fs.StringVar(&req.OutputDir, "out", "", "output directory (default: the configured carrier directory)")
+	fs.Float64Var(&sizeMB, "size", 10, "target capacity per image in MB")
+	fs.IntVar(&req.Count, "count", 1, "number of images")
+	fs.StringVar(&req.Prefix, "prefix", "", "file name prefix")
+	fs.Int64Var(&req.RandomSeed, "seed", 0, "random seed (default: time based)")
+	fs.BoolVar(&noNoise, "no-noise", false, "generate smooth images without noise")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if len(c.args) > 0 {
+		return usageError{fmt.Errorf("unexpected arguments: %v", c.args)}
+	}
+	if req.OutputDir == "" {
+		cfg, err := c.config()
+		if err != nil {
+			return err
+		}
+		req.OutputDir = cfg[config.KeyDefaultCarrierDir]
+	}
+	req.TargetBytes = int64(sizeMB * 1024 * 1024)
+	req.NoiseEnabled = !noNoise
+	if err := app.RunGenerateCarrier(ctx, req, c.emit(), "cli", c.perfLogger()); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.stdout, req.OutputDir)
+	return nil
+}
+
+func runInspect(ctx context.Context, c *cli, args []string) error {
+	var req models.InspectRequest
+	var asJSON bool
+	fs := c.flagSet("inspect", true)
+	fs.StringVar(&req.ImagePath, "image", "", "image to inspect (or pass it as the argument)")
+	fs.StringVar(&req.KeyfilePath, "keyfile", "", "keyfile used at encryption")
+	fs.BoolVar(&asJSON, "json", false, "print the report as JSON")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if err := c.imageArg(&req.ImagePath); err != nil {
+		return err
+	}
+	cfg, err := c.config()
+	if err != nil {
+		return err
+	}
+	if req.Password, err = c.password(); err != nil {
+		return err
+	}
+	res, inspectErr := app.RunInspect(ctx, cfg, req)
+	if res.Width == 0 {
+		return inspectErr
+	}
+	if asJSON {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+		return inspectErr
+	}
+	w := c.stdout
+	fmt.Fprintf(w, "image:       %dx%d, capacity %d bytes\n", res.Width, res.Height, res.CapacityBytes)
+	if !res.PayloadFound {
+		fmt.Fprintln(w, "payload:     not found (wrong password or no hidden data)")
+		return inspectErr
+	}
+	fmt.Fprintf(w, "payload:     %d bytes, scatter=%t crc=%t cropped=%t unreliable=%d\n", res.EmbeddedBytes, res.Scatter, res.CRCValid, res.Cropped, res.UnreliableBytes)
+	if res.FEC != "" {
+		fmt.Fprintf(w, "fec:         %s\n", res.FEC)
+	}
+	if res.Algorithm != "" {
+		fmt.Fprintf(w, "cipher:      %s stream=%t chunk=%d\n", res.Algorithm, res.Stream, res.ChunkSize)
+		fmt.Fprintf(w, "key:         %s keyfile=%t\n", res.KDF, res.Keyfile)
+		compression := res.Compression
+		if compression == "" {
+			compression = "none"
+		}
+		fmt.Fprintf(w, "compression: %s manifest=%t\n", compression, res.Manifest)
+	}
+	if res.SignerKeyID != "" {
+		fmt.Fprintf(w, "signer:      %s\n", res.SignerKeyID)
+	}
+	return inspectErr
+}
+
+func (c *cli) imageArg(path *string) error {
+	switch {
+	case *path == "" && len(c.args) == 1:
+		*path = c.args[0]
+	case len(c.args) > 0:
+		return usageError{fmt.Errorf("unexpected arguments: %v", c.args)}
+	}
+	if *path == "" {
+		return usageError{errors.New("an image path is required")}
+	}
+	return nil
+}
+
+func runServe(ctx context.Context, c *cli, args []string) error {
+	var addr, token string
+	fs := c.flagSet("serve", false)
+	fs.StringVar(&addr, "addr", "127.0.0.1:7788", "loopback address to listen on")
+	fs.StringVar(&token, "token", os.Getenv("STEGO_API_TOKEN"), "API token (default: $STEGO_API_TOKEN, or a random one)")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if len(c.args) > 0 {
+		return usageError{fmt.Errorf("unexpected arguments: %v", c.args)}
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return usageError{err}
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return usageError{fmt.Errorf("refusing to listen on non-loopback address %s", addr)}
+	}
+	store, cfgPath, err := c.store()
+	if err != nil {
+		return err
+	}
+	var logger *log.Store
+	if cfgPath != "" {
+		if logger, err = log.NewStore(filepath.Join(filepath.Dir(cfgPath), "logs.db")); err != nil {
+			return err
+		}
+		defer func() { _ = logger.Close() }()
+	}
+	srv, err := server.New(store, logger, token)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		fmt.Fprintf(c.stdout, "token: %s\n", srv.Token())
+	}
+	fmt.Fprintf(c.stderr, "listening on http://%s\n", ln.Addr())
+
+	hs := &http.Server{Handler: srv.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = hs.Shutdown(shutdownCtx)
+	}()
+	if err := hs.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"stego/internal/app"
+)
+
+func writeCarrier(t *testing.T, path string, w, h int) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func runCLI(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestEncryptDecryptInspect(t *testing.T) {
+	dir := t.TempDir()
+	carrier := filepath.Join(dir, "carrier.png")
+	writeCarrier(t, carrier, 200, 200)
+	secret := filepath.Join(dir, "secret.txt")
+	if err := os.WriteFile(secret, []byte("launch codes"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pwFile := filepath.Join(dir, "pw")
+	if err := os.WriteFile(pwFile, []byte("hunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("STEGO_CONFIG", "")
+
+	code, out, errOut := runCLI("", "encrypt", "-q", "-password-file", pwFile, "-in", secret, "-carrier", carrier, "-out", dir)
+	if code != exitOK {
+		t.Fatalf("encrypt exit %d: %s", code, errOut)
+	}
+	img := strings.TrimSpace(out)
+
+	code, out, errOut = runCLI("hunter2\n", "inspect", img, "-password-stdin")
+	if code != exitOK || !strings.Contains(out, "manifest=true") {
+		t.Fatalf("inspect exit %d: %s%s", code, out, errOut)
+	}
+
+	code, out, errOut = runCLI("hunter2\n", "decrypt", "-q", "-password-stdin", "-out", dir, img)
+	if code != exitOK {
+		t.Fatalf("decrypt exit %d: %s", code, errOut)
+	}
+	got, err := os.ReadFile(strings.TrimSpace(out))
+	if err != nil || string(got) != "launch codes" {
+		t.Fatalf("decrypted %q, %v", got, err)
+	}
+
+	t.Setenv("STEGO_PASSWORD", "wrong")
+	if code, _, _ := runCLI("", "decrypt", "-q", "-out", dir, img); code != exitAuth {
+		t.Fatalf("wrong password exit %d, want %d", code, exitAuth)
+	}
+}
+
+func TestTextMessageGoesToStdout(t *testing.T) {
+	dir := t.TempDir()
+	carrier := filepath.Join(dir, "carrier.png")
+	writeCarrier(t, carrier, 200, 200)
+	t.Setenv("STEGO_CONFIG", "")
+	t.Setenv("STEGO_PASSWORD", "note")
+
+	code, out, errOut := runCLI("", "encrypt", "-q", "-text", "see you at noon", "-carrier", carrier, "-out", dir)
+	if code != exitOK {
+		t.Fatalf("encrypt exit %d: %s", code, errOut)
+	}
+	code, out, errOut = runCLI("", "decrypt", "-q", "-out", dir, strings.TrimSpace(out))
+	if code != exitOK || out != "see you at noon" {
+		t.Fatalf("decrypt exit %d, stdout %q: %s", code, out, errOut)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"bogus"},
+		{"encrypt"},
+		{"decrypt"},
+		{"decrypt", "-nope", "x.png"},
+		{"inspect", "a.png", "b.png"},
+	} {
+		if code, _, _ := runCLI("", args...); code != exitUsage {
+			t.Fatalf("%v: exit %d, want %d", args, code, exitUsage)
+		}
+	}
+	if code, _, _ := runCLI("", "encrypt", "-h"); code != exitOK {
+		t.Fatalf("help exit %d", code)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{nil, exitOK},
+		{context.Canceled, exitCancelled},
+		{fmt.Errorf("%w: a.txt", app.ErrManifestHashMismatch), exitCorrupted},
+		{app.ErrCorrupted, exitCorrupted},
+		{app.ErrWrongPassword, exitAuth},
+		{app.ErrKeyRequired, exitAuth},
+		{app.ErrCapacity, exitCapacity},
+		{fmt.Errorf("%w: image asks for 4096 MiB", app.ErrKDFLimit), exitKDFLimit},
+		{app.ErrNoPayload, exitFailure},
+	} {
+		if got := exitCode(tc.err); got != tc.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
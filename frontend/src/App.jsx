@@ -27,12 +27,16 @@ import {
 } from './components/ui/dropdown-menu';
 import {
   GetAppInfo,
+  CalibrateKDF,
+  GenerateKeyPair,
+  GenerateSigningKeyPair,
   GetConfig,
   SaveConfig,
   StartEncrypt,
   CancelEncrypt,
   StartDecrypt,
   CancelDecrypt,
+  GetDecryptedText,
   StartGenerateCarrier,
   CancelGenerate,
   OpenDirectoryDialog,
@@ -52,6 +56,47 @@ const logAction = (module, action, details = '') => {
   }
 };
 
+const formatBytes = (n) => {
+  const units = ['B', 'KB', 'MB', 'GB'];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return `${n.toFixed(i ? 1 : 0)} ${units[i]}`;
+};
+
+const formatDuration = (s) => {
+  s = Math.ceil(s);
+  const m = Math.floor(s / 60);
+  return m > 0 ? `${m}:${String(s % 60).padStart(2, '0')}` : `${s}s`;
+};
+
+// tOr translates key, or returns fallback when the locales lack it.
+const tOr = (t, key, fallback) => {
+  const text = t(key);
+  return text === key ? fallback : text;
+};
+
+// progressMessage localises a progress event by its stage or error code and
+// appends the throughput and ETA of byte-counted stages.
+const progressMessage = (t, p) => {
+  if (p.error) {
+    return tOr(t, `errors.${p.errorCode}`, p.error);
+  }
+  if (p.done || !p.stage) {
+    return p.message;
+  }
+  let msg = tOr(t, `progress.stage.${p.stage}`, p.message);
+  if (p.bytesPerSec > 0) {
+    msg += ` ${formatBytes(p.bytesDone)} / ${formatBytes(p.bytesTotal)} · ` + t('progress.rate', { rate: formatBytes(p.bytesPerSec) });
+  }
+  if (p.etaSeconds > 0) {
+    msg += ' · ' + t('progress.eta', { eta: formatDuration(p.etaSeconds) });
+  }
+  return msg;
+};
+
 const Icons = {
   Moon: () => (
     <svg xmlns="http://www.w3.org/2000/svg" width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round">
@@ -206,15 +251,22 @@ const MenuItem = ({ icon: Icon, active, onClick, labelKey }) => {
 
 const EncryptPage = ({ config }) => {
   const { t } = useI18n();
+  const [sourceMode, setSourceMode] = React.useState('file');
   const [formData, setFormData] = React.useState({
     dataSourcePath: '',
+    text: '',
     carrierDir: config.defaultCarrierDir || '',
     carrierImagePath: '',
     outputDir: config.defaultOutputDir || '',
     outputFileName: config.defaultEncryptOutputName || '',
     password: config.defaultEncryptPassword || '',
+    keyfilePath: '',
+    recipients: '',
+    shareCount: '',
+    shareThreshold: '',
     scatter: true,
   });
+  const [shares, setShares] = React.useState([]);
   const [progress, setProgress] = React.useState(0);
   const [status, setStatus] = React.useState('');
   const [statusType, setStatusType] = React.useState('info');
@@ -225,8 +277,11 @@ const EncryptPage = ({ config }) => {
   React.useEffect(() => {
     const handler = (p) => {
       setProgress(p.progress);
-      setStatus(p.error || p.message);
+      setStatus(progressMessage(t, p));
       setStatusType(p.error ? 'error' : 'info');
+      if (p.encryptResult && p.encryptResult.shares) {
+        setShares(p.encryptResult.shares);
+      }
       if (p.done) {
         setIsRunning(false);
         setTask(null);
@@ -242,10 +297,16 @@ const EncryptPage = ({ config }) => {
       setStatus(t('encrypt.starting'));
       setStatusType('info');
       setIsRunning(true);
-      logAction('encrypt', '开始加密', `数据源: ${formData.dataSourcePath}`);
+      setShares([]);
+      logAction('encrypt', '开始加密', sourceMode === 'text' ? '数据源: 文本消息' : `数据源: ${formData.dataSourcePath}`);
 
       const taskId = await StartEncrypt({
         ...formData,
+        dataSourcePath: sourceMode === 'file' ? formData.dataSourcePath : '',
+        text: sourceMode === 'text' ? formData.text : '',
+        recipients: formData.recipients.split(/[\s,]+/).filter(Boolean),
+        shareCount: parseInt(formData.shareCount, 10) || 0,
+        shareThreshold: parseInt(formData.shareThreshold, 10) || 0,
         identifier: 'stego',
       });
       setTask(taskId);
@@ -278,6 +339,34 @@ const EncryptPage = ({ config }) => {
         <CardDescription className="text-xs">{t('encrypt.description')}</CardDescription>
       </CardHeader>
       <CardContent className="flex-1 overflow-auto space-y-3">
+        <div className="flex gap-2">
+          {['file', 'text'].map((mode) => (
+            <Button
+              key={mode}
+              variant={sourceMode === mode ? 'default' : 'outline'}
+              size="sm"
+              onClick={() => setSourceMode(mode)}
+              disabled={isRunning}
+            >
+              {t(`encrypt.sourceMode.${mode}`)}
+            </Button>
+          ))}
+        </div>
+
+        {sourceMode === 'text' ? (
+        <div className="space-y-1.5">
+          <Label htmlFor="enc-text" className="text-xs">{t('encrypt.text')}</Label>
+          <textarea
+            id="enc-text"
+            rows={4}
+            placeholder={t('encrypt.textPlaceholder')}
+            value={formData.text}
+            onChange={(e) => setFormData({ ...formData, text: e.target.value })}
+            disabled={isRunning}
+            className="w-full px-3 py-2 text-sm border rounded-md bg-background"
+          />
+        </div>
+        ) : (
         <div className="space-y-1.5">
           <Label htmlFor="enc-data" className="text-xs">{t('encrypt.dataSourceDir')}</Label>
           <div className="flex gap-2">
@@ -303,6 +392,7 @@ const EncryptPage = ({ config }) => {
             </Button>
           </div>
         </div>
+        )}
 
         <div className="space-y-1.5">
           <Label htmlFor="enc-carrierDir" className="text-xs">{t('encrypt.carrierDir')}</Label>
@@ -383,6 +473,71 @@ const EncryptPage = ({ config }) => {
           </div>
         </div>
 
+        <div className="space-y-1.5">
+          <Label htmlFor="enc-keyfile" className="text-xs">{t('encrypt.keyfile')}</Label>
+          <div className="flex gap-2">
+            <Input
+              id="enc-keyfile"
+              value={formData.keyfilePath}
+              onChange={(e) => setFormData({ ...formData, keyfilePath: e.target.value })}
+              disabled={isRunning}
+              className="h-9 text-sm flex-1"
+            />
+            <Button
+              variant="outline"
+              size="sm"
+              onClick={async () => {
+                const result = await OpenFileDialog("");
+                if (result) setFormData({ ...formData, keyfilePath: result });
+              }}
+              disabled={isRunning}
+              className="h-9 px-3"
+              title={t('encrypt.selectFile')}
+            >
+              <Icons.FileText />
+            </Button>
+          </div>
+        </div>
+
+        <div className="space-y-1.5">
+          <Label htmlFor="enc-recipients" className="text-xs">{t('encrypt.recipients')}</Label>
+          <Input
+            id="enc-recipients"
+            placeholder={t('encrypt.recipientsPlaceholder')}
+            value={formData.recipients}
+            onChange={(e) => setFormData({ ...formData, recipients: e.target.value })}
+            disabled={isRunning}
+            className="h-9 text-sm"
+          />
+        </div>
+
+        <div className="grid grid-cols-2 gap-2">
+          <div className="space-y-1.5">
+            <Label htmlFor="enc-shareThreshold" className="text-xs">{t('encrypt.shareThreshold')}</Label>
+            <Input
+              id="enc-shareThreshold"
+              type="number"
+              min="2"
+              value={formData.shareThreshold}
+              onChange={(e) => setFormData({ ...formData, shareThreshold: e.target.value })}
+              disabled={isRunning}
+              className="h-9 text-sm"
+            />
+          </div>
+          <div className="space-y-1.5">
+            <Label htmlFor="enc-shareCount" className="text-xs">{t('encrypt.shareCount')}</Label>
+            <Input
+              id="enc-shareCount"
+              type="number"
+              min="2"
+              value={formData.shareCount}
+              onChange={(e) => setFormData({ ...formData, shareCount: e.target.value })}
+              disabled={isRunning}
+              className="h-9 text-sm"
+            />
+          </div>
+        </div>
+
         <div className="space-y-1.5">
           <Label htmlFor="enc-outputName" className="text-xs">{t('encrypt.outputFileName')}</Label>
           <Input
@@ -396,7 +551,7 @@ const EncryptPage = ({ config }) => {
         </div>
 
         <div className="flex gap-2">
-          <Button onClick={handleStart} disabled={isRunning || !formData.dataSourcePath} size="sm">
+          <Button onClick={handleStart} disabled={isRunning || (sourceMode === 'file' ? !formData.dataSourcePath : !formData.text)} size="sm">
             {t('encrypt.start')}
           </Button>
           <Button variant="outline" onClick={handleCancel} disabled={!isRunning} size="sm">
@@ -412,6 +567,15 @@ const EncryptPage = ({ config }) => {
             </p>
           </>
         )}
+
+        {shares.length > 0 && (
+          <div className="space-y-1.5">
+            <Label className="text-xs">{t('encrypt.sharesResult')}</Label>
+            <pre className="text-xs p-2 border rounded-md bg-background whitespace-pre-wrap break-all select-text">
+              {shares.join('\n')}
+            </pre>
+          </div>
+        )}
       </CardContent>
       </Card>
     </div>
@@ -424,6 +588,8 @@ const DecryptPage = ({ config }) => {
     imagePath: '',
     outputDir: config.defaultOutputDir || '',
     password: config.defaultDecryptPassword || '',
+    keyfilePath: '',
+    shares: '',
   });
   const [progress, setProgress] = React.useState(0);
   const [status, setStatus] = React.useState('');
@@ -431,11 +597,26 @@ const DecryptPage = ({ config }) => {
   const [task, setTask] = React.useState(null);
   const [isRunning, setIsRunning] = React.useState(false);
   const [showPassword, setShowPassword] = React.useState(false);
+  const [text, setText] = React.useState(null);
 
   React.useEffect(() => {
     const handler = (p) => {
       setProgress(p.progress);
-      setStatus(p.error || p.message);
+      let message = progressMessage(t, p);
+      if (p.done && p.decryptResult && p.decryptResult.payloadType === 'text') {
+        GetDecryptedText(p.taskId).then(setText).catch(() => setText(null));
+      }
+      const sig = p.decryptResult && p.decryptResult.signatureStatus;
+      if (sig && sig !== 'none') {
+        message += ' · ' + t(`decrypt.signature.${sig}`, {
+          signer: p.decryptResult.signerName || p.decryptResult.signerKeyId || '',
+        });
+      }
+      const files = p.decryptResult && p.decryptResult.files;
+      if (files && files.length && p.decryptResult.payloadType !== 'text') {
+        message += ' · ' + t('decrypt.filesVerified', { count: files.length });
+      }
+      setStatus(message);
       setStatusType(p.error ? 'error' : 'info');
       if (p.done) {
         setIsRunning(false);
@@ -452,10 +633,12 @@ const DecryptPage = ({ config }) => {
       setStatus(t('decrypt.starting'));
       setStatusType('info');
       setIsRunning(true);
+      setText(null);
       logAction('decrypt', '开始解密', `图片路径: ${formData.imagePath}`);
 
       const taskId = await StartDecrypt({
         ...formData,
+        shares: formData.shares.split(/\s+/).filter(Boolean),
         identifier: 'stego',
       });
       setTask(taskId);
@@ -565,6 +748,45 @@ const DecryptPage = ({ config }) => {
           </div>
         </div>
 
+        <div className="space-y-1.5">
+          <Label htmlFor="dec-keyfile" className="text-xs">{t('decrypt.keyfile')}</Label>
+          <div className="flex gap-2">
+            <Input
+              id="dec-keyfile"
+              value={formData.keyfilePath}
+              onChange={(e) => setFormData({ ...formData, keyfilePath: e.target.value })}
+              disabled={isRunning}
+              className="h-9 text-sm flex-1"
+            />
+            <Button
+              variant="outline"
+              size="sm"
+              onClick={async () => {
+                const result = await OpenFileDialog("");
+                if (result) setFormData({ ...formData, keyfilePath: result });
+              }}
+              disabled={isRunning}
+              className="h-9 px-3"
+              title={t('decrypt.selectFile')}
+            >
+              <Icons.FileText />
+            </Button>
+          </div>
+        </div>
+
+        <div className="space-y-1.5">
+          <Label htmlFor="dec-shares" className="text-xs">{t('decrypt.shares')}</Label>
+          <textarea
+            id="dec-shares"
+            rows={2}
+            placeholder="stego-share-..."
+            value={formData.shares}
+            onChange={(e) => setFormData({ ...formData, shares: e.target.value })}
+            disabled={isRunning}
+            className="w-full px-3 py-2 text-sm border rounded-md bg-background font-mono"
+          />
+        </div>
+
         <div className="flex gap-2">
           <Button onClick={handleStart} disabled={isRunning || !formData.imagePath} size="sm">
             {t('decrypt.start')}
@@ -582,6 +804,24 @@ const DecryptPage = ({ config }) => {
             </p>
           </>
         )}
+
+        {text !== null && (
+          <div className="space-y-1.5">
+            <div className="flex items-center justify-between">
+              <Label htmlFor="dec-text" className="text-xs">{t('decrypt.textResult')}</Label>
+              <Button variant="outline" size="sm" onClick={() => navigator.clipboard.writeText(text)} className="h-7 px-2 text-xs">
+                {t('decrypt.copyText')}
+              </Button>
+            </div>
+            <textarea
+              id="dec-text"
+              rows={6}
+              readOnly
+              value={text}
+              className="w-full px-3 py-2 text-sm border rounded-md bg-background select-text"
+            />
+          </div>
+        )}
       </CardContent>
     </Card>
   );
@@ -1057,8 +1297,19 @@ const SettingsPage = ({ config, onConfigChange }) => {
     defaultEncryptOutputName: config.defaultEncryptOutputName || '',
     defaultEncryptPassword: config.defaultEncryptPassword || '',
     defaultDecryptPassword: config.defaultDecryptPassword || '',
+    defaultIdentity: config.defaultIdentity || '',
+    defaultSigningKey: config.defaultSigningKey || '',
+    trustedSigners: config.trustedSigners || '',
+    defaultCipher: config.defaultCipher || 'AES-GCM',
+    defaultEcc: config.defaultEcc || '32',
+    defaultCompression: config.defaultCompression || 'zstd',
+    kdfTargetMs: config.kdfTargetMs || '1000',
+    kdfMaxMemoryMB: config.kdfMaxMemoryMB || '256',
   });
   const [status, setStatus] = React.useState('');
+  const [kdfStatus, setKdfStatus] = React.useState('');
+  const [publicKey, setPublicKey] = React.useState('');
+  const [signingPublicKey, setSigningPublicKey] = React.useState('');
   const [showEncPassword, setShowEncPassword] = React.useState(false);
   const [showDecPassword, setShowDecPassword] = React.useState(false);
 
@@ -1074,6 +1325,45 @@ const SettingsPage = ({ config, onConfigChange }) => {
     }
   };
 
+  const handleGenerateKeyPair = async () => {
+    try {
+      const pair = await GenerateKeyPair();
+      setFormData({ ...formData, defaultIdentity: pair.privateKey });
+      setPublicKey(pair.publicKey);
+      logAction('config', '生成密钥对', pair.publicKey);
+    } catch (e) {
+      setStatus(String(e));
+    }
+  };
+
+  const handleGenerateSigningKey = async () => {
+    try {
+      const pair = await GenerateSigningKeyPair();
+      setFormData({ ...formData, defaultSigningKey: pair.privateKey });
+      setSigningPublicKey(pair.publicKey);
+      logAction('config', '生成签名密钥对', pair.publicKey);
+    } catch (e) {
+      setStatus(String(e));
+    }
+  };
+
+  const handleCalibrate = async () => {
+    try {
+      setKdfStatus(t('settings.kdfCalibrating'));
+      await SaveConfig(formData);
+      const res = await CalibrateKDF();
+      setKdfStatus(t('settings.kdfCalibrated', {
+        time: res.time,
+        memory: Math.round(res.memoryKiB / 1024),
+        threads: res.threads,
+        duration: res.durationMs,
+      }));
+      logAction('config', 'KDF 校准', `t=${res.time} m=${res.memoryKiB}KiB p=${res.threads}`);
+    } catch (e) {
+      setKdfStatus(String(e));
+    }
+  };
+
   return (
     <Card className="h-full flex flex-col">
       <CardHeader className="pb-3">
@@ -1183,6 +1473,135 @@ const SettingsPage = ({ config, onConfigChange }) => {
           </div>
         </div>
 
+        <div className="space-y-1.5">
+          <Label htmlFor="cfg-identity" className="text-xs">{t('settings.defaultIdentity')}</Label>
+          <div className="flex gap-2">
+            <Input
+              id="cfg-identity"
+              type="password"
+              value={formData.defaultIdentity}
+              onChange={(e) => setFormData({ ...formData, defaultIdentity: e.target.value })}
+              className="h-9 text-sm flex-1"
+            />
+            <Button variant="outline" size="sm" onClick={handleGenerateKeyPair} className="h-9 px-3">
+              {t('settings.generateKeyPair')}
+            </Button>
+          </div>
+          {publicKey && (
+            <p className="text-xs text-muted-foreground break-all select-text">{t('settings.publicKey')}: {publicKey}</p>
+          )}
+        </div>
+
+        <div className="space-y-1.5">
+          <Label htmlFor="cfg-signingKey" className="text-xs">{t('settings.defaultSigningKey')}</Label>
+          <div className="flex gap-2">
+            <Input
+              id="cfg-signingKey"
+              type="password"
+              value={formData.defaultSigningKey}
+              onChange={(e) => setFormData({ ...formData, defaultSigningKey: e.target.value })}
+              className="h-9 text-sm flex-1"
+            />
+            <Button variant="outline" size="sm" onClick={handleGenerateSigningKey} className="h-9 px-3">
+              {t('settings.generateKeyPair')}
+            </Button>
+          </div>
+          {signingPublicKey && (
+            <p className="text-xs text-muted-foreground break-all select-text">{t('settings.publicKey')}: {signingPublicKey}</p>
+          )}
+        </div>
+
+        <div className="space-y-1.5">
+          <Label htmlFor="cfg-trustedSigners" className="text-xs">{t('settings.trustedSigners')}</Label>
+          <textarea
+            id="cfg-trustedSigners"
+            rows={3}
+            placeholder="alice=stego-sign-pub-..."
+            value={formData.trustedSigners}
+            onChange={(e) => setFormData({ ...formData, trustedSigners: e.target.value })}
+            className="w-full px-3 py-2 text-sm border rounded-md bg-background font-mono"
+          />
+        </div>
+
+        <div className="space-y-1.5">
+          <Label htmlFor="cfg-cipher" className="text-xs">{t('settings.defaultCipher')}</Label>
+          <select
+            id="cfg-cipher"
+            value={formData.defaultCipher}
+            onChange={(e) => setFormData({ ...formData, defaultCipher: e.target.value })}
+            className="w-full h-9 px-2 text-sm border rounded-md bg-background"
+          >
+            <option value="AES-GCM">AES-256-GCM</option>
+            <option value="ChaCha20-Poly1305">ChaCha20-Poly1305</option>
+            <option value="XChaCha20-Poly1305">XChaCha20-Poly1305</option>
+          </select>
+        </div>
+
+        <div className="space-y-1.5">
+          <Label htmlFor="cfg-ecc" className="text-xs">{t('settings.defaultEcc')}</Label>
+          <select
+            id="cfg-ecc"
+            value={formData.defaultEcc}
+            onChange={(e) => setFormData({ ...formData, defaultEcc: e.target.value })}
+            className="w-full h-9 px-2 text-sm border rounded-md bg-background"
+          >
+            {['16', '32', '64', '128'].map((parity) => (
+              <option key={parity} value={parity}>
+                {t('settings.eccOption', { parity, fix: Number(parity) / 2 })}
+              </option>
+            ))}
+            <option value="RS(128,96)">{t('settings.eccShortened')}</option>
+            {['50', '100'].map((overhead) => (
+              <option key={overhead} value={`LT(${overhead})`}>
+                {t('settings.eccFountain', { overhead })}
+              </option>
+            ))}
+          </select>
+        </div>
+
+        <div className="space-y-1.5">
+          <Label htmlFor="cfg-compression" className="text-xs">{t('settings.defaultCompression')}</Label>
+          <select
+            id="cfg-compression"
+            value={formData.defaultCompression}
+            onChange={(e) => setFormData({ ...formData, defaultCompression: e.target.value })}
+            className="w-full h-9 px-2 text-sm border rounded-md bg-background"
+          >
+            <option value="none">{t('settings.compressionNone')}</option>
+            <option value="deflate">Deflate</option>
+            <option value="zstd">Zstandard</option>
+            <option value="xz">XZ</option>
+          </select>
+          <p className="text-xs text-muted-foreground">{t('settings.compressionHint')}</p>
+        </div>
+
+        <div className="grid grid-cols-2 gap-2">
+          <div className="space-y-1.5">
+            <Label htmlFor="cfg-kdfTarget" className="text-xs">{t('settings.kdfTargetMs')}</Label>
+            <Input
+              id="cfg-kdfTarget"
+              type="number"
+              value={formData.kdfTargetMs}
+              onChange={(e) => setFormData({ ...formData, kdfTargetMs: e.target.value })}
+              className="h-9 text-sm"
+            />
+          </div>
+          <div className="space-y-1.5">
+            <Label htmlFor="cfg-kdfMemory" className="text-xs">{t('settings.kdfMaxMemoryMB')}</Label>
+            <Input
+              id="cfg-kdfMemory"
+              type="number"
+              value={formData.kdfMaxMemoryMB}
+              onChange={(e) => setFormData({ ...formData, kdfMaxMemoryMB: e.target.value })}
+              className="h-9 text-sm"
+            />
+          </div>
+        </div>
+        <div className="flex gap-2 items-center">
+          <Button variant="outline" onClick={handleCalibrate} size="sm">{t('settings.kdfCalibrate')}</Button>
+          {kdfStatus && <p className="text-xs text-muted-foreground">{kdfStatus}</p>}
+        </div>
+
         <div className="flex gap-2 items-center">
           <Button onClick={handleSave} size="sm">{t('settings.save')}</Button>
           {status && <p className="text-xs text-muted-foreground">{status}</p>}
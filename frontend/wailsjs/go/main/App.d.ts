@@ -3,6 +3,8 @@
 import {models} from '../models';
 import {log} from '../models';
 
+export function CalibrateKDF():Promise<models.KDFCalibration>;
+
 export function CancelDecrypt(arg1:string):Promise<void>;
 
 export function CancelEncrypt(arg1:string):Promise<void>;
@@ -15,10 +17,16 @@ export function ExportLogs(arg1:string,arg2:number,arg3:number):Promise<string>;
 
 export function ExportLogsToFile(arg1:string,arg2:number,arg3:number):Promise<string>;
 
+export function GenerateKeyPair():Promise<models.KeyPair>;
+
+export function GenerateSigningKeyPair():Promise<models.KeyPair>;
+
 export function GetAppInfo():Promise<models.AppInfo>;
 
 export function GetConfig():Promise<Record<string, string>>;
 
+export function GetDecryptedText(arg1:string):Promise<string>;
+
 export function GetLogs(arg1:string,arg2:number,arg3:number,arg4:number,arg5:number):Promise<Array<log.Entry>>;
 
 export function GetLogsCount():Promise<number>;
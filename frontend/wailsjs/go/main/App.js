@@ -2,6 +2,10 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function CalibrateKDF() {
+  return window['go']['main']['App']['CalibrateKDF']();
+}
+
 export function CancelDecrypt(arg1) {
   return window['go']['main']['App']['CancelDecrypt'](arg1);
 }
@@ -26,6 +30,14 @@ export function ExportLogsToFile(arg1, arg2, arg3) {
   return window['go']['main']['App']['ExportLogsToFile'](arg1, arg2, arg3);
 }
 
+export function GenerateKeyPair() {
+  return window['go']['main']['App']['GenerateKeyPair']();
+}
+
+export function GenerateSigningKeyPair() {
+  return window['go']['main']['App']['GenerateSigningKeyPair']();
+}
+
 export function GetAppInfo() {
   return window['go']['main']['App']['GetAppInfo']();
 }
@@ -34,6 +46,10 @@ export function GetConfig() {
   return window['go']['main']['App']['GetConfig']();
 }
 
+export function GetDecryptedText(arg1) {
+  return window['go']['main']['App']['GetDecryptedText'](arg1);
+}
+
 export function GetLogs(arg1, arg2, arg3, arg4, arg5) {
   return window['go']['main']['App']['GetLogs'](arg1, arg2, arg3, arg4, arg5);
 }
@@ -72,6 +72,9 @@ export namespace models {
 	    imagePath: string;
 	    outputDir: string;
 	    password: string;
+	    keyfilePath: string;
+	    identity: string;
+	    shares: string[];
 	    identifier: string;
 	
 	    static createFrom(source: any = {}) {
@@ -83,16 +86,28 @@ export namespace models {
 	        this.imagePath = source["imagePath"];
 	        this.outputDir = source["outputDir"];
 	        this.password = source["password"];
+	        this.keyfilePath = source["keyfilePath"];
+	        this.identity = source["identity"];
+	        this.shares = source["shares"];
 	        this.identifier = source["identifier"];
 	    }
 	}
 	export class EncryptRequest {
 	    dataSourcePath: string;
+	    text: string;
 	    carrierDir: string;
 	    carrierImagePath: string;
 	    outputDir: string;
 	    outputFileName: string;
 	    password: string;
+	    keyfilePath: string;
+	    recipients: string[];
+	    signingKey: string;
+	    shareCount: number;
+	    shareThreshold: number;
+	    cipher: string;
+	    ecc: string;
+	    compression: string;
 	    scatter?: boolean;
 	    identifier: string;
 	    autoSelectCarrier: boolean;
@@ -105,11 +120,20 @@ export namespace models {
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
 	        this.dataSourcePath = source["dataSourcePath"];
+	        this.text = source["text"];
 	        this.carrierDir = source["carrierDir"];
 	        this.carrierImagePath = source["carrierImagePath"];
 	        this.outputDir = source["outputDir"];
 	        this.outputFileName = source["outputFileName"];
 	        this.password = source["password"];
+	        this.keyfilePath = source["keyfilePath"];
+	        this.recipients = source["recipients"];
+	        this.signingKey = source["signingKey"];
+	        this.shareCount = source["shareCount"];
+	        this.shareThreshold = source["shareThreshold"];
+	        this.cipher = source["cipher"];
+	        this.ecc = source["ecc"];
+	        this.compression = source["compression"];
 	        this.scatter = source["scatter"];
 	        this.identifier = source["identifier"];
 	        this.autoSelectCarrier = source["autoSelectCarrier"];
@@ -138,6 +162,42 @@ export namespace models {
 	        this.noiseEnabled = source["noiseEnabled"];
 	    }
 	}
+	export class KDFCalibration {
+	    algorithm: string;
+	    time: number;
+	    memoryKiB: number;
+	    threads: number;
+	    targetMs: number;
+	    durationMs: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new KDFCalibration(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.algorithm = source["algorithm"];
+	        this.time = source["time"];
+	        this.memoryKiB = source["memoryKiB"];
+	        this.threads = source["threads"];
+	        this.targetMs = source["targetMs"];
+	        this.durationMs = source["durationMs"];
+	    }
+	}
+	export class KeyPair {
+	    publicKey: string;
+	    privateKey: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new KeyPair(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.publicKey = source["publicKey"];
+	        this.privateKey = source["privateKey"];
+	    }
+	}
 
 }
 
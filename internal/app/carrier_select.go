@@ -3,6 +3,7 @@ package app
 import (
 	"context"
 	"errors"
+	"fmt"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
@@ -10,7 +11,9 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 
 	"golang.org/x/image/draw"
 
@@ -31,7 +34,8 @@ func selectCarrierImage(ctx context.Context, eng *engine.Engine, carrierDir stri
 		capacity int
 		score    float64
 	}
-	var best *cand
+	var cands []cand
+	tooSmall := false
 	for _, e := range entries {
 		if e.IsDir() {
 			continue
@@ -57,27 +61,61 @@ func selectCarrierImage(ctx context.Context, eng *engine.Engine, carrierDir stri
 		}
 		capacity := eng.CalculateMaxCapacity(cfg.Width, cfg.Height, false)
 		if requiredBytes > capacity {
+			tooSmall = true
 			continue
 		}
-		score := 0.0
-		if !preferLargest {
-			score = quickTextureScore(path, 256)
+		cands = append(cands, cand{path: path, capacity: capacity})
+	}
+
+	// Texture scoring decodes every candidate in full, so spread it across
+	// GOMAXPROCS workers; the pick below still walks the directory order.
+	if !preferLargest {
+		next := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < runtime.GOMAXPROCS(0) && w < len(cands); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					cands[i].score = quickTextureScore(cands[i].path, 256)
+				}
+			}()
+		}
+	feed:
+		for i := range cands {
+			select {
+			case next <- i:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		close(next)
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			return "", err
 		}
-		c := cand{path: path, capacity: capacity, score: score}
+	}
+
+	var best *cand
+	for i := range cands {
+		c := &cands[i]
 		if best == nil {
-			best = &c
+			best = c
 			continue
 		}
 		if preferLargest {
 			if c.capacity > best.capacity {
-				best = &c
+				best = c
 			}
 			continue
 		}
 		if c.score > best.score {
-			best = &c
+			best = c
 		}
 	}
+	if best == nil && tooSmall {
+		return "", fmt.Errorf("%w: no carrier image is large enough", ErrCapacity)
+	}
 	if best == nil {
 		return "", errors.New("no suitable carrier image found")
 	}
@@ -0,0 +1,191 @@
+package app
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	CompressionNone    = "none"
+	CompressionDeflate = "deflate"
+	CompressionZstd    = "zstd"
+	CompressionXZ      = "xz"
+)
+
+const (
+	entropySampleSize = 64 * 1024
+	// Data sampled above this many bits per byte is treated as already
+	// compressed or encrypted and stored as is.
+	entropySkipThreshold = 7.5
+)
+
+func normalizeCompression(s string) (string, error) {
+	switch v := strings.ToLower(strings.TrimSpace(s)); v {
+	case "", CompressionNone:
+		return CompressionNone, nil
+	case CompressionDeflate, CompressionZstd, CompressionXZ:
+		return v, nil
+	default:
+		return "", fmt.Errorf("unsupported compression: %q", s)
+	}
+}
+
+// sampleEntropy estimates the Shannon entropy of r in bits per byte from
+// samples taken at its start, middle and end.
+func sampleEntropy(r io.ReaderAt, size int64) (float64, error) {
+	var counts [256]int64
+	var total int64
+	buf := make([]byte, entropySampleSize)
+	offsets := []int64{0}
+	if size > 3*entropySampleSize {
+		offsets = append(offsets, size/2-entropySampleSize/2, size-entropySampleSize)
+	}
+	for _, off := range offsets {
+		n, err := r.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		for _, b := range buf[:n] {
+			counts[b]++
+		}
+		total += int64(n)
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	var h float64
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / float64(total)
+			h -= p * math.Log2(p)
+		}
+	}
+	return h, nil
+}
+
+// compressDataSource compresses src into a temporary file. It returns src
+// unchanged with CompressionNone when the data looks incompressible or the
+// result would not be smaller; otherwise src is closed and replaced.
+// onProgress receives the source bytes compressed so far.
+func compressDataSource(ctx context.Context, src *dataSource, algo string, onProgress func(done, total int64)) (*dataSource, string, error) {
+	if algo == CompressionNone || src.size == 0 {
+		return src, CompressionNone, nil
+	}
+	entropy, err := sampleEntropy(src, src.size)
+	if err != nil {
+		return nil, "", err
+	}
+	if entropy > entropySkipThreshold {
+		return src, CompressionNone, nil
+	}
+
+	f, err := os.CreateTemp("", "stego-*.cmp")
+	if err != nil {
+		return nil, "", err
+	}
+	out := &dataSource{File: f, temp: true, manifest: src.manifest}
+	fail := func(err error) (*dataSource, string, error) {
+		_ = out.Close()
+		return nil, "", err
+	}
+	cw, err := newCompressor(algo, f)
+	if err != nil {
+		return fail(err)
+	}
+	r := &progressReader{r: &contextReader{ctx: ctx, r: io.NewSectionReader(src, 0, src.size)}, total: src.size, fn: onProgress}
+	if _, err := io.Copy(cw, r); err != nil {
+		return fail(err)
+	}
+	if err := cw.Close(); err != nil {
+		return fail(err)
+	}
+	if out.size, err = f.Seek(0, io.SeekCurrent); err != nil {
+		return fail(err)
+	}
+	if out.size >= src.size {
+		_ = out.Close()
+		return src, CompressionNone, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	_ = src.Close()
+	return out, algo, nil
+}
+
+// compressBytes is the in-memory counterpart of compressDataSource.
+func compressBytes(data []byte, algo string) ([]byte, string, error) {
+	if algo == CompressionNone || len(data) == 0 {
+		return data, CompressionNone, nil
+	}
+	entropy, err := sampleEntropy(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, "", err
+	}
+	if entropy > entropySkipThreshold {
+		return data, CompressionNone, nil
+	}
+	var buf bytes.Buffer
+	cw, err := newCompressor(algo, &buf)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := cw.Write(data); err != nil {
+		return nil, "", err
+	}
+	if err := cw.Close(); err != nil {
+		return nil, "", err
+	}
+	if buf.Len() >= len(data) {
+		return data, CompressionNone, nil
+	}
+	return buf.Bytes(), algo, nil
+}
+
+func newCompressor(algo string, w io.Writer) (io.WriteCloser, error) {
+	switch algo {
+	case CompressionDeflate:
+		return flate.NewWriter(w, flate.BestCompression)
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBetterCompression), zstd.WithEncoderConcurrency(1))
+	case CompressionXZ:
+		return xz.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unsupported compression: %q", algo)
+	}
+}
+
+// newDecompressor wraps r according to the algorithm recorded in the
+// container metadata. The returned closer releases decoder resources.
+func newDecompressor(algo string, r io.Reader) (io.Reader, func(), error) {
+	switch algo {
+	case "", CompressionNone:
+		return r, func() {}, nil
+	case CompressionDeflate:
+		fr := flate.NewReader(r)
+		return fr, func() { _ = fr.Close() }, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	case CompressionXZ:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return xr, func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported compression: %q", algo)
+	}
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"stego/internal/crypto"
+	"stego/internal/engine"
+)
+
+var containerAADContext = []byte("stego-container-aad-v1")
+
+type container struct {
+	meta       encryptMetadata
+	raw        []byte
+	headerEnd  int
+	salt       []byte
+	nonce      []byte
+	tag        []byte
+	ciphertext []byte
+	signed     []byte
+	signature  []byte
+}
+
+func marshalContainerHeader(meta encryptMetadata, salt, nonce []byte) ([]byte, error) {
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, engine.MetadataLengthSize, engine.MetadataLengthSize+len(metaJSON)+len(salt)+len(nonce))
+	binary.LittleEndian.PutUint32(out, uint32(len(metaJSON)))
+	out = append(out, metaJSON...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	return out, nil
+}
+
+func containerAAD(meta encryptMetadata, header []byte) []byte {
+	if !meta.AAD {
+		return nil
+	}
+	return append(append([]byte{}, containerAADContext...), header...)
+}
+
+func parseContainer(data []byte) (*container, error) {
+	if len(data) < engine.MetadataLengthSize {
+		return nil, errors.New("data format invalid: metadata length missing")
+	}
+	metaLen := int(binary.LittleEndian.Uint32(data[:engine.MetadataLengthSize]))
+	metaEnd := engine.MetadataLengthSize + metaLen
+	if metaEnd > len(data) {
+		return nil, errors.New("data format invalid: metadata length out of range")
+	}
+
+	c := &container{raw: data}
+	if err := json.Unmarshal(data[engine.MetadataLengthSize:metaEnd], &c.meta); err != nil {
+		return nil, err
+	}
+	m := c.meta
+	if m.SaltLength < 0 || m.NonceLength < 0 || m.TagLength < 0 || m.SignatureLength < 0 {
+		return nil, errors.New("data format invalid: negative field length")
+	}
+	if m.SignatureLength > 0 {
+		if len(data)-metaEnd < m.SignatureLength {
+			return nil, errors.New("signature incomplete")
+		}
+		c.signed = data[:len(data)-m.SignatureLength]
+		c.signature = data[len(data)-m.SignatureLength:]
+		data = c.signed
+	}
+	tagLen := m.TagLength
+	if m.Stream {
+		if m.ChunkSize <= 0 {
+			return nil, errors.New("data format invalid: stream chunk size missing")
+		}
+		if m.ChunkSize > crypto.MaxStreamChunkSize {
+			return nil, errors.New("data format invalid: stream chunk size out of range")
+		}
+		tagLen = 0
+	}
+	encrypted := data[metaEnd:]
+	if len(encrypted) < m.SaltLength+m.NonceLength+tagLen {
+		return nil, errors.New("encrypted payload incomplete")
+	}
+	c.salt = encrypted[:m.SaltLength]
+	c.nonce = encrypted[m.SaltLength : m.SaltLength+m.NonceLength]
+	c.tag = encrypted[m.SaltLength+m.NonceLength : m.SaltLength+m.NonceLength+tagLen]
+	c.ciphertext = encrypted[m.SaltLength+m.NonceLength+tagLen:]
+	c.headerEnd = metaEnd + m.SaltLength + m.NonceLength
+	return c, nil
+}
+
+func (c *container) aad() []byte {
+	return containerAAD(c.meta, c.raw[:c.headerEnd])
+}
+
+// enableStream switches the metadata to chunked STREAM encryption: the stored
+// nonce becomes a per-container prefix and every chunk carries its own tag.
+func (m *encryptMetadata) enableStream(spec crypto.CipherSpec, chunkSize int) {
+	m.Stream = true
+	m.ChunkSize = chunkSize
+	m.NonceLength = crypto.StreamNoncePrefixSize(spec)
+}
+
+func (m encryptMetadata) sealedLength(plainLen int64) int64 {
+	if m.Stream {
+		return crypto.StreamCiphertextSize(plainLen, m.ChunkSize, m.TagLength)
+	}
+	return plainLen + int64(m.TagLength)
+}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"bytes"
+	"testing"
+
+	"stego/internal/crypto"
+	"stego/internal/models"
+)
+
+func sealTestContainer(t *testing.T, meta encryptMetadata, key, salt, nonce, plain []byte) []byte {
+	t.Helper()
+	spec, err := crypto.LookupCipher(meta.Algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := marshalContainerHeader(meta, salt, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, tag, err := spec.Seal(key, nonce, plain, containerAAD(meta, header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(append(header, tag...), ct...)
+}
+
+func TestContainerAADDetectsMetadataTampering(t *testing.T) {
+	spec, _ := crypto.LookupCipher(crypto.CipherAESGCM)
+	meta := encryptMetadata{
+		Algorithm:        spec.Name,
+		KeyLength:        spec.KeySize,
+		SaltLength:       16,
+		NonceLength:      spec.NonceSize,
+		TagLength:        spec.TagSize,
+		KDF:              crypto.KDFPBKDF2SHA1,
+		PBKDF2Iterations: 1000,
+		AAD:              true,
+	}
+	key := bytes.Repeat([]byte{1}, spec.KeySize)
+	salt := bytes.Repeat([]byte{2}, 16)
+	nonce := bytes.Repeat([]byte{3}, spec.NonceSize)
+	plain := []byte("authenticated header")
+
+	good := sealTestContainer(t, meta, key, salt, nonce, plain)
+	c, err := parseContainer(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := spec.Open(key, c.nonce, c.ciphertext, c.tag, c.aad()); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("untampered container failed to open: %v", err)
+	}
+
+	tampered := meta
+	tampered.PBKDF2Iterations = 2000
+	header, _ := marshalContainerHeader(tampered, salt, nonce)
+	forged := append(append(header, c.tag...), c.ciphertext...)
+	fc, err := parseContainer(forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := spec.Open(key, fc.nonce, fc.ciphertext, fc.tag, fc.aad()); err == nil {
+		t.Fatalf("expected tampered metadata to fail authentication")
+	}
+}
+
+func TestContainerSignatureRejectsForgery(t *testing.T) {
+	signer, _ := crypto.GenerateSigningKey()
+	spec, _ := crypto.LookupCipher(crypto.CipherChaCha20Poly1305)
+	meta := encryptMetadata{
+		Algorithm:   spec.Name,
+		KeyLength:   spec.KeySize,
+		NonceLength: spec.NonceSize,
+		TagLength:   spec.TagSize,
+		AAD:         true,
+	}
+	k, err := applySigner(&meta, signer.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := sealTestContainer(t, meta, bytes.Repeat([]byte{1}, spec.KeySize), nil, bytes.Repeat([]byte{3}, spec.NonceSize), []byte("x"))
+	data = append(data, k.Sign(data)...)
+
+	c, err := parseContainer(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res models.DecryptResult
+	if err := verifyContainerSignature(c, nil, &res); err != nil || res.SignatureStatus != SignatureUntrusted {
+		t.Fatalf("expected valid untrusted signature, got %v %+v", err, res)
+	}
+
+	data[len(data)-1] ^= 0xFF
+	c, err = parseContainer(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyContainerSignature(c, nil, &res); err == nil || res.SignatureStatus != SignatureInvalid {
+		t.Fatalf("expected invalid signature, got %v %+v", err, res)
+	}
+}
+
+func TestParseContainerRejectsOversizedChunkSize(t *testing.T) {
+	spec, _ := crypto.LookupCipher(crypto.CipherAESGCM)
+	meta := encryptMetadata{
+		Algorithm:  spec.Name,
+		KeyLength:  spec.KeySize,
+		SaltLength: 16,
+		TagLength:  spec.TagSize,
+		AAD:        true,
+	}
+	meta.enableStream(spec, 1<<31-1)
+	header, err := marshalContainerHeader(meta, bytes.Repeat([]byte{2}, 16), bytes.Repeat([]byte{3}, meta.NonceLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseContainer(append(header, make([]byte, 64)...)); err == nil {
+		t.Fatalf("expected an oversized chunk size to be rejected")
+	}
+	key := bytes.Repeat([]byte{1}, spec.KeySize)
+	if _, err := crypto.NewStreamReader(spec, key, bytes.Repeat([]byte{3}, meta.NonceLength), nil, meta.ChunkSize, bytes.NewReader(nil)); err == nil {
+		t.Fatalf("expected the stream reader to refuse an oversized chunk size")
+	}
+}
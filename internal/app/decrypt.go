@@ -2,28 +2,33 @@ package app
 
 import (
 	"context"
-	"encoding/binary"
-	"encoding/json"
-	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"stego/internal/config"
-	"stego/internal/crypto"
 	"stego/internal/engine"
 	"stego/internal/models"
 )
 
-func RunDecrypt(ctx context.Context, cfg map[string]string, req models.DecryptRequest, emit func(models.ProgressEvent), taskID string, logf PerfLogger) error {
+func RunDecrypt(ctx context.Context, cfg map[string]string, req models.DecryptRequest, emit func(models.ProgressEvent), taskID string, logf PerfLogger) (res models.DecryptResult, err error) {
 	if emit == nil {
 		emit = func(models.ProgressEvent) {}
 	}
+	prog := newProgressReporter(emit)
 	startAll := time.Now()
 	ok := false
 	defer func() {
+		// Every error return, cancellation included, ends the event stream;
+		// an invalid signature comes with what was verified so far.
+		if err != nil && res.SignatureStatus == SignatureInvalid {
+			prog.failWith(err, &res)
+		} else if err != nil {
+			prog.fail(err)
+		}
 		logPerf(logf, "decrypt", taskID, "Total", time.Since(startAll), fmt.Sprintf("ok=%t", ok))
 	}()
 	password := strings.TrimSpace(req.Password)
@@ -39,94 +44,179 @@ func RunDecrypt(ctx context.Context, cfg map[string]string, req models.DecryptRe
 		identifier = "stego"
 	}
 
-	emit(models.ProgressEvent{Progress: 0, Message: "读取图片..."})
+	secret, usesKeyfile, err := resolveSecret(password, req.KeyfilePath)
+	if err != nil {
+		return res, err
+	}
+
+	prog.enter(models.StageRead, "读取图片...", 0, 5)
 	t0 := time.Now()
-	rgb, w, h, err := engine.LoadImageRGB(req.ImagePath)
+	rows, err := engine.OpenImageRows(req.ImagePath)
 	if err != nil {
-		emit(models.ProgressEvent{Progress: 0, Error: err.Error(), Done: true})
-		return err
+		return res, err
 	}
+	defer func() { _ = rows.Close() }()
+	w, h := rows.Size()
 	logPerf(logf, "decrypt", taskID, "LoadImage", time.Since(t0), fmt.Sprintf("w=%d h=%d", w, h))
 	if err := ctx.Err(); err != nil {
-		return err
+		return res, err
 	}
 
-	emit(models.ProgressEvent{Progress: 20, Message: "提取数据..."})
+	identity := strings.TrimSpace(req.Identity)
+	if identity == "" {
+		identity = cfg[config.KeyDefaultIdentity]
+	}
+	opts := OpenOptions{
+		Secret:          secret,
+		Keyfile:         usesKeyfile,
+		Identity:        identity,
+		Shares:          req.Shares,
+		TrustedSigners:  cfg[config.KeyTrustedSigners],
+		MaxKDFMemoryKiB: kdfMaxMemoryKiB(cfg),
+		Logf:            logf,
+		TaskID:          taskID,
+	}
+
+	prog.enter(models.StageExtract, "提取数据...", 5, 40)
 	eng := engine.New(1024 * 1024)
+	eng.OnProgress = prog.bytes
+	eng.CheckKey = func(ctx context.Context, kc []byte) error {
+		prog.enter(models.StageKDF, "派生密钥...", 5, 10)
+		t0 := time.Now()
+		if err := opts.CheckKey(ctx, kc); err != nil {
+			return err
+		}
+		logPerf(logf, "decrypt", taskID, "KeyCheck", time.Since(t0), "")
+		prog.enter(models.StageExtract, "提取数据...", 10, 40)
+		return nil
+	}
 	t0 = time.Now()
-	extracted, _, _, _, err := eng.Extract(rgb, w, h, password)
+	report, err := eng.ExtractStreamContext(ctx, rows, secret)
 	if err != nil {
-		emit(models.ProgressEvent{Progress: 20, Error: err.Error(), Done: true})
-		return err
+		return res, err
 	}
-	logPerf(logf, "decrypt", taskID, "Extract", time.Since(t0), fmt.Sprintf("bytes=%d", len(extracted)))
+	logPerf(logf, "decrypt", taskID, "Extract", time.Since(t0), fmt.Sprintf("bytes=%d crcValid=%t cropped=%t unreliableRanges=%d", len(report.Data), report.CRCValid, report.Cropped, len(report.Unreliable)))
 
-	emit(models.ProgressEvent{Progress: 40, Message: "纠错解码..."})
-	t0 = time.Now()
-	extracted, err = crypto.ECCUnwrapRS(extracted)
+	opened, err := Open(ctx, report, opts, &res, prog.forward)
 	if err != nil {
-		emit(models.ProgressEvent{Progress: 40, Error: err.Error(), Done: true})
-		return err
+		return res, err
 	}
-	logPerf(logf, "decrypt", taskID, "ECCUnwrap", time.Since(t0), fmt.Sprintf("bytes=%d", len(extracted)))
-	if len(extracted) < engine.MetadataLengthSize {
-		return errors.New("data format invalid: metadata length missing")
-	}
-	metaLen := int(binary.LittleEndian.Uint32(extracted[:engine.MetadataLengthSize]))
-	metaEnd := engine.MetadataLengthSize + metaLen
-	if metaLen < 0 || metaEnd > len(extracted) {
-		return errors.New("data format invalid: metadata length out of range")
+	defer opened.Close()
+	meta := opened.meta
+
+	t0 = time.Now()
+	if opened.Type() == PayloadText {
+		if res.Text, err = opened.ReadText(ctx); err != nil {
+			return res, err
+		}
+	} else {
+		outBase := filepath.Join(outputDir, "extracted")
+		if err := os.MkdirAll(outBase, 0o755); err != nil {
+			return res, err
+		}
+		outPath, err := writeDecryptedOutput(ctx, opened, outBase, identifier, req.ImagePath, opened.manifest, prog)
+		if err != nil {
+			return res, err
+		}
+		res.OutputPath = outPath
 	}
+	res.Files = opened.Files()
+	res.PayloadType = opened.Type()
+	logPerf(logf, "decrypt", taskID, "DecryptAndWrite", time.Since(t0), fmt.Sprintf("algorithm=%s stream=%t compression=%s", meta.Algorithm, meta.Stream, meta.Compression))
 
-	var meta encryptMetadata
-	if err := json.Unmarshal(extracted[engine.MetadataLengthSize:metaEnd], &meta); err != nil {
-		return err
-	}
-	encrypted := extracted[metaEnd:]
-	minSize := meta.SaltLength + meta.NonceLength + meta.TagLength
-	if len(encrypted) < minSize {
-		return errors.New("encrypted payload incomplete")
-	}
-	salt := encrypted[:meta.SaltLength]
-	nonce := encrypted[meta.SaltLength : meta.SaltLength+meta.NonceLength]
-	tag := encrypted[meta.SaltLength+meta.NonceLength : meta.SaltLength+meta.NonceLength+meta.TagLength]
-	ciphertext := encrypted[meta.SaltLength+meta.NonceLength+meta.TagLength:]
+	emit(models.ProgressEvent{Stage: models.StageDone, Progress: 100, Message: "完成", Done: true, DecryptResult: &res})
+	ok = true
+	return res, nil
+}
 
-	emit(models.ProgressEvent{Progress: 60, Message: "解密..."})
-	t0 = time.Now()
-	key := crypto.PBKDF2Compat(password, salt, meta.PBKDF2Iterations, meta.KeyLength)
-	logPerf(logf, "decrypt", taskID, "KDF", time.Since(t0), fmt.Sprintf("iters=%d keyLen=%d", meta.PBKDF2Iterations, meta.KeyLength))
-	t0 = time.Now()
-	plain, err := crypto.DecryptAESGCM(key, nonce, ciphertext, tag)
+// writeDecryptedOutput streams plaintext to a temporary file next to the final
+// destination, then unzips it or renames it once authentication succeeded.
+// With a manifest the original names, permissions and times are restored and
+// every file is checked against its recorded hash; legacy payloads are
+// sniffed for a zip header instead. Archives are unpacked into a staging
+// directory that only becomes the destination once every file verified, so
+// a mismatch leaves nothing behind. Unpacking reports to prog as the write
+// stage.
+func writeDecryptedOutput(ctx context.Context, plain io.Reader, outBase, identifier, imagePath string, manifest *payloadManifest, prog *progressReporter) (string, error) {
+	tmp, err := os.CreateTemp(outBase, ".stego-*.part")
 	if err != nil {
-		emit(models.ProgressEvent{Progress: 60, Error: err.Error(), Done: true})
-		return err
+		return "", err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	_, copyErr := io.Copy(tmp, &contextReader{ctx: ctx, r: plain})
+	if err := tmp.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	if copyErr != nil {
+		return "", copyErr
 	}
-	logPerf(logf, "decrypt", taskID, "Decrypt", time.Since(t0), fmt.Sprintf("plainBytes=%d", len(plain)))
+	prog.enter(models.StageWrite, "写出文件...", 80, 100)
 
-	outBase := filepath.Join(outputDir, "extracted")
-	if err := os.MkdirAll(outBase, 0o755); err != nil {
-		return err
+	var archive bool
+	if manifest != nil {
+		archive = manifest.Archive
+	} else {
+		head := make([]byte, len(zipMagic))
+		if f, err := os.Open(tmp.Name()); err == nil {
+			_, _ = io.ReadFull(f, head)
+			_ = f.Close()
+		}
+		archive = isZip(head)
 	}
-	emit(models.ProgressEvent{Progress: 80, Message: "写出文件..."})
-	t0 = time.Now()
-	if isZip(plain) {
-		dest := filepath.Join(outBase, identifier+"_"+filepath.Base(strings.TrimSuffix(req.ImagePath, filepath.Ext(req.ImagePath))))
-		if err := os.MkdirAll(dest, 0o755); err != nil {
-			return err
+	if archive {
+		dest := uniqueFilePath(filepath.Join(outBase, identifier+"_"+filepath.Base(strings.TrimSuffix(imagePath, filepath.Ext(imagePath)))))
+		staging, err := os.MkdirTemp(outBase, ".stego-*.dir")
+		if err != nil {
+			return "", err
 		}
-		if err := unzipToDir(plain, dest); err != nil {
-			return err
+		defer func() { _ = os.RemoveAll(staging) }()
+		if err := unzipToDir(tmp.Name(), staging, prog.bytes); err != nil {
+			return "", err
 		}
-	} else {
-		outFile := filepath.Join(outBase, filepath.Base(req.ImagePath)+"_extracted.bin")
-		if err := os.WriteFile(outFile, plain, 0o644); err != nil {
-			return err
+		if manifest != nil {
+			for _, item := range manifest.Items {
+				path, err := safeJoin(staging, item.Name)
+				if err != nil {
+					return "", err
+				}
+				if err := restoreItem(path, item); err != nil {
+					return "", err
+				}
+			}
+		}
+		if err := os.Chmod(staging, 0o755); err != nil {
+			return "", err
 		}
+		if err := os.Rename(staging, dest); err != nil {
+			return "", err
+		}
+		return dest, nil
+	}
+	outFile := filepath.Join(outBase, filepath.Base(imagePath)+"_extracted.bin")
+	if manifest != nil {
+		item := manifest.Items[0]
+		if name := filepath.Base(filepath.FromSlash(item.Name)); name != "." && name != ".." && name != string(os.PathSeparator) {
+			outFile = uniqueFilePath(filepath.Join(outBase, name))
+		}
+		if err := restoreItem(tmp.Name(), item); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(tmp.Name(), outFile); err != nil {
+		return "", err
 	}
-	logPerf(logf, "decrypt", taskID, "WriteOutput", time.Since(t0), "")
+	return outFile, nil
+}
 
-	emit(models.ProgressEvent{Progress: 100, Message: "完成", Done: true})
-	ok = true
-	return nil
+func erasureMask(n int, ranges []engine.ByteRange) []bool {
+	if len(ranges) == 0 {
+		return nil
+	}
+	mask := make([]bool, n)
+	for _, r := range ranges {
+		for i := r.Start; i < r.End && i < n; i++ {
+			mask[i] = true
+		}
+	}
+	return mask
 }
@@ -2,8 +2,7 @@ package app
 
 import (
 	"context"
-	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -23,16 +22,41 @@ type encryptMetadata struct {
 	SaltLength       int    `json:"salt_length"`
 	NonceLength      int    `json:"nonce_length"`
 	TagLength        int    `json:"tag_length"`
-	PBKDF2Iterations int    `json:"pbkdf2_iterations"`
+	KDF              string `json:"kdf,omitempty"`
+	PBKDF2Iterations int    `json:"pbkdf2_iterations,omitempty"`
+	Argon2Time       uint32 `json:"argon2_time,omitempty"`
+	Argon2MemoryKiB  uint32 `json:"argon2_memory_kib,omitempty"`
+	Argon2Threads    uint8  `json:"argon2_threads,omitempty"`
+	AAD              bool   `json:"aad,omitempty"`
+	Keyfile          bool   `json:"keyfile,omitempty"`
+	Stream           bool   `json:"stream,omitempty"`
+	ChunkSize        int    `json:"chunk_size,omitempty"`
+	Compression      string `json:"compression,omitempty"`
+	Manifest         bool   `json:"manifest,omitempty"`
+
+	KeyMode    string                   `json:"key_mode,omitempty"`
+	Recipients []crypto.RecipientStanza `json:"recipients,omitempty"`
+
+	ShareSetID     string `json:"share_set_id,omitempty"`
+	ShareThreshold int    `json:"share_threshold,omitempty"`
+
+	SignerKeyID     string `json:"signer_key_id,omitempty"`
+	SignerPublicKey string `json:"signer_public_key,omitempty"`
+	SignatureLength int    `json:"signature_length,omitempty"`
 }
 
-func RunEncrypt(ctx context.Context, cfg map[string]string, req models.EncryptRequest, emit func(models.ProgressEvent), taskID string, logf PerfLogger) error {
+func RunEncrypt(ctx context.Context, cfg map[string]string, req models.EncryptRequest, emit func(models.ProgressEvent), taskID string, logf PerfLogger) (res models.EncryptResult, err error) {
 	if emit == nil {
 		emit = func(models.ProgressEvent) {}
 	}
+	prog := newProgressReporter(emit)
 	startAll := time.Now()
 	ok := false
 	defer func() {
+		// Every error return, cancellation included, ends the event stream.
+		if err != nil {
+			prog.fail(err)
+		}
 		logPerf(logf, "encrypt", taskID, "Total", time.Since(startAll), fmt.Sprintf("ok=%t", ok))
 	}()
 	password := strings.TrimSpace(req.Password)
@@ -54,106 +78,139 @@ func RunEncrypt(ctx context.Context, cfg map[string]string, req models.EncryptRe
 			outputFileName = "encrypted"
 		}
 	}
+	cipherName := strings.TrimSpace(req.Cipher)
+	if cipherName == "" {
+		cipherName = cfg[config.KeyDefaultCipher]
+	}
+	if cipherName == "" {
+		cipherName = crypto.CipherAESGCM
+	}
+	spec, err := crypto.LookupCipher(cipherName)
+	if err != nil {
+		return res, err
+	}
+	eccSetting := strings.TrimSpace(req.ECC)
+	if eccSetting == "" {
+		eccSetting = cfg[config.KeyDefaultECC]
+	}
+	fecParams, err := crypto.ParseFEC(eccSetting)
+	if err != nil {
+		return res, err
+	}
+	compressionSetting := strings.TrimSpace(req.Compression)
+	if compressionSetting == "" {
+		compressionSetting = cfg[config.KeyDefaultCompression]
+	}
+	compression, err := normalizeCompression(compressionSetting)
+	if err != nil {
+		return res, err
+	}
 	scatter := true
 	if req.Scatter != nil {
 		scatter = *req.Scatter
 	}
 
-	emit(models.ProgressEvent{Progress: 0, Message: "读取数据源..."})
+	secret, usesKeyfile, err := resolveSecret(password, req.KeyfilePath)
+	if err != nil {
+		return res, err
+	}
+
+	prog.enter(models.StageRead, "读取数据源...", 0, 5)
 	t0 := time.Now()
-	data, _, err := readDataSource(ctx, req.DataSourcePath)
+	var src *dataSource
+	switch {
+	case req.Text != "" && strings.TrimSpace(req.DataSourcePath) != "":
+		err = errors.New("data source path and inline text cannot be combined")
+	case req.Text != "":
+		src, err = openTextSource(req.Text)
+	default:
+		src, err = openDataSource(ctx, req.DataSourcePath, prog.bytes)
+	}
 	if err != nil {
-		emit(models.ProgressEvent{Progress: 0, Error: err.Error(), Done: true})
-		return err
+		return res, err
 	}
-	logPerf(logf, "encrypt", taskID, "ReadDataSource", time.Since(t0), fmt.Sprintf("bytes=%d", len(data)))
+	defer func() { _ = src.Close() }()
+	logPerf(logf, "encrypt", taskID, "ReadDataSource", time.Since(t0), fmt.Sprintf("bytes=%d", src.size))
 	if err := ctx.Err(); err != nil {
-		return err
+		return res, err
 	}
 
-	cryptoCfg := crypto.DefaultAESGCMConfig()
-	metaProbe := encryptMetadata{
-		Algorithm:        "AES-GCM",
-		KeyLength:        cryptoCfg.KeyLength,
-		SaltLength:       cryptoCfg.SaltLength,
-		NonceLength:      cryptoCfg.NonceLen,
-		TagLength:        cryptoCfg.TagLen,
-		PBKDF2Iterations: cryptoCfg.Iterations,
+	if compression != CompressionNone {
+		prog.enter(models.StageCompress, "压缩数据...", 5, 10)
+		t0 = time.Now()
+		compressed, algo, err := compressDataSource(ctx, src, compression, prog.bytes)
+		if err != nil {
+			return res, err
+		}
+		logPerf(logf, "encrypt", taskID, "Compress", time.Since(t0), fmt.Sprintf("algorithm=%s bytes=%d->%d", algo, src.size, compressed.size))
+		src, compression = compressed, algo
 	}
-	metaJSON, _ := json.Marshal(metaProbe)
-	requiredPayloadBytes := estimateRequiredPayloadBytes(int64(len(data)), int64(len(metaJSON)), cryptoCfg.SaltLength, cryptoCfg.NonceLen, cryptoCfg.TagLen)
-	requiredBytesInCarrier := engine.HeaderLength + engine.IntegrityHashLen + int(requiredPayloadBytes) + engine.CRCLength
 
-	emit(models.ProgressEvent{Progress: 10, Message: "选择载体图片..."})
-	carrierPath := strings.TrimSpace(req.CarrierImagePath)
 	eng := engine.New(1024 * 1024)
+	eng.OnProgress = prog.bytes
+	signingKey := strings.TrimSpace(req.SigningKey)
+	if signingKey == "" {
+		signingKey = cfg[config.KeyDefaultSigningKey]
+	}
+	sealer, err := NewSealer(&Payload{r: src, size: src.size, manifest: src.manifest, compression: compression}, SealOptions{
+		Secret:         secret,
+		Keyfile:        usesKeyfile,
+		Cipher:         spec,
+		Crypto:         cryptoConfigFromSettings(cfg),
+		FEC:            fecParams,
+		ChunkSize:      eng.ChunkSize,
+		Recipients:     req.Recipients,
+		ShareCount:     req.ShareCount,
+		ShareThreshold: req.ShareThreshold,
+		SigningKey:     signingKey,
+	})
+	if err != nil {
+		return res, err
+	}
+	res.Shares = sealer.Shares()
+	requiredBytesInCarrier := sealer.RequiredBytes()
+
+	prog.enter(models.StageCarrier, "选择载体图片...", 10, 20)
+	carrierPath := strings.TrimSpace(req.CarrierImagePath)
 	if carrierPath == "" {
 		t0 = time.Now()
 		p, err := selectCarrierImage(ctx, eng, carrierDir, requiredBytesInCarrier, req.PreferLargestImage)
 		if err != nil {
-			emit(models.ProgressEvent{Progress: 10, Error: err.Error(), Done: true})
-			return err
+			return res, err
 		}
 		carrierPath = p
 		logPerf(logf, "encrypt", taskID, "SelectCarrierImage", time.Since(t0), "")
 	}
 
 	if err := ctx.Err(); err != nil {
-		return err
+		return res, err
 	}
-	emit(models.ProgressEvent{Progress: 20, Message: "加密并纠错编码..."})
 
 	t0 = time.Now()
-	salt, err := crypto.RandomBytes(cryptoCfg.SaltLength)
-	if err != nil {
-		return err
-	}
-	nonce, err := crypto.RandomBytes(cryptoCfg.NonceLen)
-	if err != nil {
-		return err
-	}
-	key := crypto.PBKDF2Compat(password, salt, cryptoCfg.Iterations, cryptoCfg.KeyLength)
-	ciphertext, tag, err := crypto.EncryptAESGCM(key, nonce, data)
-	if err != nil {
-		return err
-	}
-
-	meta := encryptMetadata{
-		Algorithm:        "AES-GCM",
-		KeyLength:        cryptoCfg.KeyLength,
-		SaltLength:       cryptoCfg.SaltLength,
-		NonceLength:      cryptoCfg.NonceLen,
-		TagLength:        cryptoCfg.TagLen,
-		PBKDF2Iterations: cryptoCfg.Iterations,
-	}
-	metaJSON, err = json.Marshal(meta)
-	if err != nil {
-		return err
-	}
-	metaLen := make([]byte, 4)
-	binary.LittleEndian.PutUint32(metaLen, uint32(len(metaJSON)))
-	fullData := append(append(append(append(metaLen, metaJSON...), salt...), nonce...), tag...)
-	fullData = append(fullData, ciphertext...)
-
-	wrapped, err := crypto.ECCWrapRS(fullData)
+	wrapped, err := sealer.Seal(ctx, func(stage string, done, total int64) {
+		switch stage {
+		case models.StageKDF:
+			prog.enter(stage, "派生密钥...", 20, 22)
+		case models.StageEncrypt:
+			prog.enter(stage, "加密...", 22, 40)
+		case models.StageFEC:
+			prog.enter(stage, "纠错编码...", 40, 50)
+		}
+		prog.bytes(done, total)
+	})
 	if err != nil {
-		return err
+		return res, err
 	}
-	logPerf(logf, "encrypt", taskID, "Encrypt+ECCWrap", time.Since(t0), fmt.Sprintf("wrappedBytes=%d", len(wrapped)))
+	logPerf(logf, "encrypt", taskID, "KDF+Encrypt+ECCWrap", time.Since(t0), fmt.Sprintf("wrappedBytes=%d ecc=%s %s", len(wrapped), fecParams, sealer.meta.kdfSummary()))
 
-	emit(models.ProgressEvent{Progress: 50, Message: "嵌入数据..."})
+	eng.KeyCheck = sealer.KeyCheck()
+	prog.enter(models.StageEmbed, "嵌入数据...", 50, 100)
 	t0 = time.Now()
-	rgb, w, h, err := engine.LoadImageRGB(carrierPath)
+	openCarrier, w, h, err := engine.OpenImageSource(carrierPath)
 	if err != nil {
-		return err
+		return res, err
 	}
 	logPerf(logf, "encrypt", taskID, "LoadCarrierImage", time.Since(t0), fmt.Sprintf("w=%d h=%d", w, h))
-	t0 = time.Now()
-	outRGB, _, err := eng.Hide(rgb, w, h, wrapped, password, scatter)
-	if err != nil {
-		return err
-	}
-	logPerf(logf, "encrypt", taskID, "Hide", time.Since(t0), "")
 
 	outFile := filepath.Join(outputDir, "encrypted", outputFileName)
 	if filepath.Ext(outFile) == "" {
@@ -161,20 +218,20 @@ func RunEncrypt(ctx context.Context, cfg map[string]string, req models.EncryptRe
 	}
 	outFile = uniqueFilePath(outFile)
 
-	if err := os.MkdirAll(filepath.Dir(outFile), 0o755); err != nil {
-		return err
-	}
-
-	emit(models.ProgressEvent{Progress: 90, Message: "保存图片..."})
 	t0 = time.Now()
-	if err := engine.SaveRGBAsPNG(outFile, outRGB, w, h); err != nil {
+	err = engine.WritePNGFile(outFile, w, h, func(dst engine.RowWriter) error {
+		_, err := eng.HideStreamContext(ctx, openCarrier, dst, wrapped, sealer.ScatterSecret(), scatter)
 		return err
+	})
+	if err != nil {
+		return res, err
 	}
-	logPerf(logf, "encrypt", taskID, "SavePNG", time.Since(t0), filepath.Base(outFile))
+	logPerf(logf, "encrypt", taskID, "Hide+SavePNG", time.Since(t0), filepath.Base(outFile))
 
-	emit(models.ProgressEvent{Progress: 100, Message: "完成", Done: true})
+	res.OutputPath = outFile
+	emit(models.ProgressEvent{Stage: models.StageDone, Progress: 100, Message: "完成", Done: true, EncryptResult: &res})
 	ok = true
-	return nil
+	return res, nil
 }
 
 func uniqueFilePath(path string) string {
@@ -192,10 +249,7 @@ func uniqueFilePath(path string) string {
 	return path
 }
 
-func estimateRequiredPayloadBytes(plainLen int64, metaJSONLen int64, saltLen int, nonceLen int, tagLen int) int64 {
-	fullDataLen := int64(4) + metaJSONLen + int64(saltLen) + int64(nonceLen) + int64(tagLen) + plainLen
-	framedLen := int64(4) + fullDataLen
-	blocks := (framedLen + crypto.RSK - 1) / crypto.RSK
-	wrappedLen := int64(3+2+2+4) + blocks*int64(crypto.RSK+crypto.RSNSym)
-	return wrappedLen + 256
+func estimateRequiredPayloadBytes(plainLen int64, metaJSONLen int64, meta encryptMetadata, fec crypto.FECParams) int64 {
+	fullDataLen := int64(4) + metaJSONLen + int64(meta.SaltLength+meta.NonceLength+meta.SignatureLength) + meta.sealedLength(plainLen)
+	return fec.WrappedLen(fullDataLen) + 256
 }
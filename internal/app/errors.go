@@ -0,0 +1,54 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"stego/internal/crypto"
+	"stego/internal/engine"
+	"stego/internal/models"
+)
+
+var (
+	// ErrWrongPassword means the password fails the key check stored ahead
+	// of the payload, or the container is intact but the password, keyfile
+	// or key shares do not unlock it.
+	ErrWrongPassword = engine.ErrWrongPassword
+	// ErrKeyRequired means the image needs a keyfile, identity or key
+	// shares that were not given.
+	ErrKeyRequired = errors.New("key required")
+	// ErrKDFLimit means the image asks for more key derivation memory than
+	// the configured budget allows, or more PBKDF2 iterations than
+	// crypto.MaxPBKDF2Iterations.
+	ErrKDFLimit = errors.New("key derivation exceeds the resource limit")
+
+	ErrNoPayload = engine.ErrNoPayload
+	ErrCorrupted = engine.ErrCorrupted
+	ErrCapacity  = engine.ErrCapacity
+)
+
+// ErrorCode classifies err as one of the models.ErrorCode constants.
+func ErrorCode(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.Canceled):
+		return models.ErrorCodeCancelled
+	case errors.Is(err, ErrWrongPassword), errors.Is(err, crypto.ErrNoMatchingIdentity):
+		return models.ErrorCodeWrongPassword
+	case errors.Is(err, ErrKeyRequired), errors.Is(err, engine.ErrPasswordRequired):
+		return models.ErrorCodeKeyRequired
+	case errors.Is(err, ErrCorrupted), errors.Is(err, ErrManifestHashMismatch):
+		return models.ErrorCodeCorrupted
+	case errors.Is(err, ErrNoPayload):
+		return models.ErrorCodeNoPayload
+	case errors.Is(err, ErrCapacity):
+		return models.ErrorCodeCapacity
+	case errors.Is(err, errSignatureInvalid):
+		return models.ErrorCodeSignatureInvalid
+	case errors.Is(err, ErrKDFLimit):
+		return models.ErrorCodeKDFLimit
+	default:
+		return models.ErrorCodeUnknown
+	}
+}
@@ -14,13 +14,18 @@ import (
 	"stego/internal/models"
 )
 
-func RunGenerateCarrier(ctx context.Context, req models.GenerateRequest, emit func(models.ProgressEvent), taskID string, logf PerfLogger) error {
+func RunGenerateCarrier(ctx context.Context, req models.GenerateRequest, emit func(models.ProgressEvent), taskID string, logf PerfLogger) (err error) {
 	if emit == nil {
 		emit = func(models.ProgressEvent) {}
 	}
 	startAll := time.Now()
 	ok := false
+	progress := 0
 	defer func() {
+		// Every error return, cancellation included, ends the event stream.
+		if err != nil {
+			emit(models.ProgressEvent{Stage: models.StageGenerate, Progress: progress, Error: err.Error(), ErrorCode: ErrorCode(err), Done: true})
+		}
 		logPerf(logf, "generate", taskID, "Total", time.Since(startAll), fmt.Sprintf("ok=%t count=%d targetBytes=%d", ok, req.Count, req.TargetBytes))
 	}()
 
@@ -55,13 +60,12 @@ func RunGenerateCarrier(ctx context.Context, req models.GenerateRequest, emit fu
 			return ctx.Err()
 		default:
 		}
-		progress := int(float64(i-1) / float64(req.Count) * 100)
-		emit(models.ProgressEvent{Progress: progress, Current: i - 1, Total: req.Count, Message: "生成图片..."})
+		progress = int(float64(i-1) / float64(req.Count) * 100)
+		emit(models.ProgressEvent{Stage: models.StageGenerate, Progress: progress, Current: i - 1, Total: req.Count, Message: "生成图片..."})
 
 		t0 := time.Now()
 		res, err := generator.GenerateCarrierPNG(req.TargetBytes, seedBase+int64(i), req.NoiseEnabled)
 		if err != nil {
-			emit(models.ProgressEvent{Progress: progress, Error: err.Error(), Done: true})
 			return err
 		}
 		genTotal += time.Since(t0)
@@ -74,7 +78,7 @@ func RunGenerateCarrier(ctx context.Context, req models.GenerateRequest, emit fu
 		writeTotal += time.Since(t0)
 	}
 
-	emit(models.ProgressEvent{Progress: 100, Current: req.Count, Total: req.Count, Message: "完成", Done: true})
+	emit(models.ProgressEvent{Stage: models.StageDone, Progress: 100, Current: req.Count, Total: req.Count, Message: "完成", Done: true})
 	if req.Count > 0 {
 		logPerf(logf, "generate", taskID, "GenerateTotal", genTotal, fmt.Sprintf("count=%d avg=%s", req.Count, formatDuration(genTotal/time.Duration(req.Count))))
 		logPerf(logf, "generate", taskID, "WriteTotal", writeTotal, fmt.Sprintf("count=%d avg=%s", req.Count, formatDuration(writeTotal/time.Duration(req.Count))))
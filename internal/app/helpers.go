@@ -14,27 +14,125 @@ import (
 
 var zipMagic = []byte{'P', 'K', 0x03, 0x04}
 
-func readDataSource(ctx context.Context, dataSourcePath string) ([]byte, string, error) {
+type dataSource struct {
+	*os.File
+	size     int64
+	temp     bool
+	manifest payloadManifest
+}
+
+// openDataSource opens a file for streaming, or zips a directory into a
+// temporary file first so the payload never has to sit in memory. Either way
+// the source is hashed into its manifest.
+func openDataSource(ctx context.Context, dataSourcePath string, onProgress func(done, total int64)) (*dataSource, error) {
 	info, err := os.Stat(dataSourcePath)
 	if err != nil {
-		return nil, "", err
+		return nil, err
+	}
+	if !info.IsDir() {
+		f, err := os.Open(dataSourcePath)
+		if err != nil {
+			return nil, err
+		}
+		src := &dataSource{File: f, size: info.Size(), manifest: payloadManifest{Version: manifestVersion, Type: PayloadBinary}}
+		r := &progressReader{r: &contextReader{ctx: ctx, r: f}, total: info.Size(), fn: onProgress}
+		item, err := newManifestItem(info.Name(), info.ModTime(), info.Mode(), r)
+		if err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			_ = src.Close()
+			return nil, err
+		}
+		src.manifest.Items = []manifestItem{item}
+		return src, nil
+	}
+	f, err := os.CreateTemp("", "stego-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	src := &dataSource{File: f, temp: true, manifest: payloadManifest{Version: manifestVersion, Type: PayloadBinary, Archive: true}}
+	if src.manifest.Items, err = zipDirectory(ctx, dataSourcePath, f, onProgress); err != nil {
+		_ = src.Close()
+		return nil, err
+	}
+	if src.size, err = f.Seek(0, io.SeekCurrent); err != nil {
+		_ = src.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		_ = src.Close()
+		return nil, err
+	}
+	return src, nil
+}
+
+// openTextSource stages inline text in a temporary file so it flows through
+// the same compression and encryption path as file payloads.
+func openTextSource(text string) (*dataSource, error) {
+	manifest, err := newTextManifest(text)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp("", "stego-*.txt")
+	if err != nil {
+		return nil, err
+	}
+	src := &dataSource{File: f, temp: true, size: int64(len(text)), manifest: manifest}
+	if _, err := io.WriteString(f, text); err == nil {
+		_, err = f.Seek(0, io.SeekStart)
 	}
-	baseName := strings.TrimSuffix(filepath.Base(dataSourcePath), filepath.Ext(dataSourcePath))
-	if info.IsDir() {
-		b, err := zipDirectory(ctx, dataSourcePath)
-		return b, filepath.Base(dataSourcePath), err
+	if err != nil {
+		_ = src.Close()
+		return nil, err
+	}
+	return src, nil
+}
+
+func (d *dataSource) Close() error {
+	err := d.File.Close()
+	if d.temp {
+		_ = os.Remove(d.Name())
 	}
-	b, err := os.ReadFile(dataSourcePath)
-	return b, baseName, err
+	return err
 }
 
-func zipDirectory(ctx context.Context, dir string) ([]byte, error) {
-	var buf bytes.Buffer
-	zw := zip.NewWriter(&buf)
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// zipDirectory writes dir to dst as a zip archive and returns a manifest item
+// for every file it contains. onProgress receives the file bytes zipped so
+// far out of the directory's total.
+func zipDirectory(ctx context.Context, dir string, dst io.Writer, onProgress func(done, total int64)) ([]manifestItem, error) {
+	zw := zip.NewWriter(dst)
 	defer func() { _ = zw.Close() }()
 
 	root := filepath.Clean(dir)
+	var total int64
 	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	progress := &progressReader{total: total, fn: onProgress}
+
+	var items []manifestItem
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -71,25 +169,33 @@ func zipDirectory(ctx context.Context, dir string) ([]byte, error) {
 			return err
 		}
 		defer func() { _ = f.Close() }()
-		if _, err := io.Copy(w, f); err != nil {
+		progress.r = io.TeeReader(f, w)
+		item, err := newManifestItem(rel, info.ModTime(), info.Mode(), progress)
+		if err != nil {
 			return err
 		}
+		items = append(items, item)
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
-	if err := zw.Close(); err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+	return items, zw.Close()
 }
 
-func unzipToDir(data []byte, outDir string) error {
-	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+// unzipToDir extracts zipPath into outDir. onProgress receives the
+// uncompressed bytes written so far out of the archive's total.
+func unzipToDir(zipPath string, outDir string, onProgress func(done, total int64)) error {
+	r, err := zip.OpenReader(zipPath)
 	if err != nil {
 		return err
 	}
+	defer func() { _ = r.Close() }()
+	var total int64
+	for _, f := range r.File {
+		total += int64(f.UncompressedSize64)
+	}
+	progress := &progressReader{total: total, fn: onProgress}
 	for _, f := range r.File {
 		dest := filepath.Join(outDir, filepath.FromSlash(f.Name))
 		if !strings.HasPrefix(filepath.Clean(dest), filepath.Clean(outDir)+string(os.PathSeparator)) {
@@ -113,7 +219,8 @@ func unzipToDir(data []byte, outDir string) error {
 			_ = rc.Close()
 			return err
 		}
-		_, copyErr := io.Copy(out, rc)
+		progress.r = rc
+		_, copyErr := io.Copy(out, progress)
 		_ = out.Close()
 		_ = rc.Close()
 		if copyErr != nil {
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"context"
+	"strings"
+
+	"stego/internal/config"
+	"stego/internal/crypto"
+	"stego/internal/engine"
+	"stego/internal/models"
+)
+
+// RunInspect reports what an image carries: its capacity, the embedding
+// flags and the container metadata. The payload itself is never decrypted;
+// the password only locates scattered data. The partial result is returned
+// alongside any error.
+func RunInspect(ctx context.Context, cfg map[string]string, req models.InspectRequest) (models.InspectResult, error) {
+	var res models.InspectResult
+	password := strings.TrimSpace(req.Password)
+	if password == "" {
+		password = cfg[config.KeyDefaultDecryptPassword]
+	}
+	secret, _, err := resolveSecret(password, req.KeyfilePath)
+	if err != nil {
+		return res, err
+	}
+	rows, err := engine.OpenImageRows(req.ImagePath)
+	if err != nil {
+		return res, err
+	}
+	defer func() { _ = rows.Close() }()
+	w, h := rows.Size()
+	eng := engine.New(1024 * 1024)
+	res.Width, res.Height = w, h
+	res.CapacityBytes = eng.CalculateMaxCapacity(w, h, true)
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+
+	report, err := eng.ExtractStreamContext(ctx, rows, secret)
+	if err != nil {
+		return res, err
+	}
+	res.EmbeddedBytes = len(report.Data)
+	res.Scatter = report.Scatter
+	res.CRCValid = report.CRCValid
+	res.Cropped = report.Cropped
+	for _, r := range report.Unreliable {
+		res.UnreliableBytes += r.End - r.Start
+	}
+	if !report.CRCValid && !crypto.IsFECWrapped(report.Data) {
+		return res, engine.ErrCRCMismatch
+	}
+	res.PayloadFound = true
+
+	data := report.Data
+	if fec, ok := crypto.DetectFEC(data); ok {
+		res.FEC = fec.String()
+	}
+	data, err = crypto.FECUnwrapContext(ctx, data, erasureMask(len(data), report.Unreliable))
+	if err != nil {
+		return res, err
+	}
+	c, err := parseContainer(data)
+	if err != nil {
+		return res, err
+	}
+	m := c.meta
+	res.Algorithm = m.Algorithm
+	res.KDF = m.kdfSummary()
+	res.Stream = m.Stream
+	res.ChunkSize = m.ChunkSize
+	res.Compression = m.Compression
+	res.Manifest = m.Manifest
+	res.Keyfile = m.Keyfile
+	res.KeyMode = m.KeyMode
+	res.Recipients = len(m.Recipients)
+	res.ShareThreshold = m.ShareThreshold
+	res.SignerKeyID = m.SignerKeyID
+	return res, nil
+}
@@ -0,0 +1,150 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"stego/internal/config"
+	"stego/internal/crypto"
+	"stego/internal/models"
+)
+
+func cryptoConfigFromSettings(cfg map[string]string) crypto.AESGCMConfig {
+	c := crypto.DefaultAESGCMConfig()
+	p := crypto.Argon2Params{
+		Time:      uint32(parseUintSetting(cfg[config.KeyKDFArgon2Time], 32)),
+		MemoryKiB: uint32(parseUintSetting(cfg[config.KeyKDFArgon2MemoryKiB], 32)),
+		Threads:   uint8(parseUintSetting(cfg[config.KeyKDFArgon2Threads], 8)),
+	}
+	if p.Validate() == nil && p.MemoryKiB <= kdfMaxMemoryKiB(cfg) {
+		c.Argon2 = p
+	}
+	return c
+}
+
+// defaultKDFMaxMemoryMB is the Argon2 memory budget when the setting is
+// unset.
+const defaultKDFMaxMemoryMB = 256
+
+// kdfMaxMemoryKiB is the Argon2 memory budget from the settings. Calibration
+// stays within it, and decryption refuses images that ask for more, as
+// their parameters are read from untrusted data.
+func kdfMaxMemoryKiB(cfg map[string]string) uint32 {
+	mb := parseUintSetting(cfg[config.KeyKDFMaxMemoryMB], 32)
+	if mb == 0 {
+		mb = defaultKDFMaxMemoryMB
+	}
+	return uint32(min(mb*1024, crypto.MaxArgon2MemoryKiB))
+}
+
+// checkKDFLimit rejects key derivation metadata that needs more Argon2
+// memory than maxMemKiB, or the default budget when it is zero, or more
+// PBKDF2 iterations than crypto.MaxPBKDF2Iterations, before any of the work
+// is done.
+func checkKDFLimit(meta encryptMetadata, maxMemKiB uint32) error {
+	switch meta.KDF {
+	case "", crypto.KDFPBKDF2SHA1:
+		if meta.PBKDF2Iterations > crypto.MaxPBKDF2Iterations {
+			return fmt.Errorf("%w: image asks for %d PBKDF2 iterations, limit is %d", ErrKDFLimit, meta.PBKDF2Iterations, crypto.MaxPBKDF2Iterations)
+		}
+	case crypto.KDFArgon2id:
+		if maxMemKiB == 0 {
+			maxMemKiB = defaultKDFMaxMemoryMB * 1024
+		}
+		if meta.Argon2MemoryKiB > maxMemKiB {
+			return fmt.Errorf("%w: image asks for %d MiB, limit is %d MiB", ErrKDFLimit, meta.Argon2MemoryKiB/1024, maxMemKiB/1024)
+		}
+	}
+	return nil
+}
+
+func CalibrateKDF(cfg map[string]string) (models.KDFCalibration, map[string]string, error) {
+	targetMs := parseUintSetting(cfg[config.KeyKDFTargetMs], 32)
+	if targetMs == 0 {
+		targetMs = 1000
+	}
+	p, est, err := crypto.CalibrateArgon2id(time.Duration(targetMs)*time.Millisecond, kdfMaxMemoryKiB(cfg), 0)
+	if err != nil {
+		return models.KDFCalibration{}, nil, err
+	}
+	res := models.KDFCalibration{
+		Algorithm:  crypto.KDFArgon2id,
+		Time:       p.Time,
+		MemoryKiB:  p.MemoryKiB,
+		Threads:    p.Threads,
+		TargetMs:   int64(targetMs),
+		DurationMs: est.Milliseconds(),
+	}
+	values := map[string]string{
+		config.KeyKDFArgon2Time:      strconv.FormatUint(uint64(p.Time), 10),
+		config.KeyKDFArgon2MemoryKiB: strconv.FormatUint(uint64(p.MemoryKiB), 10),
+		config.KeyKDFArgon2Threads:   strconv.FormatUint(uint64(p.Threads), 10),
+	}
+	return res, values, nil
+}
+
+func parseUintSetting(v string, bits int) uint64 {
+	n, err := strconv.ParseUint(v, 10, bits)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func newEncryptMetadata(cfg crypto.AESGCMConfig, spec crypto.CipherSpec) encryptMetadata {
+	meta := encryptMetadata{
+		Algorithm:   spec.Name,
+		KeyLength:   spec.KeySize,
+		SaltLength:  cfg.SaltLength,
+		NonceLength: spec.NonceSize,
+		TagLength:   spec.TagSize,
+		KDF:         cfg.KDF,
+		AAD:         true,
+	}
+	switch cfg.KDF {
+	case crypto.KDFArgon2id:
+		meta.Argon2Time = cfg.Argon2.Time
+		meta.Argon2MemoryKiB = cfg.Argon2.MemoryKiB
+		meta.Argon2Threads = cfg.Argon2.Threads
+	default:
+		meta.PBKDF2Iterations = cfg.Iterations
+	}
+	return meta
+}
+
+func deriveKey(ctx context.Context, password string, salt []byte, meta encryptMetadata) ([]byte, error) {
+	switch meta.KDF {
+	case "", crypto.KDFPBKDF2SHA1:
+		if meta.PBKDF2Iterations <= 0 {
+			return nil, fmt.Errorf("invalid pbkdf2 iterations: %d", meta.PBKDF2Iterations)
+		}
+		return crypto.PBKDF2CompatContext(ctx, password, salt, meta.PBKDF2Iterations, meta.KeyLength)
+	case crypto.KDFArgon2id:
+		return crypto.Argon2idKeyContext(ctx, password, salt, meta.argon2Params(), meta.KeyLength)
+	default:
+		return nil, fmt.Errorf("unsupported kdf: %s", meta.KDF)
+	}
+}
+
+func (m encryptMetadata) argon2Params() crypto.Argon2Params {
+	return crypto.Argon2Params{
+		Time:      m.Argon2Time,
+		MemoryKiB: m.Argon2MemoryKiB,
+		Threads:   m.Argon2Threads,
+	}
+}
+
+func (m encryptMetadata) kdfSummary() string {
+	switch m.KeyMode {
+	case keyModeX25519:
+		return fmt.Sprintf("keyMode=%s recipients=%d", m.KeyMode, len(m.Recipients))
+	case keyModeShamir:
+		return fmt.Sprintf("keyMode=%s threshold=%d", m.KeyMode, m.ShareThreshold)
+	}
+	if m.KDF == crypto.KDFArgon2id {
+		return fmt.Sprintf("kdf=%s t=%d m=%dKiB p=%d keyLen=%d", m.KDF, m.Argon2Time, m.Argon2MemoryKiB, m.Argon2Threads, m.KeyLength)
+	}
+	return fmt.Sprintf("kdf=%s iters=%d keyLen=%d", crypto.KDFPBKDF2SHA1, m.PBKDF2Iterations, m.KeyLength)
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"stego/internal/crypto"
+	"stego/internal/engine"
+)
+
+// A key check is stored ahead of a password-sealed payload (see
+// engine.Engine.KeyCheck) so decryption can turn away a wrong password
+// before extracting anything. It carries the KDF parameters and salt of the
+// container and 16 bits of a MAC keyed by the derived key, so testing a
+// password against it costs a full key derivation, as against the container
+// itself, and a right password's key is reused to open the container:
+//
+//	kdf(1) flags(1) keyLen(1) argon2Time(1) argon2Threads(1)
+//	argon2MemoryKiB or pbkdf2Iterations(4) salt(16) check(2)
+const (
+	keyCheckArgon2id = 1
+	keyCheckPBKDF2   = 2
+
+	keyCheckKeyfile = 1 << 0
+
+	keyCheckParamsLen = 9
+	keyCheckSaltLen   = 16
+	keyCheckValueLen  = 2
+)
+
+var keyCheckLabel = []byte("stego_key_check_v1")
+
+func keyCheckValue(key []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write(keyCheckLabel)
+	return m.Sum(nil)[:keyCheckValueLen]
+}
+
+// newKeyCheck returns the key check for a container whose key was derived
+// from a password and salt as meta describes, or nil when it has none.
+func newKeyCheck(meta encryptMetadata, salt, key []byte) []byte {
+	if meta.KeyMode != "" || len(salt) != keyCheckSaltLen || meta.KeyLength <= 0 || meta.KeyLength > 255 {
+		return nil
+	}
+	kc := make([]byte, 0, engine.KeyCheckLength)
+	var flags byte
+	if meta.Keyfile {
+		flags |= keyCheckKeyfile
+	}
+	switch meta.KDF {
+	case crypto.KDFArgon2id:
+		kc = append(kc, keyCheckArgon2id, flags, byte(meta.KeyLength), byte(meta.Argon2Time), meta.Argon2Threads)
+		kc = binary.LittleEndian.AppendUint32(kc, meta.Argon2MemoryKiB)
+	case "", crypto.KDFPBKDF2SHA1:
+		kc = append(kc, keyCheckPBKDF2, flags, byte(meta.KeyLength), 0, 0)
+		kc = binary.LittleEndian.AppendUint32(kc, uint32(meta.PBKDF2Iterations))
+	default:
+		return nil
+	}
+	kc = append(kc, salt...)
+	return append(kc, keyCheckValue(key)...)
+}
+
+// parseKeyCheck reads back the KDF parameters, salt and check value.
+func parseKeyCheck(kc []byte) (meta encryptMetadata, salt, value []byte, ok bool) {
+	if len(kc) != engine.KeyCheckLength {
+		return meta, nil, nil, false
+	}
+	meta.Keyfile = kc[1]&keyCheckKeyfile != 0
+	meta.KeyLength = int(kc[2])
+	switch kc[0] {
+	case keyCheckArgon2id:
+		meta.KDF = crypto.KDFArgon2id
+		meta.Argon2Time = uint32(kc[3])
+		meta.Argon2Threads = kc[4]
+		meta.Argon2MemoryKiB = binary.LittleEndian.Uint32(kc[5:9])
+	case keyCheckPBKDF2:
+		meta.KDF = crypto.KDFPBKDF2SHA1
+		meta.PBKDF2Iterations = int(binary.LittleEndian.Uint32(kc[5:9]))
+	default:
+		return meta, nil, nil, false
+	}
+	salt = kc[keyCheckParamsLen : keyCheckParamsLen+keyCheckSaltLen]
+	return meta, salt, kc[keyCheckParamsLen+keyCheckSaltLen:], true
+}
+
+// checkedKey is a key CheckKey derived and verified.
+type checkedKey struct {
+	meta encryptMetadata
+	salt []byte
+	key  []byte
+}
+
+// matches reports whether the container c derives its key the same way.
+func (k *checkedKey) matches(c *container) bool {
+	m := c.meta
+	kdf := m.KDF
+	if kdf == "" {
+		kdf = crypto.KDFPBKDF2SHA1
+	}
+	return m.KeyMode == "" && kdf == k.meta.KDF && m.KeyLength == k.meta.KeyLength &&
+		m.PBKDF2Iterations == k.meta.PBKDF2Iterations && m.argon2Params() == k.meta.argon2Params() &&
+		bytes.Equal(c.salt, k.salt)
+}
+
+// CheckKey tests the password against the key check an image stores ahead
+// of its payload; set it as engine.Engine.CheckKey before extracting. The
+// key it derives is kept for Open, so a right password is derived once.
+func (o *OpenOptions) CheckKey(ctx context.Context, kc []byte) error {
+	meta, salt, want, ok := parseKeyCheck(kc)
+	if !ok {
+		// A key check this version does not know is left to Open.
+		return nil
+	}
+	if meta.Keyfile && !o.Keyfile {
+		return fmt.Errorf("%w: image was encrypted with a keyfile", ErrKeyRequired)
+	}
+	if err := checkKDFLimit(meta, o.MaxKDFMemoryKiB); err != nil {
+		return err
+	}
+	key, err := deriveKey(ctx, o.Secret, salt, meta)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(keyCheckValue(key), want) {
+		return ErrWrongPassword
+	}
+	o.checked = &checkedKey{meta: meta, salt: append([]byte(nil), salt...), key: key}
+	return nil
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"stego/internal/crypto"
+)
+
+const (
+	keyModeX25519 = "x25519"
+	keyModeShamir = "shamir"
+)
+
+func wrapFileKeyForRecipients(meta *encryptMetadata, recipients []string) ([]byte, error) {
+	var parsed []*crypto.X25519Recipient
+	for _, s := range recipients {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		r, err := crypto.ParseX25519Recipient(s)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, r)
+	}
+	if len(parsed) == 0 {
+		return nil, errors.New("no valid recipients")
+	}
+
+	fileKey, err := crypto.RandomBytes(meta.KeyLength)
+	if err != nil {
+		return nil, err
+	}
+	stanzas := make([]crypto.RecipientStanza, 0, len(parsed))
+	for _, r := range parsed {
+		st, err := r.Wrap(fileKey)
+		if err != nil {
+			return nil, err
+		}
+		stanzas = append(stanzas, st)
+	}
+
+	meta.KeyMode = keyModeX25519
+	meta.Recipients = stanzas
+	meta.clearKDF()
+	return fileKey, nil
+}
+
+func splitFileKeyIntoShares(meta *encryptMetadata, count, threshold int) ([]byte, []string, error) {
+	fileKey, err := crypto.RandomBytes(meta.KeyLength)
+	if err != nil {
+		return nil, nil, err
+	}
+	shares, err := crypto.SplitSecret(fileKey, count, threshold)
+	if err != nil {
+		return nil, nil, err
+	}
+	first, err := crypto.ParseShare(shares[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	meta.KeyMode = keyModeShamir
+	meta.ShareSetID = first.SetID
+	meta.ShareThreshold = threshold
+	meta.clearKDF()
+	return fileKey, shares, nil
+}
+
+// clearKDF drops the password key derivation, keyfile included, from a
+// container whose file key is wrapped or split instead.
+func (m *encryptMetadata) clearKDF() {
+	m.Keyfile = false
+	m.KDF = ""
+	m.PBKDF2Iterations = 0
+	m.Argon2Time, m.Argon2MemoryKiB, m.Argon2Threads = 0, 0, 0
+	m.SaltLength = 0
+}
+
+func resolveSecret(password, keyfilePath string) (string, bool, error) {
+	keyfilePath = strings.TrimSpace(keyfilePath)
+	if keyfilePath == "" {
+		return password, false, nil
+	}
+	digest, err := crypto.HashKeyfile(keyfilePath)
+	if err != nil {
+		return "", false, err
+	}
+	return crypto.CompositeSecret(password, digest), true, nil
+}
+
+func unlockContentKey(ctx context.Context, password, identity string, shares []string, c *container) ([]byte, error) {
+	switch c.meta.KeyMode {
+	case "":
+		return deriveKey(ctx, password, c.salt, c.meta)
+	case keyModeX25519:
+		if strings.TrimSpace(identity) == "" {
+			return nil, fmt.Errorf("%w: image is encrypted to public-key recipients", ErrKeyRequired)
+		}
+		id, err := crypto.ParseX25519Identity(identity)
+		if err != nil {
+			return nil, err
+		}
+		fileKey, err := id.Unwrap(c.meta.Recipients)
+		if err != nil {
+			return nil, err
+		}
+		if len(fileKey) != c.meta.KeyLength {
+			return nil, errors.New("unwrapped key length invalid")
+		}
+		return fileKey, nil
+	case keyModeShamir:
+		if len(shares) == 0 {
+			return nil, fmt.Errorf("%w: image needs %d custodian shares", ErrKeyRequired, c.meta.ShareThreshold)
+		}
+		for _, s := range shares {
+			if strings.TrimSpace(s) == "" {
+				continue
+			}
+			sh, err := crypto.ParseShare(s)
+			if err != nil {
+				return nil, err
+			}
+			if sh.SetID != c.meta.ShareSetID {
+				return nil, errors.New("share does not belong to this image")
+			}
+		}
+		fileKey, err := crypto.CombineShares(shares)
+		if err != nil {
+			return nil, err
+		}
+		if len(fileKey) != c.meta.KeyLength {
+			return nil, errors.New("combined key length invalid")
+		}
+		return fileKey, nil
+	default:
+		return nil, errors.New("unsupported key mode: " + c.meta.KeyMode)
+	}
+}
@@ -0,0 +1,220 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"stego/internal/models"
+)
+
+const (
+	manifestVersion = 1
+	maxManifestLen  = 16 << 20
+	maxInlineText   = 1 << 20
+)
+
+const (
+	PayloadBinary = "binary"
+	PayloadText   = "text"
+)
+
+var ErrManifestHashMismatch = errors.New("restored file does not match manifest hash")
+
+// payloadManifest precedes the payload inside the encrypted stream and
+// describes what was hidden, so decryption can restore names, times and
+// permissions and verify every file.
+type payloadManifest struct {
+	Version int            `json:"version"`
+	Type    string         `json:"type,omitempty"`
+	Archive bool           `json:"archive,omitempty"`
+	Items   []manifestItem `json:"items"`
+}
+
+type manifestItem struct {
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mtime"`
+	Mode        uint32    `json:"mode"`
+	ContentType string    `json:"content_type,omitempty"`
+	SHA256      string    `json:"sha256"`
+}
+
+// newManifestItem hashes r while sniffing its content type. name is the
+// slash-separated path relative to the payload root.
+func newManifestItem(name string, modTime time.Time, mode fs.FileMode, r io.Reader) (manifestItem, error) {
+	h := sha256.New()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return manifestItem{}, err
+	}
+	head = head[:n]
+	h.Write(head)
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return manifestItem{}, err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(head)
+	}
+	return manifestItem{
+		Name:        name,
+		Size:        int64(n) + size,
+		ModTime:     modTime.UTC(),
+		Mode:        uint32(mode.Perm()),
+		ContentType: contentType,
+		SHA256:      hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// newTextManifest describes an inline text message.
+func newTextManifest(text string) (payloadManifest, error) {
+	if !utf8.ValidString(text) {
+		return payloadManifest{}, errors.New("text is not valid UTF-8")
+	}
+	if len(text) > maxInlineText {
+		return payloadManifest{}, fmt.Errorf("text exceeds %d bytes", maxInlineText)
+	}
+	sum := sha256.Sum256([]byte(text))
+	return payloadManifest{
+		Version: manifestVersion,
+		Type:    PayloadText,
+		Items: []manifestItem{{
+			Name:        "message.txt",
+			Size:        int64(len(text)),
+			ModTime:     time.Now().UTC(),
+			Mode:        0o644,
+			ContentType: "text/plain; charset=utf-8",
+			SHA256:      hex.EncodeToString(sum[:]),
+		}},
+	}, nil
+}
+
+// marshal encodes the manifest as a little-endian u32 length followed by JSON.
+func (m *payloadManifest) marshal() ([]byte, error) {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	out := binary.LittleEndian.AppendUint32(make([]byte, 0, 4+len(body)), uint32(len(body)))
+	return append(out, body...), nil
+}
+
+func readManifest(r io.Reader) (*payloadManifest, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	n := binary.LittleEndian.Uint32(lenBuf[:])
+	if n > maxManifestLen {
+		return nil, errors.New("manifest too large")
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	var m payloadManifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	if !m.Archive && len(m.Items) != 1 {
+		return nil, errors.New("manifest must describe exactly one file")
+	}
+	if m.Type == "" {
+		m.Type = PayloadBinary
+	}
+	if m.Type == PayloadText && (m.Archive || m.Items[0].Size > maxInlineText) {
+		return nil, errors.New("invalid text payload in manifest")
+	}
+	return &m, nil
+}
+
+// readTextPayload reads an inline text payload and checks it against the
+// manifest hash.
+func readTextPayload(ctx context.Context, r io.Reader, item manifestItem) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(&contextReader{ctx: ctx, r: r}, maxInlineText+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) != item.Size {
+		return "", fmt.Errorf("text payload is %d bytes, manifest says %d", len(data), item.Size)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != item.SHA256 {
+		return "", fmt.Errorf("%w: %s", ErrManifestHashMismatch, item.Name)
+	}
+	if !utf8.Valid(data) {
+		return "", errors.New("text payload is not valid UTF-8")
+	}
+	return string(data), nil
+}
+
+// restoreItem verifies path against the manifest hash and restores its
+// permissions and modification time.
+func restoreItem(path string, item manifestItem) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != item.SHA256 {
+		return fmt.Errorf("%w: %s", ErrManifestHashMismatch, item.Name)
+	}
+	if item.Mode != 0 {
+		if err := os.Chmod(path, fs.FileMode(item.Mode).Perm()); err != nil {
+			return err
+		}
+	}
+	if !item.ModTime.IsZero() {
+		return os.Chtimes(path, item.ModTime, item.ModTime)
+	}
+	return nil
+}
+
+// safeJoin resolves a slash-separated manifest name below root, rejecting
+// names that would escape it.
+func safeJoin(root, name string) (string, error) {
+	dest := filepath.Join(root, filepath.FromSlash(name))
+	if !strings.HasPrefix(filepath.Clean(dest), filepath.Clean(root)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("unsafe path in manifest: %q", name)
+	}
+	return dest, nil
+}
+
+func (m *payloadManifest) entries() []models.ManifestEntry {
+	out := make([]models.ManifestEntry, len(m.Items))
+	for i, it := range m.Items {
+		out[i] = models.ManifestEntry{
+			Name:        it.Name,
+			Size:        it.Size,
+			ModTime:     it.ModTime.Unix(),
+			Mode:        it.Mode,
+			ContentType: it.ContentType,
+			SHA256:      it.SHA256,
+		}
+	}
+	return out
+}
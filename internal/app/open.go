@@ -0,0 +1,203 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"stego/internal/crypto"
+	"stego/internal/engine"
+	"stego/internal/models"
+)
+
+// OpenOptions carries everything needed to unlock a container. Secret is
+// the password, or the composite secret when Keyfile is set; TrustedSigners
+// uses the same "name=key" lines as the settings page.
+type OpenOptions struct {
+	Secret         string
+	Keyfile        bool
+	Identity       string
+	Shares         []string
+	TrustedSigners string
+	// MaxKDFMemoryKiB caps the Argon2 memory the container may ask for;
+	// zero means the default budget.
+	MaxKDFMemoryKiB uint32
+
+	Logf   PerfLogger
+	TaskID string
+
+	// checked is the key CheckKey verified, if it ran.
+	checked *checkedKey
+}
+
+// Opened is the decrypted and decompressed payload of a container. Stream
+// ciphers authenticate chunk by chunk, so callers must discard what they
+// read if a later Read fails.
+type Opened struct {
+	io.Reader
+	manifest *payloadManifest
+	meta     encryptMetadata
+	close    func()
+}
+
+// Close releases decompressor resources.
+func (o *Opened) Close() { o.close() }
+
+// Files lists the manifest entries; it is nil for legacy containers.
+func (o *Opened) Files() []models.ManifestEntry {
+	if o.manifest == nil {
+		return nil
+	}
+	return o.manifest.entries()
+}
+
+// Type reports PayloadText or PayloadBinary, or "" for legacy containers.
+func (o *Opened) Type() string {
+	if o.manifest == nil {
+		return ""
+	}
+	return o.manifest.Type
+}
+
+// Archive reports whether the payload is a zip of the manifest files.
+func (o *Opened) Archive() bool { return o.manifest != nil && o.manifest.Archive }
+
+// ReadText reads an inline text payload and verifies it.
+func (o *Opened) ReadText(ctx context.Context) (string, error) {
+	if o.Type() != PayloadText {
+		return "", errors.New("payload is not text")
+	}
+	return readTextPayload(ctx, o, o.manifest.Items[0])
+}
+
+// Open unwraps the FEC layer of an extraction report, checks the signature
+// into res and returns the plaintext stream. Progress from 40% to 80% is
+// sent to emit; decryption progress continues while the stream is read.
+// The extracted blob and the container decoded from it are both held in
+// memory; only the plaintext is streamed.
+func Open(ctx context.Context, report *engine.ExtractReport, opts OpenOptions, res *models.DecryptResult, emit func(models.ProgressEvent)) (*Opened, error) {
+	prog := newProgressReporter(emit)
+	extracted := report.Data
+	if !report.CRCValid && !crypto.IsFECWrapped(extracted) {
+		return nil, engine.ErrCRCMismatch
+	}
+
+	prog.enter(models.StageFEC, "纠错解码...", 40, 55)
+	t0 := time.Now()
+	extracted, err := crypto.FECUnwrapContext(crypto.WithProgress(ctx, prog.bytes), extracted, erasureMask(len(extracted), report.Unreliable))
+	if err != nil {
+		switch {
+		case ctx.Err() != nil:
+		case !report.CRCValid:
+			err = fmt.Errorf("%w: %w", engine.ErrCRCMismatch, err)
+		default:
+			err = fmt.Errorf("%w: %w", ErrCorrupted, err)
+		}
+		return nil, err
+	}
+	logPerf(opts.Logf, "decrypt", opts.TaskID, "ECCUnwrap", time.Since(t0), fmt.Sprintf("bytes=%d", len(extracted)))
+	c, err := parseContainer(extracted)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+	meta := c.meta
+	if err := verifyContainerSignature(c, parseTrustedSigners(opts.TrustedSigners), res); err != nil {
+		return nil, err
+	}
+	spec, err := crypto.LookupCipher(meta.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	nonceLen := spec.NonceSize
+	if meta.Stream {
+		nonceLen = crypto.StreamNoncePrefixSize(spec)
+	}
+	if meta.KeyLength != spec.KeySize || meta.NonceLength != nonceLen || meta.TagLength != spec.TagSize {
+		return nil, fmt.Errorf("metadata parameters do not match %s", spec.Name)
+	}
+
+	prog.enter(models.StageKDF, "派生密钥...", 55, 60)
+	t0 = time.Now()
+	if meta.Keyfile && !opts.Keyfile {
+		return nil, fmt.Errorf("%w: image was encrypted with a keyfile", ErrKeyRequired)
+	}
+	if meta.KeyMode == "" {
+		if err := checkKDFLimit(meta, opts.MaxKDFMemoryKiB); err != nil {
+			return nil, err
+		}
+	}
+	var key []byte
+	if opts.checked != nil && opts.checked.matches(c) {
+		key = opts.checked.key
+	} else if key, err = unlockContentKey(ctx, opts.Secret, opts.Identity, opts.Shares, c); err != nil {
+		return nil, err
+	}
+	logPerf(opts.Logf, "decrypt", opts.TaskID, "KDF", time.Since(t0), meta.kdfSummary())
+	var plain io.Reader
+	if meta.Stream {
+		sr, err := crypto.NewStreamReader(spec, key, c.nonce, c.aad(), meta.ChunkSize, bytes.NewReader(c.ciphertext))
+		if err != nil {
+			return nil, err
+		}
+		// Each sealed chunk carries one tag, so the plaintext is the
+		// ciphertext less a tag per chunk.
+		ctLen := int64(len(c.ciphertext))
+		sealed := int64(meta.ChunkSize + spec.TagSize)
+		total := ctLen - (ctLen+sealed-1)/sealed*int64(spec.TagSize)
+		prog.enter(models.StageDecrypt, "解密...", 60, 80)
+		sr.OnChunk = func(done int64) { prog.bytes(done, total) }
+		plain = &authReader{r: &contextReader{ctx: ctx, r: sr}}
+	} else {
+		prog.enter(models.StageDecrypt, "解密...", 60, 80)
+		t0 = time.Now()
+		b, err := spec.Open(key, c.nonce, c.ciphertext, c.tag, c.aad())
+		if err != nil {
+			return nil, authError(err, 0)
+		}
+		logPerf(opts.Logf, "decrypt", opts.TaskID, "Decrypt", time.Since(t0), fmt.Sprintf("algorithm=%s plainBytes=%d", spec.Name, len(b)))
+		plain = bytes.NewReader(b)
+	}
+
+	o := &Opened{meta: meta}
+	if meta.Manifest {
+		if o.manifest, err = readManifest(plain); err != nil {
+			return nil, err
+		}
+	}
+	if o.Reader, o.close, err = newDecompressor(meta.Compression, plain); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// authError tells a wrong key from damage once the container has parsed: a
+// key that fails the first chunk is wrong, while a failure after read bytes
+// verified means the ciphertext was altered.
+func authError(err error, read int64) error {
+	switch {
+	case errors.Is(err, crypto.ErrAuthFailed) && read == 0:
+		return fmt.Errorf("%w: %w", ErrWrongPassword, err)
+	case errors.Is(err, crypto.ErrAuthFailed), errors.Is(err, crypto.ErrStreamTruncated):
+		return fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+	return err
+}
+
+// authReader classifies the authentication failures of a stream reader
+// with authError.
+type authReader struct {
+	r    io.Reader
+	read int64
+}
+
+func (a *authReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	a.read += int64(n)
+	if err != nil && err != io.EOF {
+		err = authError(err, a.read)
+	}
+	return n, err
+}
@@ -0,0 +1,988 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"stego/internal/config"
+	"stego/internal/crypto"
+	"stego/internal/engine"
+	"stego/internal/models"
+)
+
+func writeTestCarrier(t *testing.T, dir string, w, h int) string {
+	t.Helper()
+	rng := rand.New(rand.NewSource(7))
+	rgb := make([]byte, w*h*3)
+	rng.Read(rgb)
+	path := filepath.Join(dir, "carrier.png")
+	if err := engine.SaveRGBAsPNG(path, rgb, w, h); err != nil {
+		t.Fatalf("save carrier failed: %v", err)
+	}
+	return path
+}
+
+func findSingleFile(t *testing.T, dir string) string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir failed: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry in %s, got %d", dir, len(entries))
+	}
+	return filepath.Join(dir, entries[0].Name())
+}
+
+func encryptTestFile(t *testing.T, dir string, payload []byte, req models.EncryptRequest) string {
+	t.Helper()
+	return encryptTestFileResult(t, dir, payload, req).OutputPath
+}
+
+func encryptTestFileResult(t *testing.T, dir string, payload []byte, req models.EncryptRequest) models.EncryptResult {
+	t.Helper()
+	return encryptTestFileWithConfig(t, dir, map[string]string{}, payload, req)
+}
+
+func encryptTestFileWithConfig(t *testing.T, dir string, cfg map[string]string, payload []byte, req models.EncryptRequest) models.EncryptResult {
+	t.Helper()
+	src := filepath.Join(dir, "secret.txt")
+	if err := os.WriteFile(src, payload, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	req.DataSourcePath = src
+	req.CarrierImagePath = writeTestCarrier(t, dir, 256, 256)
+	req.OutputDir = filepath.Join(dir, "out")
+	req.OutputFileName = "result"
+	res, err := RunEncrypt(context.Background(), cfg, req, nil, "enc", nil)
+	if err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+	return res
+}
+
+func decryptTestImage(t *testing.T, dir string, req models.DecryptRequest) ([]byte, error) {
+	t.Helper()
+	got, _, err := decryptTestImageWithConfig(t, dir, map[string]string{}, req)
+	return got, err
+}
+
+func decryptTestImageWithConfig(t *testing.T, dir string, cfg map[string]string, req models.DecryptRequest) ([]byte, models.DecryptResult, error) {
+	t.Helper()
+	outDir := filepath.Join(dir, "dec")
+	_ = os.RemoveAll(outDir)
+	req.OutputDir = outDir
+	res, err := RunDecrypt(context.Background(), cfg, req, nil, "dec", nil)
+	if err != nil {
+		return nil, res, err
+	}
+	got, err := os.ReadFile(findSingleFile(t, filepath.Join(outDir, "extracted")))
+	return got, res, err
+}
+
+func TestSignedImageReportsSigner(t *testing.T) {
+	signer, _ := crypto.GenerateSigningKey()
+	dir := t.TempDir()
+	payload := []byte("signed payload")
+	img := encryptTestFile(t, dir, payload, models.EncryptRequest{Password: "pw", SigningKey: signer.String()})
+
+	trusted := map[string]string{config.KeyTrustedSigners: "alice=" + signer.Public().String()}
+	got, res, err := decryptTestImageWithConfig(t, dir, trusted, models.DecryptRequest{ImagePath: img, Password: "pw"})
+	if err != nil {
+		t.Fatalf("decrypt failed: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("payload mismatch")
+	}
+	if res.SignatureStatus != SignatureValid || res.SignerName != "alice" || res.SignerKeyID != signer.Public().KeyID() {
+		t.Fatalf("unexpected signature result: %+v", res)
+	}
+
+	_, res, err = decryptTestImageWithConfig(t, dir, map[string]string{}, models.DecryptRequest{ImagePath: img, Password: "pw"})
+	if err != nil {
+		t.Fatalf("decrypt failed: %v", err)
+	}
+	if res.SignatureStatus != SignatureUntrusted {
+		t.Fatalf("expected untrusted signer, got %+v", res)
+	}
+}
+
+func TestRecipientEncryptionRoundTrip(t *testing.T) {
+	alice, _ := crypto.GenerateX25519Identity()
+	bob, _ := crypto.GenerateX25519Identity()
+	eve, _ := crypto.GenerateX25519Identity()
+	dir := t.TempDir()
+	payload := []byte("for alice and bob only")
+	img := encryptTestFile(t, dir, payload, models.EncryptRequest{
+		Recipients: []string{alice.Recipient().String(), bob.Recipient().String()},
+	})
+
+	for _, id := range []*crypto.X25519Identity{alice, bob} {
+		got, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: img, Identity: id.String()})
+		if err != nil {
+			t.Fatalf("decrypt failed: %v", err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Fatalf("payload mismatch")
+		}
+	}
+	if _, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: img, Identity: eve.String()}); err == nil {
+		t.Fatalf("expected non-recipient identity to fail")
+	}
+}
+
+func TestRecipientImageIgnoresPasswordAndKeyfile(t *testing.T) {
+	alice, _ := crypto.GenerateX25519Identity()
+	dir := t.TempDir()
+	keyfile := filepath.Join(dir, "key.bin")
+	if err := os.WriteFile(keyfile, bytes.Repeat([]byte{0x5A}, 256), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte("identity in place of a password")
+	cfg := map[string]string{config.KeyDefaultEncryptPassword: "house password"}
+	res := encryptTestFileWithConfig(t, dir, cfg, payload, models.EncryptRequest{
+		Recipients:  []string{alice.Recipient().String()},
+		KeyfilePath: keyfile,
+	})
+
+	got, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: res.OutputPath, Identity: alice.String()})
+	if err != nil {
+		t.Fatalf("decrypt with the identity alone failed: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("payload mismatch")
+	}
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	for _, cipherName := range crypto.CipherNames() {
+		t.Run(cipherName, func(t *testing.T) {
+			dir := t.TempDir()
+			carrier := writeTestCarrier(t, dir, 256, 256)
+			payload := bytes.Repeat([]byte("stego round trip "), 200)
+			src := filepath.Join(dir, "secret.txt")
+			if err := os.WriteFile(src, payload, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			outDir := filepath.Join(dir, "out")
+			_, err := RunEncrypt(context.Background(), map[string]string{}, models.EncryptRequest{
+				DataSourcePath:   src,
+				CarrierImagePath: carrier,
+				OutputDir:        outDir,
+				OutputFileName:   "result",
+				Password:         "correct horse",
+				Cipher:           cipherName,
+			}, nil, "t1", nil)
+			if err != nil {
+				t.Fatalf("encrypt failed: %v", err)
+			}
+
+			stegoPath := findSingleFile(t, filepath.Join(outDir, "encrypted"))
+			_, err = RunDecrypt(context.Background(), map[string]string{}, models.DecryptRequest{
+				ImagePath: stegoPath,
+				OutputDir: outDir,
+				Password:  "correct horse",
+			}, nil, "t2", nil)
+			if err != nil {
+				t.Fatalf("decrypt failed: %v", err)
+			}
+			got, err := os.ReadFile(findSingleFile(t, filepath.Join(outDir, "extracted")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatalf("payload mismatch")
+			}
+		})
+	}
+}
+
+func TestDecryptRejectsArgon2AboveBudget(t *testing.T) {
+	dir := t.TempDir()
+	// The default 64 MiB is within the default budget but not a 32 MB one.
+	img := encryptTestFile(t, dir, []byte("costly"), models.EncryptRequest{Password: "pw"})
+	_, _, err := decryptTestImageWithConfig(t, dir, map[string]string{config.KeyKDFMaxMemoryMB: "32"}, models.DecryptRequest{ImagePath: img, Password: "pw"})
+	if !errors.Is(err, ErrKDFLimit) {
+		t.Fatalf("expected ErrKDFLimit, got %v", err)
+	}
+	if code := ErrorCode(err); code != models.ErrorCodeKDFLimit {
+		t.Fatalf("error code %q, want %q", code, models.ErrorCodeKDFLimit)
+	}
+	if got, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: img, Password: "pw"}); err != nil || string(got) != "costly" {
+		t.Fatalf("default budget: %q, %v", got, err)
+	}
+}
+
+// writeLegacyImage hides payload as images made before Argon2id did, with
+// the PBKDF2 iteration count recorded as iterations.
+func writeLegacyImage(t *testing.T, dir, password string, payload []byte, iterations int) string {
+	t.Helper()
+	w, h := 128, 128
+	rng := rand.New(rand.NewSource(9))
+	rgb := make([]byte, w*h*3)
+	rng.Read(rgb)
+
+	salt := make([]byte, 16)
+	nonce := make([]byte, 12)
+	rng.Read(salt)
+	rng.Read(nonce)
+	key := crypto.PBKDF2Compat(password, salt, min(iterations, 50000), 32)
+	ciphertext, tag, err := crypto.EncryptAESGCM(key, nonce, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metaJSON, _ := json.Marshal(map[string]any{
+		"algorithm":         "AES-GCM",
+		"key_length":        32,
+		"salt_length":       16,
+		"nonce_length":      12,
+		"tag_length":        16,
+		"pbkdf2_iterations": iterations,
+	})
+	metaLen := make([]byte, 4)
+	binary.LittleEndian.PutUint32(metaLen, uint32(len(metaJSON)))
+	full := append(append(append(append(metaLen, metaJSON...), salt...), nonce...), tag...)
+	full = append(full, ciphertext...)
+	wrapped, err := crypto.ECCWrapRS(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _, err := engine.New(0).Hide(rgb, w, h, wrapped, password, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgPath := filepath.Join(dir, "legacy.png")
+	if err := engine.SaveRGBAsPNG(imgPath, out, w, h); err != nil {
+		t.Fatal(err)
+	}
+	return imgPath
+}
+
+func TestDecryptLegacyPBKDF2Image(t *testing.T) {
+	dir := t.TempDir()
+	payload := []byte("legacy payload")
+	password := "legacy"
+	imgPath := writeLegacyImage(t, dir, password, payload, 50000)
+
+	outDir := filepath.Join(dir, "out")
+	_, err := RunDecrypt(context.Background(), map[string]string{}, models.DecryptRequest{
+		ImagePath: imgPath,
+		OutputDir: outDir,
+		Password:  password,
+	}, nil, "legacy", nil)
+	if err != nil {
+		t.Fatalf("decrypt failed: %v", err)
+	}
+	got, err := os.ReadFile(findSingleFile(t, filepath.Join(outDir, "extracted")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("payload mismatch")
+	}
+}
+
+func TestDecryptRejectsPBKDF2AboveLimit(t *testing.T) {
+	dir := t.TempDir()
+	img := writeLegacyImage(t, dir, "legacy", []byte("costly"), 1<<31-1)
+	start := time.Now()
+	_, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: img, Password: "legacy"})
+	if !errors.Is(err, ErrKDFLimit) {
+		t.Fatalf("expected ErrKDFLimit, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("refusal took %v", d)
+	}
+}
+
+func TestKeyfileCompositeSecret(t *testing.T) {
+	dir := t.TempDir()
+	keyfile := filepath.Join(dir, "key.png")
+	if err := os.WriteFile(keyfile, bytes.Repeat([]byte{0xAB, 0xCD}, 512), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte("needs password and keyfile")
+	img := encryptTestFile(t, dir, payload, models.EncryptRequest{Password: "pw", KeyfilePath: keyfile})
+
+	got, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: img, Password: "pw", KeyfilePath: keyfile})
+	if err != nil {
+		t.Fatalf("decrypt failed: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("payload mismatch")
+	}
+	if _, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: img, Password: "pw"}); err == nil {
+		t.Fatalf("expected decrypt without keyfile to fail")
+	}
+}
+
+func TestShamirSharesUnlockImage(t *testing.T) {
+	dir := t.TempDir()
+	payload := []byte("custodian protected archive")
+	res := encryptTestFileResult(t, dir, payload, models.EncryptRequest{ShareCount: 5, ShareThreshold: 3})
+	if len(res.Shares) != 5 {
+		t.Fatalf("expected 5 shares, got %d", len(res.Shares))
+	}
+
+	got, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: res.OutputPath, Shares: res.Shares[2:]})
+	if err != nil {
+		t.Fatalf("decrypt failed: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("payload mismatch")
+	}
+	if _, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: res.OutputPath, Shares: res.Shares[:2]}); err == nil {
+		t.Fatalf("expected decrypt with too few shares to fail")
+	}
+}
+
+func TestSharesAloneUnlockImage(t *testing.T) {
+	dir := t.TempDir()
+	keyfile := filepath.Join(dir, "key.bin")
+	if err := os.WriteFile(keyfile, bytes.Repeat([]byte{0x5A}, 256), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte("shares in place of a password")
+	cfg := map[string]string{config.KeyDefaultEncryptPassword: "house password"}
+	res := encryptTestFileWithConfig(t, dir, cfg, payload, models.EncryptRequest{
+		Password:       "pw",
+		KeyfilePath:    keyfile,
+		ShareCount:     3,
+		ShareThreshold: 2,
+	})
+
+	got, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: res.OutputPath, Shares: res.Shares[1:]})
+	if err != nil {
+		t.Fatalf("decrypt with shares alone failed: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("payload mismatch")
+	}
+}
+
+func TestDirectoryStreamRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	srcDir := filepath.Join(dir, "folder")
+	if err := os.MkdirAll(filepath.Join(srcDir, "nested"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"a.txt":        bytes.Repeat([]byte("alpha "), 300),
+		"nested/b.bin": {0, 1, 2, 3, 4, 5},
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(srcDir, filepath.FromSlash(name)), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outDir := filepath.Join(dir, "out")
+	res, err := RunEncrypt(context.Background(), map[string]string{}, models.EncryptRequest{
+		DataSourcePath:   srcDir,
+		CarrierImagePath: writeTestCarrier(t, dir, 256, 256),
+		OutputDir:        outDir,
+		OutputFileName:   "result",
+		Password:         "folder",
+	}, nil, "enc", nil)
+	if err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+	dec, err := RunDecrypt(context.Background(), map[string]string{}, models.DecryptRequest{
+		ImagePath: res.OutputPath,
+		OutputDir: outDir,
+		Password:  "folder",
+	}, nil, "dec", nil)
+	if err != nil {
+		t.Fatalf("decrypt failed: %v", err)
+	}
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(dec.OutputPath, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s: content mismatch", name)
+		}
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(outDir, "extracted", ".stego-*.part")); len(leftovers) != 0 {
+		t.Fatalf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestConfigurableECCRoundTrip(t *testing.T) {
+	for _, ecc := range []string{"16", "128", "RS(128,96)"} {
+		t.Run(ecc, func(t *testing.T) {
+			dir := t.TempDir()
+			payload := bytes.Repeat([]byte("parity "), 100)
+			img := encryptTestFile(t, dir, payload, models.EncryptRequest{Password: "ecc", ECC: ecc})
+			got, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: img, Password: "ecc"})
+			if err != nil {
+				t.Fatalf("decrypt failed: %v", err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatalf("payload mismatch")
+			}
+		})
+	}
+	if _, err := RunEncrypt(context.Background(), map[string]string{}, models.EncryptRequest{ECC: "RS(300,10)"}, nil, "bad", nil); err == nil {
+		t.Fatalf("expected invalid ecc setting to be rejected")
+	}
+}
+
+func TestDecryptRepairsDamageWithErasures(t *testing.T) {
+	dir := t.TempDir()
+	payload := make([]byte, 20000)
+	rand.New(rand.NewSource(11)).Read(payload)
+	scatter := false
+	img := encryptTestFile(t, dir, payload, models.EncryptRequest{Password: "damage", Scatter: &scatter})
+
+	rgb, w, h, err := engine.LoadImageRGB(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodySlot := (engine.HeaderLength + engine.IntegrityHashLen + engine.GeometryLength) * 4
+	for i := bodySlot + 4*3000; i < bodySlot+4*5000; i++ {
+		rgb[i] ^= 0x3
+	}
+	if err := engine.SaveRGBAsPNG(img, rgb, w, h); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := engine.New(0).ExtractDetailed(rgb, w, h, "damage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := crypto.ECCUnwrapRS(report.Data); err == nil {
+		t.Fatalf("damage should exceed errors-only correction")
+	}
+	got, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: img, Password: "damage"})
+	if err != nil {
+		t.Fatalf("decrypt with erasures failed: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("payload mismatch")
+	}
+}
+
+func TestDecryptRecoversBottomCrop(t *testing.T) {
+	dir := t.TempDir()
+	payload := bytes.Repeat([]byte("crop me "), 400)
+	img := encryptTestFile(t, dir, payload, models.EncryptRequest{Password: "crop"})
+
+	rgb, w, h, err := engine.LoadImageRGB(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	croppedH := h - 5
+	if err := engine.SaveRGBAsPNG(img, rgb[:w*croppedH*3], w, croppedH); err != nil {
+		t.Fatal(err)
+	}
+	got, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: img, Password: "crop"})
+	if err != nil {
+		t.Fatalf("decrypt of cropped image failed: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("payload mismatch")
+	}
+}
+
+func TestFountainFECSurvivesHeavyBurst(t *testing.T) {
+	dir := t.TempDir()
+	payload := make([]byte, 12000)
+	rand.New(rand.NewSource(12)).Read(payload)
+	scatter := false
+	img := encryptTestFile(t, dir, payload, models.EncryptRequest{Password: "fountain", ECC: "LT(100)", Scatter: &scatter})
+
+	rgb, w, h, err := engine.LoadImageRGB(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodySlot := (engine.HeaderLength + engine.IntegrityHashLen + engine.GeometryLength) * 4
+	for i := bodySlot + 4*2000; i < bodySlot+4*8000; i++ {
+		rgb[i] ^= 0x3
+	}
+	if err := engine.SaveRGBAsPNG(img, rgb, w, h); err != nil {
+		t.Fatal(err)
+	}
+	got, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: img, Password: "fountain"})
+	if err != nil {
+		t.Fatalf("decrypt failed: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("payload mismatch")
+	}
+}
+
+func containerMetadata(t *testing.T, img, password string) encryptMetadata {
+	t.Helper()
+	rgb, w, h, err := engine.LoadImageRGB(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extracted, _, _, _, err := engine.New(0).Extract(rgb, w, h, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapped, err := crypto.FECUnwrap(extracted, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := parseContainer(unwrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.meta
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("squeeze this text down "), 1000)
+	for _, algo := range []string{CompressionNone, CompressionDeflate, CompressionZstd, CompressionXZ} {
+		t.Run(algo, func(t *testing.T) {
+			dir := t.TempDir()
+			img := encryptTestFile(t, dir, payload, models.EncryptRequest{Password: "zip", Compression: algo})
+			meta := containerMetadata(t, img, "zip")
+			want := algo
+			if algo == CompressionNone {
+				want = ""
+			}
+			if meta.Compression != want {
+				t.Fatalf("metadata compression = %q, want %q", meta.Compression, want)
+			}
+			got, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: img, Password: "zip"})
+			if err != nil {
+				t.Fatalf("decrypt failed: %v", err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatalf("payload mismatch")
+			}
+		})
+	}
+	if _, err := RunEncrypt(context.Background(), map[string]string{}, models.EncryptRequest{Compression: "brotli"}, nil, "bad", nil); err == nil {
+		t.Fatalf("expected unknown compression to be rejected")
+	}
+}
+
+func TestCompressionSkipsIncompressibleData(t *testing.T) {
+	dir := t.TempDir()
+	payload := make([]byte, 30000)
+	rand.New(rand.NewSource(13)).Read(payload)
+	img := encryptTestFile(t, dir, payload, models.EncryptRequest{Password: "noise", Compression: CompressionXZ})
+	if meta := containerMetadata(t, img, "noise"); meta.Compression != "" {
+		t.Fatalf("random data should be stored uncompressed, got %q", meta.Compression)
+	}
+	got, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: img, Password: "noise"})
+	if err != nil {
+		t.Fatalf("decrypt failed: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("payload mismatch")
+	}
+}
+
+func TestManifestRestoresNamesAndTimes(t *testing.T) {
+	dir := t.TempDir()
+	srcDir := filepath.Join(dir, "folder")
+	if err := os.MkdirAll(filepath.Join(srcDir, "nested"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2021, 3, 14, 15, 9, 26, 0, time.UTC)
+	files := map[string][]byte{
+		"report.txt":      []byte("quarterly numbers"),
+		"nested/data.bin": {9, 8, 7},
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.WriteFile(path, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, source := range []string{filepath.Join(srcDir, "report.txt"), srcDir} {
+		outDir := filepath.Join(dir, "out", filepath.Base(source))
+		res, err := RunEncrypt(context.Background(), map[string]string{}, models.EncryptRequest{
+			DataSourcePath:   source,
+			CarrierImagePath: writeTestCarrier(t, dir, 256, 256),
+			OutputDir:        outDir,
+			OutputFileName:   "result",
+			Password:         "manifest",
+		}, nil, "enc", nil)
+		if err != nil {
+			t.Fatalf("%s: encrypt failed: %v", source, err)
+		}
+		dec, err := RunDecrypt(context.Background(), map[string]string{}, models.DecryptRequest{
+			ImagePath: res.OutputPath,
+			OutputDir: outDir,
+			Password:  "manifest",
+		}, nil, "dec", nil)
+		if err != nil {
+			t.Fatalf("%s: decrypt failed: %v", source, err)
+		}
+		restored := map[string]string{"report.txt": dec.OutputPath}
+		if source == srcDir {
+			restored = map[string]string{}
+			for name := range files {
+				restored[name] = filepath.Join(dec.OutputPath, filepath.FromSlash(name))
+			}
+		} else if filepath.Base(dec.OutputPath) != "report.txt" {
+			t.Fatalf("restored name = %s, want report.txt", filepath.Base(dec.OutputPath))
+		}
+		if len(dec.Files) != len(restored) {
+			t.Fatalf("%s: result lists %d files, want %d", source, len(dec.Files), len(restored))
+		}
+		for name, path := range restored {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.ModTime().Equal(mtime) {
+				t.Fatalf("%s: mtime = %v, want %v", name, info.ModTime(), mtime)
+			}
+			if runtime.GOOS != "windows" && info.Mode().Perm() != 0o600 {
+				t.Fatalf("%s: mode = %v, want 0600", name, info.Mode().Perm())
+			}
+		}
+	}
+}
+
+func TestRestoreItemRejectsHashMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("tampered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	item := manifestItem{Name: "file.txt", SHA256: strings.Repeat("00", 32)}
+	if err := restoreItem(path, item); !errors.Is(err, ErrManifestHashMismatch) {
+		t.Fatalf("expected hash mismatch, got %v", err)
+	}
+	if _, err := safeJoin(t.TempDir(), "../escape.txt"); err == nil {
+		t.Fatalf("expected path traversal to be rejected")
+	}
+}
+
+func TestArchiveHashMismatchLeavesNoFiles(t *testing.T) {
+	outBase := t.TempDir()
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	var items []manifestItem
+	for _, name := range []string{"a.txt", "b.txt"} {
+		content := []byte("contents of " + name)
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatal(err)
+		}
+		item, err := newManifestItem(name, time.Time{}, 0o644, bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, item)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The second file fails verification after the first one was unpacked.
+	items[1].SHA256 = strings.Repeat("00", 32)
+	manifest := &payloadManifest{Version: manifestVersion, Type: PayloadBinary, Archive: true, Items: items}
+
+	prog := newProgressReporter(func(models.ProgressEvent) {})
+	_, err := writeDecryptedOutput(context.Background(), &zipped, outBase, "stego", "image.png", manifest, prog)
+	if !errors.Is(err, ErrManifestHashMismatch) {
+		t.Fatalf("expected hash mismatch, got %v", err)
+	}
+	entries, err := os.ReadDir(outBase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("failed archive left %d entries behind, first %s", len(entries), entries[0].Name())
+	}
+}
+
+func TestInlineTextRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	message := "meet at the old bridge · 午夜见\n"
+	outDir := filepath.Join(dir, "out")
+	res, err := RunEncrypt(context.Background(), map[string]string{}, models.EncryptRequest{
+		Text:             message,
+		CarrierImagePath: writeTestCarrier(t, dir, 256, 256),
+		OutputDir:        outDir,
+		OutputFileName:   "note",
+		Password:         "note",
+		Compression:      CompressionZstd,
+	}, nil, "enc", nil)
+	if err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+	dec, err := RunDecrypt(context.Background(), map[string]string{}, models.DecryptRequest{
+		ImagePath: res.OutputPath,
+		OutputDir: outDir,
+		Password:  "note",
+	}, nil, "dec", nil)
+	if err != nil {
+		t.Fatalf("decrypt failed: %v", err)
+	}
+	if dec.PayloadType != PayloadText || dec.Text != message {
+		t.Fatalf("got payload %q/%q, want text %q", dec.PayloadType, dec.Text, message)
+	}
+	if dec.OutputPath != "" {
+		t.Fatalf("text payload should not be written to disk, got %s", dec.OutputPath)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "extracted")); !os.IsNotExist(err) {
+		t.Fatalf("text payload created an extracted directory")
+	}
+
+	if _, err := RunEncrypt(context.Background(), map[string]string{}, models.EncryptRequest{
+		Text:           message,
+		DataSourcePath: res.OutputPath,
+	}, nil, "bad", nil); err == nil {
+		t.Fatalf("expected text combined with a data source to be rejected")
+	}
+}
+
+func writeCarrierDir(tb testing.TB, n, w, h int) string {
+	tb.Helper()
+	dir := tb.TempDir()
+	rng := rand.New(rand.NewSource(8))
+	for i := 0; i < n; i++ {
+		// Vary the noise amplitude so every carrier scores differently.
+		rgb := make([]byte, w*h*3)
+		rng.Read(rgb)
+		for j := range rgb {
+			rgb[j] = rgb[j] % byte(16+i*24)
+		}
+		if err := engine.SaveRGBAsPNG(filepath.Join(dir, fmt.Sprintf("c%02d.png", i)), rgb, w, h); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSelectCarrierIsDeterministic(t *testing.T) {
+	dir := writeCarrierDir(t, 6, 96, 96)
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	var picks []string
+	for _, procs := range []int{1, 4} {
+		runtime.GOMAXPROCS(procs)
+		path, err := selectCarrierImage(context.Background(), engine.New(0), dir, 1000, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		picks = append(picks, path)
+	}
+	if picks[0] != picks[1] || filepath.Base(picks[0]) != "c05.png" {
+		t.Fatalf("picked %v", picks)
+	}
+}
+
+// Run with -cpu 1,4 to see texture scoring spread across workers.
+func BenchmarkSelectCarrierImage(b *testing.B) {
+	dir := writeCarrierDir(b, 8, 1024, 768)
+	eng := engine.New(0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := selectCarrierImage(context.Background(), eng, dir, 1000, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEncryptCancelStopsEmbedding(t *testing.T) {
+	dir := t.TempDir()
+	carrier := writeTestCarrier(t, dir, 1600, 1200)
+	src := filepath.Join(dir, "secret.bin")
+	if err := os.WriteFile(src, make([]byte, 64<<10), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var cancelled time.Time
+	var last models.ProgressEvent
+	emit := func(ev models.ProgressEvent) {
+		last = ev
+		// Cancel once embedding starts, the longest stage on a big carrier.
+		if ev.Progress == 50 && cancelled.IsZero() {
+			cancelled = time.Now()
+			cancel()
+		}
+	}
+	outDir := filepath.Join(dir, "out")
+	_, err := RunEncrypt(ctx, map[string]string{}, models.EncryptRequest{
+		DataSourcePath:   src,
+		CarrierImagePath: carrier,
+		OutputDir:        outDir,
+		OutputFileName:   "result",
+		Password:         "pw",
+	}, emit, "cancel", nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if !last.Done || last.ErrorCode != models.ErrorCodeCancelled {
+		t.Fatalf("expected a final cancelled event, got %+v", last)
+	}
+	if d := time.Since(cancelled); d > 500*time.Millisecond {
+		t.Fatalf("cancel took %s", d)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(outDir, "encrypted")); len(entries) != 0 {
+		t.Fatalf("partial output left behind: %v", entries)
+	}
+}
+
+func TestFailuresEndTheEventStream(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "secret.bin")
+	if err := os.WriteFile(src, []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	runs := map[string]func(emit func(models.ProgressEvent)) error{
+		"missing carrier": func(emit func(models.ProgressEvent)) error {
+			_, err := RunEncrypt(context.Background(), map[string]string{}, models.EncryptRequest{
+				DataSourcePath:   src,
+				CarrierImagePath: filepath.Join(dir, "missing.png"),
+				OutputDir:        dir,
+				Password:         "pw",
+			}, emit, "missing", nil)
+			return err
+		},
+		"cancelled decrypt": func(emit func(models.ProgressEvent)) error {
+			_, err := RunDecrypt(cancelled, map[string]string{}, models.DecryptRequest{
+				ImagePath: writeTestCarrier(t, dir, 64, 64),
+				OutputDir: dir,
+				Password:  "pw",
+			}, emit, "cancelled", nil)
+			return err
+		},
+		"cancelled generate": func(emit func(models.ProgressEvent)) error {
+			return RunGenerateCarrier(cancelled, models.GenerateRequest{OutputDir: dir, Count: 1, TargetBytes: 1024}, emit, "generate", nil)
+		},
+	}
+	for name, run := range runs {
+		var events []models.ProgressEvent
+		err := run(func(ev models.ProgressEvent) { events = append(events, ev) })
+		if err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+		var last models.ProgressEvent
+		if len(events) > 0 {
+			last = events[len(events)-1]
+		}
+		if !last.Done || last.ErrorCode != ErrorCode(err) {
+			t.Errorf("%s: no final error event for %v, last was %+v", name, err, last)
+		}
+	}
+}
+
+func TestProgressReporterThrottlesByteUpdates(t *testing.T) {
+	var events []models.ProgressEvent
+	r := newProgressReporter(func(ev models.ProgressEvent) { events = append(events, ev) })
+	r.enter(models.StageEncrypt, "加密...", 20, 40)
+	for i := int64(1); i <= 1000; i++ {
+		r.bytes(i, 1000)
+	}
+	if len(events) > 4 {
+		t.Fatalf("expected throttled updates, got %d events", len(events))
+	}
+	last := events[len(events)-1]
+	if last.Stage != models.StageEncrypt || last.BytesDone != 1000 || last.BytesTotal != 1000 || last.Progress != 40 {
+		t.Fatalf("final update missing: %+v", last)
+	}
+}
+
+func TestEncryptProgressReportsStages(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "secret.bin")
+	if err := os.WriteFile(src, bytes.Repeat([]byte("progress "), 4096), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var events []models.ProgressEvent
+	_, err := RunEncrypt(context.Background(), map[string]string{}, models.EncryptRequest{
+		DataSourcePath:   src,
+		CarrierImagePath: writeTestCarrier(t, dir, 256, 256),
+		OutputDir:        filepath.Join(dir, "out"),
+		OutputFileName:   "result",
+		Password:         "pw",
+	}, func(ev models.ProgressEvent) { events = append(events, ev) }, "progress", nil)
+	if err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+	seen := map[string]bool{}
+	prev := 0
+	for _, ev := range events {
+		if ev.Progress < prev {
+			t.Fatalf("progress went backwards: %d after %d", ev.Progress, prev)
+		}
+		prev = ev.Progress
+		seen[ev.Stage] = true
+		if ev.BytesDone > ev.BytesTotal {
+			t.Fatalf("bytes done past total: %+v", ev)
+		}
+	}
+	for _, stage := range []string{models.StageRead, models.StageKDF, models.StageEncrypt, models.StageFEC, models.StageEmbed, models.StageDone} {
+		if !seen[stage] {
+			t.Errorf("no %q event", stage)
+		}
+	}
+}
+
+func TestDecryptClassifiesFailures(t *testing.T) {
+	dir := t.TempDir()
+	noScatter := false
+	img := encryptTestFile(t, dir, []byte("classified"), models.EncryptRequest{Password: "right", Scatter: &noScatter})
+
+	// Both layouts reject the password from the key check, before the
+	// payload is extracted and error corrected.
+	scattered := encryptTestFile(t, t.TempDir(), []byte("classified"), models.EncryptRequest{Password: "right"})
+	for _, path := range []string{img, scattered} {
+		var events []models.ProgressEvent
+		_, err := RunDecrypt(context.Background(), map[string]string{}, models.DecryptRequest{
+			ImagePath: path,
+			OutputDir: filepath.Join(dir, "dec"),
+			Password:  "wrong",
+		}, func(ev models.ProgressEvent) { events = append(events, ev) }, "wrong", nil)
+		if !errors.Is(err, ErrWrongPassword) {
+			t.Fatalf("expected ErrWrongPassword, got %v", err)
+		}
+		if last := events[len(events)-1]; !last.Done || last.ErrorCode != models.ErrorCodeWrongPassword {
+			t.Fatalf("expected a %q event, got %+v", models.ErrorCodeWrongPassword, last)
+		}
+		for _, ev := range events {
+			if ev.Stage == models.StageFEC || ev.Progress > 10 {
+				t.Fatalf("wrong password got past the key check: %+v", ev)
+			}
+		}
+	}
+
+	// Keyfile images ask for the keyfile rather than blame the password.
+	keyfile := filepath.Join(dir, "key.bin")
+	if err := os.WriteFile(keyfile, []byte("keyfile"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	withKeyfile := encryptTestFile(t, t.TempDir(), []byte("classified"), models.EncryptRequest{Password: "right", KeyfilePath: keyfile})
+	if _, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: withKeyfile, Password: "right"}); !errors.Is(err, ErrKeyRequired) {
+		t.Fatalf("expected ErrKeyRequired, got %v", err)
+	}
+	if got, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: withKeyfile, Password: "right", KeyfilePath: keyfile}); err != nil || string(got) != "classified" {
+		t.Fatalf("keyfile decrypt: %q, %v", got, err)
+	}
+
+	_, err := decryptTestImage(t, dir, models.DecryptRequest{ImagePath: writeTestCarrier(t, dir, 256, 256), Password: "right"})
+	if !errors.Is(err, ErrNoPayload) || ErrorCode(err) != models.ErrorCodeNoPayload {
+		t.Fatalf("expected ErrNoPayload, got %v", err)
+	}
+}
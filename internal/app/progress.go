@@ -0,0 +1,112 @@
+package app
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"stego/internal/models"
+)
+
+// progressInterval is the minimum gap between two byte-count updates of the
+// same stage; stage changes and the final update always go out.
+const progressInterval = 100 * time.Millisecond
+
+// progressReporter maps the byte counts of long-running stages onto a span
+// of the overall percentage and adds throughput and ETA. It is safe for
+// concurrent use, as parallel FEC workers report through it.
+type progressReporter struct {
+	emit func(models.ProgressEvent)
+
+	mu       sync.Mutex
+	ev       models.ProgressEvent
+	from, to int
+	start    time.Time
+	last     time.Time
+}
+
+func newProgressReporter(emit func(models.ProgressEvent)) *progressReporter {
+	if emit == nil {
+		emit = func(models.ProgressEvent) {}
+	}
+	return &progressReporter{emit: emit}
+}
+
+// enter starts stage, which covers from..to percent, and emits it. Entering
+// the current stage again does nothing.
+func (r *progressReporter) enter(stage, message string, from, to int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ev.Stage == stage {
+		return
+	}
+	r.from, r.to = from, to
+	r.start = time.Now()
+	r.last = time.Time{}
+	r.ev = models.ProgressEvent{Stage: stage, Message: message, Progress: from}
+	r.emit(r.ev)
+}
+
+// bytes reports done of total bytes through the current stage. Calls with
+// nothing done yet add nothing to the event enter already sent.
+func (r *progressReporter) bytes(done, total int64) {
+	if done <= 0 || total <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if done < total && now.Sub(r.last) < progressInterval {
+		return
+	}
+	r.last = now
+	ev := models.ProgressEvent{Stage: r.ev.Stage, Message: r.ev.Message, Progress: r.from, BytesDone: done, BytesTotal: total}
+	ev.Progress = r.from + int(int64(r.to-r.from)*min(done, total)/total)
+	if elapsed := now.Sub(r.start).Seconds(); elapsed > 0 {
+		ev.BytesPerSec = float64(done) / elapsed
+		if done < total {
+			ev.ETASeconds = float64(total-done) / ev.BytesPerSec
+		}
+	}
+	r.ev = ev
+	r.emit(ev)
+}
+
+// forward emits ev, produced by another reporter, and makes its stage and
+// percentage the ones fail reports.
+func (r *progressReporter) forward(ev models.ProgressEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ev = ev
+	r.emit(ev)
+}
+
+// fail emits err as the final event, at the stage and percentage reached.
+func (r *progressReporter) fail(err error) {
+	r.failWith(err, nil)
+}
+
+// failWith is fail with a partial decrypt result attached.
+func (r *progressReporter) failWith(err error, res *models.DecryptResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.emit(models.ProgressEvent{Stage: r.ev.Stage, Progress: r.ev.Progress, Error: err.Error(), ErrorCode: ErrorCode(err), Done: true, DecryptResult: res})
+}
+
+// progressReader reports every read from r to fn, if set, as a running
+// byte count.
+type progressReader struct {
+	r     io.Reader
+	done  int64
+	total int64
+	fn    func(done, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 && p.fn != nil {
+		p.done += int64(n)
+		p.fn(p.done, p.total)
+	}
+	return n, err
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+
+	"stego/internal/crypto"
+	"stego/internal/engine"
+	"stego/internal/models"
+)
+
+// Payload is plaintext ready to be sealed: the manifest describing it and
+// the bytes that follow, already compressed when compression is set.
+type Payload struct {
+	r           io.Reader
+	size        int64
+	manifest    payloadManifest
+	compression string
+}
+
+// NewPayload describes a single in-memory file and compresses it with algo
+// unless the data looks incompressible.
+func NewPayload(data []byte, name string, modTime time.Time, mode fs.FileMode, algo string) (*Payload, error) {
+	item, err := newManifestItem(name, modTime, mode, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return newMemoryPayload(data, payloadManifest{Version: manifestVersion, Type: PayloadBinary, Items: []manifestItem{item}}, algo)
+}
+
+// NewTextPayload describes an inline text message.
+func NewTextPayload(text, algo string) (*Payload, error) {
+	manifest, err := newTextManifest(text)
+	if err != nil {
+		return nil, err
+	}
+	return newMemoryPayload([]byte(text), manifest, algo)
+}
+
+func newMemoryPayload(data []byte, manifest payloadManifest, algo string) (*Payload, error) {
+	algo, err := normalizeCompression(algo)
+	if err != nil {
+		return nil, err
+	}
+	data, algo, err = compressBytes(data, algo)
+	if err != nil {
+		return nil, err
+	}
+	return &Payload{r: bytes.NewReader(data), size: int64(len(data)), manifest: manifest, compression: algo}, nil
+}
+
+// SealOptions selects how a payload is encrypted and protected. Secret is
+// the password, or the composite secret when Keyfile is set; it is ignored
+// when the key is wrapped for Recipients or split into shares.
+type SealOptions struct {
+	Secret         string
+	Keyfile        bool
+	Cipher         crypto.CipherSpec
+	Crypto         crypto.AESGCMConfig
+	FEC            crypto.FECParams
+	ChunkSize      int
+	Recipients     []string
+	ShareCount     int
+	ShareThreshold int
+	SigningKey     string
+}
+
+// Sealer holds the container metadata and keys for one payload, so the
+// size of the embedded blob is known before a carrier is chosen.
+type Sealer struct {
+	payload       *Payload
+	opts          SealOptions
+	meta          encryptMetadata
+	manifestBlock []byte
+	plainLen      int64
+	fileKey       []byte
+	signer        *crypto.SigningKey
+	shares        []string
+	keyCheck      []byte
+}
+
+func NewSealer(p *Payload, opts SealOptions) (*Sealer, error) {
+	s := &Sealer{payload: p, opts: opts}
+	s.meta = newEncryptMetadata(opts.Crypto, opts.Cipher)
+	s.meta.Keyfile = opts.Keyfile
+	s.meta.enableStream(opts.Cipher, opts.ChunkSize)
+	if p.compression != CompressionNone {
+		s.meta.Compression = p.compression
+	}
+	block, err := p.manifest.marshal()
+	if err != nil {
+		return nil, err
+	}
+	s.manifestBlock = block
+	s.meta.Manifest = true
+	s.plainLen = int64(len(block)) + p.size
+
+	switch {
+	case len(opts.Recipients) > 0 && opts.ShareCount > 0:
+		err = errors.New("recipients and key shares cannot be combined")
+	case len(opts.Recipients) > 0:
+		s.fileKey, err = wrapFileKeyForRecipients(&s.meta, opts.Recipients)
+	case opts.ShareCount > 0:
+		s.fileKey, s.shares, err = splitFileKeyIntoShares(&s.meta, opts.ShareCount, opts.ShareThreshold)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.signer, err = applySigner(&s.meta, opts.SigningKey); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Shares returns the custodian shares when the key was split.
+func (s *Sealer) Shares() []string { return s.shares }
+
+// ScatterSecret is the secret to scatter the payload with, or "" to embed
+// it in order: recipients and share holders unlock the key without the
+// password and may not know it, so they could not find a payload it
+// scattered.
+func (s *Sealer) ScatterSecret() string {
+	if s.meta.KeyMode != "" {
+		return ""
+	}
+	return s.opts.Secret
+}
+
+// KeyCheck is the key check for the container Seal produced, to be set as
+// engine.Engine.KeyCheck; it is nil when no password derives the key.
+func (s *Sealer) KeyCheck() []byte { return s.keyCheck }
+
+// RequiredBytes is the number of bytes the carrier must be able to embed.
+func (s *Sealer) RequiredBytes() int {
+	metaJSON, _ := json.Marshal(s.meta)
+	return engine.EmbeddedLength(int(estimateRequiredPayloadBytes(s.plainLen, int64(len(metaJSON)), s.meta, s.opts.FEC)))
+}
+
+// Seal encrypts, signs and FEC-wraps the payload. onProgress, if set, is
+// told when key derivation starts (models.StageKDF) and receives the bytes
+// done out of total for encryption (models.StageEncrypt) and error
+// correction (models.StageFEC). Encryption works one chunk at a time, but
+// memory still grows with the payload: the sealed container is built in
+// memory, signed and FEC-wrapped as a whole into a second copy, as the
+// interleaved FEC and scattered embedding need random access to all of it.
+func (s *Sealer) Seal(ctx context.Context, onProgress func(stage string, done, total int64)) ([]byte, error) {
+	if onProgress == nil {
+		onProgress = func(string, int64, int64) {}
+	}
+	meta := s.meta
+	salt, err := crypto.RandomBytes(meta.SaltLength)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := crypto.RandomBytes(meta.NonceLength)
+	if err != nil {
+		return nil, err
+	}
+	key := s.fileKey
+	if key == nil {
+		onProgress(models.StageKDF, 0, 0)
+		key, err = deriveKey(ctx, s.opts.Secret, salt, meta)
+		if err != nil {
+			return nil, err
+		}
+		s.keyCheck = newKeyCheck(meta, salt, key)
+	}
+	header, err := marshalContainerHeader(meta, salt, nonce)
+	if err != nil {
+		return nil, err
+	}
+	fullData := bytes.NewBuffer(make([]byte, 0, len(header)+int(meta.sealedLength(s.plainLen))+meta.SignatureLength))
+	fullData.Write(header)
+	sw, err := crypto.NewStreamWriter(s.opts.Cipher, key, nonce, containerAAD(meta, header), meta.ChunkSize, fullData)
+	if err != nil {
+		return nil, err
+	}
+	onProgress(models.StageEncrypt, 0, s.plainLen)
+	sw.OnChunk = func(done int64) { onProgress(models.StageEncrypt, done, s.plainLen) }
+	if _, err := sw.Write(s.manifestBlock); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(sw, &contextReader{ctx: ctx, r: s.payload.r}); err != nil {
+		return nil, err
+	}
+	if err := sw.Close(); err != nil {
+		return nil, err
+	}
+	if s.signer != nil {
+		fullData.Write(s.signer.Sign(fullData.Bytes()))
+	}
+	onProgress(models.StageFEC, 0, int64(fullData.Len()))
+	ctx = crypto.WithProgress(ctx, func(done, total int64) { onProgress(models.StageFEC, done, total) })
+	return crypto.FECWrapContext(ctx, fullData.Bytes(), s.opts.FEC)
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"errors"
+	"strings"
+
+	"stego/internal/crypto"
+	"stego/internal/models"
+)
+
+const (
+	SignatureNone      = "none"
+	SignatureValid     = "valid"
+	SignatureUntrusted = "untrusted"
+	SignatureInvalid   = "invalid"
+)
+
+var errSignatureInvalid = errors.New("signature verification failed")
+
+type trustedSigner struct {
+	name string
+	key  *crypto.VerifyKey
+}
+
+func applySigner(meta *encryptMetadata, signingKey string) (*crypto.SigningKey, error) {
+	if strings.TrimSpace(signingKey) == "" {
+		return nil, nil
+	}
+	k, err := crypto.ParseSigningKey(signingKey)
+	if err != nil {
+		return nil, err
+	}
+	pub := k.Public()
+	meta.SignerKeyID = pub.KeyID()
+	meta.SignerPublicKey = pub.String()
+	meta.SignatureLength = crypto.SignatureSize
+	return k, nil
+}
+
+func parseTrustedSigners(list string) map[string]trustedSigner {
+	out := map[string]trustedSigner{}
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, keyStr := "", line
+		if i := strings.LastIndex(line, "="); i >= 0 {
+			name, keyStr = strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		}
+		key, err := crypto.ParseVerifyKey(keyStr)
+		if err != nil {
+			continue
+		}
+		out[key.KeyID()] = trustedSigner{name: name, key: key}
+	}
+	return out
+}
+
+func verifyContainerSignature(c *container, trusted map[string]trustedSigner, res *models.DecryptResult) error {
+	res.SignatureStatus = SignatureNone
+	if c.meta.SignatureLength == 0 {
+		return nil
+	}
+	res.SignerKeyID = c.meta.SignerKeyID
+	key, err := crypto.ParseVerifyKey(c.meta.SignerPublicKey)
+	if err != nil || key.KeyID() != c.meta.SignerKeyID || !key.Verify(c.signed, c.signature) {
+		res.SignatureStatus = SignatureInvalid
+		return errSignatureInvalid
+	}
+	if t, ok := trusted[key.KeyID()]; ok {
+		res.SignatureStatus = SignatureValid
+		res.SignerName = t.name
+		return nil
+	}
+	res.SignatureStatus = SignatureUntrusted
+	return nil
+}
@@ -16,17 +16,33 @@ const (
 	KeyDefaultEncryptPassword   = "defaultEncryptPassword"
 	KeyDefaultDecryptPassword   = "defaultDecryptPassword"
 	KeyDefaultEncryptOutputName = "defaultEncryptOutputName"
+	KeyDefaultCipher            = "defaultCipher"
+	KeyDefaultECC               = "defaultEcc"
+	KeyDefaultCompression       = "defaultCompression"
+	KeyDefaultIdentity          = "defaultIdentity"
+	KeyDefaultSigningKey        = "defaultSigningKey"
+	KeyTrustedSigners           = "trustedSigners"
 	KeyAuthor                   = "author"
 	KeyRepository               = "repository"
 	KeyContact                  = "contact"
+	KeyKDFTargetMs              = "kdfTargetMs"
+	KeyKDFMaxMemoryMB           = "kdfMaxMemoryMB"
+	KeyKDFArgon2Time            = "kdfArgon2Time"
+	KeyKDFArgon2MemoryKiB       = "kdfArgon2MemoryKiB"
+	KeyKDFArgon2Threads         = "kdfArgon2Threads"
 	defaultCarrierDirValue      = "./images"
 	defaultOutputDirValue       = "./output"
 	defaultEncryptPasswordVal   = ""
 	defaultDecryptPasswordVal   = ""
 	defaultEncryptOutputNameVal = "encrypted"
+	defaultCipherValue          = "AES-GCM"
+	defaultECCValue             = "32"
+	defaultCompressionValue     = "zstd"
 	defaultAuthorValue          = ""
 	defaultRepositoryValue      = ""
 	defaultContactValue         = ""
+	defaultKDFTargetMsValue     = "1000"
+	defaultKDFMaxMemoryMBValue  = "256"
 	schemaInit                  = `CREATE TABLE IF NOT EXISTS kv (k TEXT PRIMARY KEY, v TEXT NOT NULL);`
 )
 
@@ -75,6 +91,15 @@ func (s *Store) GetAllWithDefaults() map[string]string {
 	if _, ok := m[KeyDefaultEncryptOutputName]; !ok {
 		m[KeyDefaultEncryptOutputName] = defaultEncryptOutputNameVal
 	}
+	if m[KeyDefaultCipher] == "" {
+		m[KeyDefaultCipher] = defaultCipherValue
+	}
+	if m[KeyDefaultECC] == "" {
+		m[KeyDefaultECC] = defaultECCValue
+	}
+	if m[KeyDefaultCompression] == "" {
+		m[KeyDefaultCompression] = defaultCompressionValue
+	}
 	if _, ok := m[KeyAuthor]; !ok {
 		m[KeyAuthor] = defaultAuthorValue
 	}
@@ -84,6 +109,12 @@ func (s *Store) GetAllWithDefaults() map[string]string {
 	if _, ok := m[KeyContact]; !ok {
 		m[KeyContact] = defaultContactValue
 	}
+	if m[KeyKDFTargetMs] == "" {
+		m[KeyKDFTargetMs] = defaultKDFTargetMsValue
+	}
+	if m[KeyKDFMaxMemoryMB] == "" {
+		m[KeyKDFMaxMemoryMB] = defaultKDFMaxMemoryMBValue
+	}
 	return m
 }
 
@@ -13,7 +13,9 @@ type AESGCMConfig struct {
 	SaltLength int
 	NonceLen   int
 	TagLen     int
+	KDF        string
 	Iterations int
+	Argon2     Argon2Params
 }
 
 func DefaultAESGCMConfig() AESGCMConfig {
@@ -22,7 +24,9 @@ func DefaultAESGCMConfig() AESGCMConfig {
 		SaltLength: 16,
 		NonceLen:   12,
 		TagLen:     16,
+		KDF:        KDFArgon2id,
 		Iterations: 50000,
+		Argon2:     DefaultArgon2Params(),
 	}
 }
 
@@ -60,7 +64,11 @@ func DecryptAESGCM(key []byte, nonce []byte, ciphertext []byte, tag []byte) ([]b
 		return nil, errors.New("invalid nonce length")
 	}
 	combined := append(append([]byte{}, ciphertext...), tag...)
-	return gcm.Open(nil, nonce, combined, nil)
+	plain, err := gcm.Open(nil, nonce, combined, nil)
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+	return plain, nil
 }
 
 func RandomBytes(n int) ([]byte, error) {
@@ -0,0 +1,60 @@
+package crypto
+
+import (
+	"errors"
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const minCalibrationMemoryKiB = 8 * 1024
+
+func CalibrateArgon2id(target time.Duration, maxMemoryKiB uint32, threads uint8) (Argon2Params, time.Duration, error) {
+	if target <= 0 {
+		return Argon2Params{}, 0, errors.New("calibration target must be > 0")
+	}
+	if threads == 0 {
+		n := runtime.NumCPU()
+		if n > 4 {
+			n = 4
+		}
+		threads = uint8(n)
+	}
+	mem := maxMemoryKiB
+	if mem > MaxArgon2MemoryKiB {
+		mem = MaxArgon2MemoryKiB
+	}
+	if mem < minCalibrationMemoryKiB {
+		mem = minCalibrationMemoryKiB
+	}
+
+	salt, err := RandomBytes(16)
+	if err != nil {
+		return Argon2Params{}, 0, err
+	}
+	measure := func(memKiB uint32) time.Duration {
+		t0 := time.Now()
+		_ = argon2.IDKey([]byte("stego-calibration"), salt, 1, memKiB, threads, 32)
+		return time.Since(t0)
+	}
+
+	perPass := measure(mem)
+	for perPass > target && mem/2 >= minCalibrationMemoryKiB {
+		mem /= 2
+		perPass = measure(mem)
+	}
+	if perPass <= 0 {
+		perPass = time.Millisecond
+	}
+
+	passes := uint32(target / perPass)
+	if passes < 1 {
+		passes = 1
+	}
+	if passes > MaxArgon2Time {
+		passes = MaxArgon2Time
+	}
+	p := Argon2Params{Time: passes, MemoryKiB: mem, Threads: threads}
+	return p, perPass * time.Duration(passes), p.Validate()
+}
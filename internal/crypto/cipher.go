@@ -0,0 +1,119 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	CipherAESGCM            = "AES-GCM"
+	CipherChaCha20Poly1305  = "ChaCha20-Poly1305"
+	CipherXChaCha20Poly1305 = "XChaCha20-Poly1305"
+)
+
+// ErrAuthFailed means a ciphertext failed authentication: the key is wrong
+// or the ciphertext, tag or associated data were altered.
+var ErrAuthFailed = errors.New("message authentication failed")
+
+type CipherSpec struct {
+	Name      string
+	KeySize   int
+	NonceSize int
+	TagSize   int
+	New       func(key []byte) (cipher.AEAD, error)
+}
+
+var cipherRegistry = map[string]CipherSpec{
+	CipherAESGCM: {
+		Name:      CipherAESGCM,
+		KeySize:   32,
+		NonceSize: 12,
+		TagSize:   16,
+		New: func(key []byte) (cipher.AEAD, error) {
+			block, err := aes.NewCipher(key)
+			if err != nil {
+				return nil, err
+			}
+			return cipher.NewGCM(block)
+		},
+	},
+	CipherChaCha20Poly1305: {
+		Name:      CipherChaCha20Poly1305,
+		KeySize:   chacha20poly1305.KeySize,
+		NonceSize: chacha20poly1305.NonceSize,
+		TagSize:   chacha20poly1305.Overhead,
+		New:       chacha20poly1305.New,
+	},
+	CipherXChaCha20Poly1305: {
+		Name:      CipherXChaCha20Poly1305,
+		KeySize:   chacha20poly1305.KeySize,
+		NonceSize: chacha20poly1305.NonceSizeX,
+		TagSize:   chacha20poly1305.Overhead,
+		New:       chacha20poly1305.NewX,
+	},
+}
+
+var cipherAliases = map[string]string{
+	"aes-256-gcm":        CipherAESGCM,
+	"aes-gcm":            CipherAESGCM,
+	"chacha20-poly1305":  CipherChaCha20Poly1305,
+	"xchacha20-poly1305": CipherXChaCha20Poly1305,
+}
+
+func LookupCipher(name string) (CipherSpec, error) {
+	if spec, ok := cipherRegistry[name]; ok {
+		return spec, nil
+	}
+	if canonical, ok := cipherAliases[strings.ToLower(strings.TrimSpace(name))]; ok {
+		return cipherRegistry[canonical], nil
+	}
+	return CipherSpec{}, fmt.Errorf("unsupported encryption algorithm: %q", name)
+}
+
+func CipherNames() []string {
+	return []string{CipherAESGCM, CipherChaCha20Poly1305, CipherXChaCha20Poly1305}
+}
+
+func (s CipherSpec) Seal(key, nonce, plaintext, aad []byte) (ciphertext []byte, tag []byte, err error) {
+	aead, err := s.aead(key, nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	combined := aead.Seal(nil, nonce, plaintext, aad)
+	if len(combined) < aead.Overhead() {
+		return nil, nil, errors.New("ciphertext too short")
+	}
+	return combined[:len(combined)-aead.Overhead()], combined[len(combined)-aead.Overhead():], nil
+}
+
+func (s CipherSpec) Open(key, nonce, ciphertext, tag, aad []byte) ([]byte, error) {
+	aead, err := s.aead(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	combined := append(append([]byte{}, ciphertext...), tag...)
+	plain, err := aead.Open(nil, nonce, combined, aad)
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+	return plain, nil
+}
+
+func (s CipherSpec) aead(key, nonce []byte) (cipher.AEAD, error) {
+	if len(key) != s.KeySize {
+		return nil, errors.New("invalid key length")
+	}
+	aead, err := s.New(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce length")
+	}
+	return aead, nil
+}
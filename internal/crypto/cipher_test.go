@@ -0,0 +1,43 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCipherRegistryRoundTrip(t *testing.T) {
+	plain := []byte("cipher agility round trip")
+	aad := []byte("header")
+	for _, name := range CipherNames() {
+		spec, err := LookupCipher(name)
+		if err != nil {
+			t.Fatalf("%s: lookup failed: %v", name, err)
+		}
+		key := bytes.Repeat([]byte{7}, spec.KeySize)
+		nonce := bytes.Repeat([]byte{9}, spec.NonceSize)
+		ct, tag, err := spec.Seal(key, nonce, plain, aad)
+		if err != nil {
+			t.Fatalf("%s: seal failed: %v", name, err)
+		}
+		if len(tag) != spec.TagSize {
+			t.Fatalf("%s: tag size %d, want %d", name, len(tag), spec.TagSize)
+		}
+		got, err := spec.Open(key, nonce, ct, tag, aad)
+		if err != nil {
+			t.Fatalf("%s: open failed: %v", name, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("%s: plaintext mismatch", name)
+		}
+	}
+}
+
+func TestLookupCipherRejectsUnknown(t *testing.T) {
+	if _, err := LookupCipher("DES-CBC"); err == nil {
+		t.Fatalf("expected unknown cipher to be rejected")
+	}
+	spec, err := LookupCipher("aes-256-gcm")
+	if err != nil || spec.Name != CipherAESGCM {
+		t.Fatalf("alias lookup failed: %v", err)
+	}
+}
@@ -2,8 +2,14 @@ package crypto
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 var (
@@ -13,69 +19,223 @@ var (
 const (
 	RSK    = 223
 	RSNSym = 32
+
+	rsBlockMax = 255
+
+	rsMinRowsPerWorker = 64
+	rsTileRows         = 64
+	eccHeaderLen       = 3 + 2 + 2 + 4
+	eccFrameLenLen     = 4
 )
 
+// RSParams selects the Reed-Solomon code used by ECCWrapRSParams. K+NSym may be
+// below 255, giving a shortened code with the same correction power per block.
+type RSParams struct {
+	K    int
+	NSym int
+}
+
+func DefaultRSParams() RSParams {
+	return RSParams{K: RSK, NSym: RSNSym}
+}
+
+func RSParityLevels() []int {
+	return []int{16, 32, 64, 128}
+}
+
+func (p RSParams) Validate() error {
+	if p.NSym < 2 || p.NSym%2 != 0 {
+		return fmt.Errorf("invalid ecc parity symbols: %d", p.NSym)
+	}
+	if p.K < 1 || p.K+p.NSym > rsBlockMax {
+		return fmt.Errorf("invalid ecc code RS(%d,%d)", p.K+p.NSym, p.K)
+	}
+	return nil
+}
+
+func (p RSParams) String() string {
+	return fmt.Sprintf("RS(%d,%d)", p.K+p.NSym, p.K)
+}
+
+// ParseRSParams accepts a bare parity count ("64", full-length code) or an
+// explicit "RS(n,k)" for shortened codes.
+func ParseRSParams(s string) (RSParams, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return DefaultRSParams(), nil
+	}
+	if nsym, err := strconv.Atoi(s); err == nil {
+		p := RSParams{K: rsBlockMax - nsym, NSym: nsym}
+		return p, p.Validate()
+	}
+	var n, k int
+	if _, err := fmt.Sscanf(strings.ToUpper(strings.ReplaceAll(s, " ", "")), "RS(%d,%d)", &n, &k); err != nil {
+		return RSParams{}, fmt.Errorf("invalid ecc setting: %q", s)
+	}
+	p := RSParams{K: k, NSym: n - k}
+	return p, p.Validate()
+}
+
+// ECCWrappedLen is the exact size ECCWrapRSParams produces for dataLen bytes.
+func ECCWrappedLen(dataLen int64, p RSParams) int64 {
+	framedLen := eccFrameLenLen + dataLen
+	blocks := (framedLen + int64(p.K) - 1) / int64(p.K)
+	return eccHeaderLen + blocks*int64(p.K+p.NSym)
+}
+
 func ECCWrapRS(data []byte) ([]byte, error) {
-	framed := make([]byte, 4+len(data))
-	binary.LittleEndian.PutUint32(framed[0:4], uint32(len(data)))
-	copy(framed[4:], data)
-
-	blocks := (len(framed) + RSK - 1) / RSK
-	cwLen := RSK + RSNSym
-
-	codewords := make([][]byte, blocks)
-	for i := 0; i < blocks; i++ {
-		chunk := framed[i*RSK:]
-		if len(chunk) > RSK {
-			chunk = chunk[:RSK]
-		}
-		if len(chunk) < RSK {
-			padded := make([]byte, RSK)
-			copy(padded, chunk)
-			chunk = padded
+	return ECCWrapRSParams(data, DefaultRSParams())
+}
+
+func ECCWrapRSParams(data []byte, p RSParams) ([]byte, error) {
+	return ECCWrapRSParamsContext(context.Background(), data, p)
+}
+
+// ECCWrapRSParamsContext is ECCWrapRSParams, checking ctx between tiles of
+// codewords.
+func ECCWrapRSParamsContext(ctx context.Context, data []byte, p RSParams) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	framedLen := eccFrameLenLen + len(data)
+	blocks := (framedLen + p.K - 1) / p.K
+	cwLen := p.K + p.NSym
+
+	out := make([]byte, eccHeaderLen+blocks*cwLen)
+	copy(out[0:3], eccMagic)
+	binary.LittleEndian.PutUint16(out[3:5], uint16(p.K))
+	binary.LittleEndian.PutUint16(out[5:7], uint16(p.NSym))
+	binary.LittleEndian.PutUint32(out[7:11], uint32(framedLen))
+
+	var prefix [eccFrameLenLen]byte
+	binary.LittleEndian.PutUint32(prefix[:], uint32(len(data)))
+	gen := rsGenerator(p.NSym)
+	payload := out[eccHeaderLen:]
+	progress := newProgressCounter(ctx, int64(len(data)))
+	err := rsParallel(blocks, func(lo, hi int) error {
+		tile := make([]byte, rsTileRows*cwLen)
+		for row0 := lo; row0 < hi; row0 += rsTileRows {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rows := min(rsTileRows, hi-row0)
+			for r := 0; r < rows; r++ {
+				cw := tile[r*cwLen : (r+1)*cwLen]
+				rsFramedBlock(cw[:p.K], prefix[:], data, (row0+r)*p.K)
+				rsEncodeParity(cw[:p.K], gen, cw[p.K:])
+			}
+			for col := 0; col < cwLen; col++ {
+				dst := payload[col*blocks+row0 : col*blocks+row0+rows]
+				for r := range dst {
+					dst[r] = tile[r*cwLen+col]
+				}
+			}
+			progress.add(int64(min(rows*p.K, max(0, len(data)-row0*p.K))))
 		}
-		cw := rsEncode(chunk, RSNSym)
-		codewords[i] = cw
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return out, nil
+}
 
-	payload := rsInterleave(codewords, cwLen)
-	header := make([]byte, 3+2+2+4)
-	copy(header[0:3], eccMagic)
-	binary.LittleEndian.PutUint16(header[3:5], uint16(RSK))
-	binary.LittleEndian.PutUint16(header[5:7], uint16(RSNSym))
-	binary.LittleEndian.PutUint32(header[7:11], uint32(len(framed)))
+// rsFramedBlock copies bytes [off, off+len(dst)) of prefix||data into dst,
+// zero-padding past the end, so the framed buffer is never materialised.
+func rsFramedBlock(dst, prefix, data []byte, off int) {
+	n := 0
+	if off < len(prefix) {
+		n = copy(dst, prefix[off:])
+		off = 0
+	} else {
+		off -= len(prefix)
+	}
+	if off < len(data) {
+		n += copy(dst[n:], data[off:])
+	}
+	for i := n; i < len(dst); i++ {
+		dst[i] = 0
+	}
+}
 
-	return append(header, payload...), nil
+func IsECCWrapped(blob []byte) bool {
+	return bytes.HasPrefix(blob, eccMagic)
 }
 
 func ECCUnwrapRS(blob []byte) ([]byte, error) {
+	return ECCUnwrapRSErasures(blob, nil)
+}
+
+// ECCUnwrapRSErasures is ECCUnwrapRS with a hint of which blob bytes are known
+// to be unreliable (erased[i] for blob[i]). Erased symbols cost one parity
+// symbol instead of two, so up to nsym of them per codeword can be repaired.
+func ECCUnwrapRSErasures(blob []byte, erased []bool) ([]byte, error) {
+	return ECCUnwrapRSErasuresContext(context.Background(), blob, erased)
+}
+
+// ECCUnwrapRSErasuresContext is ECCUnwrapRSErasures, checking ctx between
+// tiles of codewords.
+func ECCUnwrapRSErasuresContext(ctx context.Context, blob []byte, erased []bool) ([]byte, error) {
 	if !bytes.HasPrefix(blob, eccMagic) {
 		return blob, nil
 	}
-	if len(blob) < 11 {
+	if len(blob) < eccHeaderLen {
 		return nil, errors.New("ecc header corrupted")
 	}
 	k := int(binary.LittleEndian.Uint16(blob[3:5]))
 	nsym := int(binary.LittleEndian.Uint16(blob[5:7]))
 	framedLen := int(binary.LittleEndian.Uint32(blob[7:11]))
-	if k != RSK || nsym != RSNSym {
+	if (RSParams{K: k, NSym: nsym}).Validate() != nil {
 		return nil, errors.New("unsupported ecc parameters")
 	}
 	cwLen := k + nsym
-	interleaved := blob[11:]
+	interleaved := blob[eccHeaderLen:]
 	if len(interleaved)%cwLen != 0 {
 		return nil, errors.New("ecc payload length invalid")
 	}
 	blocks := len(interleaved) / cwLen
-	codewords := rsDeinterleave(interleaved, blocks, cwLen)
+	erasures := rsDeinterleaveErasures(erased, eccHeaderLen, blocks, cwLen)
 
-	decoded := make([]byte, 0, blocks*k)
-	for _, cw := range codewords {
-		msg, err := rsDecode(cw, k, nsym)
-		if err != nil {
-			return nil, err
+	decoded := make([]byte, blocks*k)
+	genMul := rsGenerator(nsym)
+	progress := newProgressCounter(ctx, int64(len(interleaved)))
+	err := rsParallel(blocks, func(lo, hi int) error {
+		tile := make([]byte, rsTileRows*cwLen)
+		parity := make([]byte, nsym)
+		for row0 := lo; row0 < hi; row0 += rsTileRows {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rows := min(rsTileRows, hi-row0)
+			for col := 0; col < cwLen; col++ {
+				src := interleaved[col*blocks+row0 : col*blocks+row0+rows]
+				for r, c := range src {
+					tile[r*cwLen+col] = c
+				}
+			}
+			for r := 0; r < rows; r++ {
+				row := row0 + r
+				cw := tile[r*cwLen : (r+1)*cwLen]
+				dst := decoded[row*k : (row+1)*k]
+				if len(erasures[row]) == 0 && rsCodewordValid(cw, k, genMul, parity) {
+					copy(dst, cw[:k])
+					continue
+				}
+				msg, err := rsDecodeErasures(cw, k, nsym, erasures[row])
+				if err != nil && len(erasures[row]) > 0 {
+					msg, err = rsDecode(cw, k, nsym)
+				}
+				if err != nil {
+					return err
+				}
+				copy(dst, msg)
+			}
+			progress.add(int64(rows * cwLen))
 		}
-		decoded = append(decoded, msg...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	if framedLen < 4 || framedLen > len(decoded) {
 		return nil, errors.New("ecc decoded length invalid")
@@ -90,27 +250,49 @@ func ECCUnwrapRS(blob []byte) ([]byte, error) {
 	return out, nil
 }
 
-func rsInterleave(codewords [][]byte, cwLen int) []byte {
-	blocks := len(codewords)
-	out := make([]byte, 0, blocks*cwLen)
-	for col := 0; col < cwLen; col++ {
-		for row := 0; row < blocks; row++ {
-			out = append(out, codewords[row][col])
+// rsParallel splits [0, n) codeword rows into contiguous ranges, one per
+// GOMAXPROCS worker. The returned error is that of the lowest failing range,
+// so results do not depend on scheduling.
+func rsParallel(n int, fn func(lo, hi int) error) error {
+	workers := runtime.GOMAXPROCS(0)
+	if max := n / rsMinRowsPerWorker; workers > max {
+		workers = max
+	}
+	if workers <= 1 {
+		return fn(0, n)
+	}
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		lo, hi := n*w/workers, n*(w+1)/workers
+		wg.Add(1)
+		go func(w, lo, hi int) {
+			defer wg.Done()
+			errs[w] = fn(lo, hi)
+		}(w, lo, hi)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
 		}
 	}
-	return out
+	return nil
 }
 
-func rsDeinterleave(interleaved []byte, blocks, cwLen int) [][]byte {
-	out := make([][]byte, blocks)
-	for i := range out {
-		out[i] = make([]byte, cwLen)
+func rsDeinterleaveErasures(erased []bool, offset, blocks, cwLen int) [][]int {
+	out := make([][]int, blocks)
+	if len(erased) <= offset {
+		return out
+	}
+	erased = erased[offset:]
+	if len(erased) > blocks*cwLen {
+		erased = erased[:blocks*cwLen]
 	}
-	idx := 0
-	for col := 0; col < cwLen; col++ {
-		for row := 0; row < blocks; row++ {
-			out[row][col] = interleaved[idx]
-			idx++
+	for idx, bad := range erased {
+		if bad {
+			row := idx % blocks
+			out[row] = append(out[row], idx/blocks)
 		}
 	}
 	return out
@@ -1,7 +1,12 @@
 package crypto
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"math/rand"
+	"runtime"
 	"testing"
 )
 
@@ -38,3 +43,238 @@ func TestECCWrapUnwrapRoundTrip(t *testing.T) {
 		t.Fatalf("unwrap mismatch")
 	}
 }
+
+func TestRSCorrectsUpToHalfParity(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	for _, nsym := range RSParityLevels() {
+		k := 255 - nsym
+		msg := make([]byte, k)
+		rng.Read(msg)
+		cw := rsEncode(msg, nsym)
+		for _, p := range rng.Perm(len(cw))[:nsym/2] {
+			cw[p] ^= byte(rng.Intn(255) + 1)
+		}
+		decoded, err := rsDecode(cw, k, nsym)
+		if err != nil {
+			t.Fatalf("nsym=%d: decode failed: %v", nsym, err)
+		}
+		if string(decoded) != string(msg) {
+			t.Fatalf("nsym=%d: decoded mismatch", nsym)
+		}
+	}
+}
+
+func TestECCWrapParamsSurvivesBurst(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	data := make([]byte, 3000)
+	rng.Read(data)
+	for _, setting := range []string{"16", "32", "64", "128", "RS(128,96)"} {
+		p, err := ParseRSParams(setting)
+		if err != nil {
+			t.Fatalf("%s: parse failed: %v", setting, err)
+		}
+		wrapped, err := ECCWrapRSParams(data, p)
+		if err != nil {
+			t.Fatalf("%s: wrap failed: %v", setting, err)
+		}
+		if int64(len(wrapped)) != ECCWrappedLen(int64(len(data)), p) {
+			t.Fatalf("%s: wrapped length %d, want %d", setting, len(wrapped), ECCWrappedLen(int64(len(data)), p))
+		}
+		blocks := (len(wrapped) - eccHeaderLen) / (p.K + p.NSym)
+		burst := blocks * p.NSym / 2
+		start := eccHeaderLen + 100
+		for i := start; i < start+burst; i++ {
+			wrapped[i] ^= 0x5a
+		}
+		unwrapped, err := ECCUnwrapRS(wrapped)
+		if err != nil {
+			t.Fatalf("%s: unwrap failed: %v", setting, err)
+		}
+		if string(unwrapped) != string(data) {
+			t.Fatalf("%s: unwrap mismatch", setting)
+		}
+	}
+}
+
+func TestParseRSParamsRejectsInvalid(t *testing.T) {
+	for _, s := range []string{"0", "15", "256", "RS(300,200)", "RS(100,120)", "strong"} {
+		if _, err := ParseRSParams(s); err == nil {
+			t.Fatalf("expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestRSErasuresDoubleCorrection(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	for _, nsym := range RSParityLevels() {
+		k := 255 - nsym
+		for errs := 0; errs <= nsym/2; errs += nsym / 8 {
+			erasures := nsym - 2*errs
+			msg := make([]byte, k)
+			rng.Read(msg)
+			cw := rsEncode(msg, nsym)
+			perm := rng.Perm(len(cw))
+			erasePos := perm[:erasures]
+			for _, p := range perm[:erasures+errs] {
+				cw[p] ^= byte(rng.Intn(255) + 1)
+			}
+			decoded, err := rsDecodeErasures(cw, k, nsym, erasePos)
+			if err != nil {
+				t.Fatalf("nsym=%d errors=%d erasures=%d: decode failed: %v", nsym, errs, erasures, err)
+			}
+			if string(decoded) != string(msg) {
+				t.Fatalf("nsym=%d errors=%d erasures=%d: decoded mismatch", nsym, errs, erasures)
+			}
+		}
+	}
+}
+
+// referenceWrap is the original per-block long-division encoder and
+// row-by-column interleaver; the optimised codec must match it byte for byte.
+func referenceWrap(data []byte, p RSParams) []byte {
+	framed := binary.LittleEndian.AppendUint32(nil, uint32(len(data)))
+	framed = append(framed, data...)
+	blocks := (len(framed) + p.K - 1) / p.K
+	gen := rsGeneratorPoly(p.NSym)
+	codewords := make([][]byte, blocks)
+	for i := range codewords {
+		msg := make([]byte, p.K)
+		copy(msg, framed[i*p.K:min((i+1)*p.K, len(framed))])
+		cw := make([]byte, p.K+p.NSym)
+		copy(cw, msg)
+		for j := 0; j < p.K; j++ {
+			coef := cw[j]
+			for g := 1; g < len(gen) && coef != 0; g++ {
+				cw[j+g] ^= gfMul(gen[g], coef)
+			}
+		}
+		copy(cw, msg)
+		codewords[i] = cw
+	}
+	out := []byte("RS1")
+	out = binary.LittleEndian.AppendUint16(out, uint16(p.K))
+	out = binary.LittleEndian.AppendUint16(out, uint16(p.NSym))
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(framed)))
+	for col := 0; col < p.K+p.NSym; col++ {
+		for row := 0; row < blocks; row++ {
+			out = append(out, codewords[row][col])
+		}
+	}
+	return out
+}
+
+func TestECCWrapMatchesReferenceFormat(t *testing.T) {
+	rng := rand.New(rand.NewSource(9))
+	for _, setting := range []string{"16", "32", "128", "RS(128,96)"} {
+		p, _ := ParseRSParams(setting)
+		for _, n := range []int{0, 1, p.K - 4, p.K, 70 * p.K} {
+			data := make([]byte, n)
+			rng.Read(data)
+			got, err := ECCWrapRSParams(data, p)
+			if err != nil {
+				t.Fatalf("%s/%d: wrap failed: %v", setting, n, err)
+			}
+			if !bytes.Equal(got, referenceWrap(data, p)) {
+				t.Fatalf("%s/%d: wrapped bytes differ from reference format", setting, n)
+			}
+		}
+	}
+}
+
+func TestECCParallelCodecMatchesSerial(t *testing.T) {
+	data := benchmarkPayload(300 * RSK)
+	wrapped, err := ECCWrapRS(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(10))
+	for i := 0; i < len(wrapped)/40; i++ {
+		wrapped[eccHeaderLen+rng.Intn(len(wrapped)-eccHeaderLen)] ^= 0x5a
+	}
+
+	prev := runtime.GOMAXPROCS(4)
+	defer runtime.GOMAXPROCS(prev)
+	parallelWrapped, err := ECCWrapRS(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parallelWrapped, referenceWrap(data, DefaultRSParams())) {
+		t.Fatalf("parallel wrap differs from reference format")
+	}
+	got, err := ECCUnwrapRS(wrapped)
+	if err != nil {
+		t.Fatalf("parallel unwrap failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("parallel unwrap mismatch")
+	}
+}
+
+func benchmarkPayload(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(6)).Read(data)
+	return data
+}
+
+func BenchmarkECCWrapRS(b *testing.B) {
+	data := benchmarkPayload(4 << 20)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ECCWrapRS(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkECCUnwrapRS(b *testing.B) {
+	data := benchmarkPayload(4 << 20)
+	wrapped, err := ECCWrapRS(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ECCUnwrapRS(wrapped); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkECCUnwrapRSDamaged(b *testing.B) {
+	data := benchmarkPayload(4 << 20)
+	wrapped, err := ECCWrapRS(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(8))
+	for i := 0; i < len(wrapped)/64; i++ {
+		wrapped[eccHeaderLen+rng.Intn(len(wrapped)-eccHeaderLen)] ^= 0xff
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ECCUnwrapRS(wrapped); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestFECContextStopsWhenCancelled(t *testing.T) {
+	data := make([]byte, 1<<20)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, p := range []FECParams{DefaultFECParams(), {Scheme: FECFountain, LT: DefaultLTParams()}} {
+		if _, err := FECWrapContext(ctx, data, p); !errors.Is(err, context.Canceled) {
+			t.Fatalf("%s wrap: expected context.Canceled, got %v", p, err)
+		}
+		wrapped, err := FECWrap(data, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := FECUnwrapContext(ctx, wrapped, nil); !errors.Is(err, context.Canceled) {
+			t.Fatalf("%s unwrap: expected context.Canceled, got %v", p, err)
+		}
+	}
+}
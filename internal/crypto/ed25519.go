@@ -0,0 +1,81 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+const (
+	SigningPublicKeyPrefix = "stego-sign-pub-"
+	SigningSecretKeyPrefix = "STEGO-SIGN-KEY-"
+	SignatureSize          = ed25519.SignatureSize
+)
+
+type SigningKey struct {
+	priv ed25519.PrivateKey
+}
+
+type VerifyKey struct {
+	pub ed25519.PublicKey
+}
+
+func GenerateSigningKey() (*SigningKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{priv: priv}, nil
+}
+
+func ParseSigningKey(s string) (*SigningKey, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, SigningSecretKeyPrefix) {
+		return nil, errors.New("invalid signing key: missing " + SigningSecretKeyPrefix + " prefix")
+	}
+	seed, err := keyEncoding.DecodeString(s[len(SigningSecretKeyPrefix):])
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("invalid signing key encoding")
+	}
+	return &SigningKey{priv: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+func ParseVerifyKey(s string) (*VerifyKey, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, SigningPublicKeyPrefix) {
+		return nil, errors.New("invalid verify key: missing " + SigningPublicKeyPrefix + " prefix")
+	}
+	raw, err := keyEncoding.DecodeString(s[len(SigningPublicKeyPrefix):])
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid verify key encoding")
+	}
+	return &VerifyKey{pub: ed25519.PublicKey(raw)}, nil
+}
+
+func (k *SigningKey) String() string {
+	return SigningSecretKeyPrefix + keyEncoding.EncodeToString(k.priv.Seed())
+}
+
+func (k *SigningKey) Public() *VerifyKey {
+	return &VerifyKey{pub: k.priv.Public().(ed25519.PublicKey)}
+}
+
+func (k *SigningKey) Sign(msg []byte) []byte {
+	return ed25519.Sign(k.priv, msg)
+}
+
+func (v *VerifyKey) String() string {
+	return SigningPublicKeyPrefix + keyEncoding.EncodeToString(v.pub)
+}
+
+func (v *VerifyKey) KeyID() string {
+	sum := sha256.Sum256(v.pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (v *VerifyKey) Verify(msg, sig []byte) bool {
+	return len(sig) == SignatureSize && ed25519.Verify(v.pub, msg, sig)
+}
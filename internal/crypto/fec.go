@@ -0,0 +1,127 @@
+package crypto
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	FECReedSolomon = "rs"
+	FECFountain    = "lt"
+)
+
+// ErrFECFailed means a blob was too damaged for its error correction to
+// restore it.
+var ErrFECFailed = errors.New("error correction failed")
+
+// FECParams selects the forward error correction wrapped around a container.
+// The choice is recorded by the blob's magic ("RS1" or "LT1"), so unwrapping
+// needs no parameters.
+type FECParams struct {
+	Scheme string
+	RS     RSParams
+	LT     LTParams
+}
+
+func DefaultFECParams() FECParams {
+	return FECParams{Scheme: FECReedSolomon, RS: DefaultRSParams()}
+}
+
+// ParseFEC accepts the Reed-Solomon forms understood by ParseRSParams, or a
+// fountain code as "LT", "LT(overhead%)" or "LT(overhead%,symbolSize)".
+func ParseFEC(s string) (FECParams, error) {
+	s = strings.TrimSpace(s)
+	upper := strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	if !strings.HasPrefix(upper, "LT") {
+		rs, err := ParseRSParams(s)
+		return FECParams{Scheme: FECReedSolomon, RS: rs}, err
+	}
+	lt := DefaultLTParams()
+	switch {
+	case upper == "LT":
+	case strings.Count(upper, ",") == 1:
+		if _, err := fmt.Sscanf(upper, "LT(%d,%d)", &lt.Overhead, &lt.SymbolSize); err != nil {
+			return FECParams{}, fmt.Errorf("invalid ecc setting: %q", s)
+		}
+	default:
+		if _, err := fmt.Sscanf(upper, "LT(%d)", &lt.Overhead); err != nil {
+			return FECParams{}, fmt.Errorf("invalid ecc setting: %q", s)
+		}
+	}
+	return FECParams{Scheme: FECFountain, LT: lt}, lt.Validate()
+}
+
+func (p FECParams) String() string {
+	if p.Scheme == FECFountain {
+		return p.LT.String()
+	}
+	return p.RS.String()
+}
+
+func (p FECParams) WrappedLen(dataLen int64) int64 {
+	if p.Scheme == FECFountain {
+		return LTWrappedLen(dataLen, p.LT)
+	}
+	return ECCWrappedLen(dataLen, p.RS)
+}
+
+func FECWrap(data []byte, p FECParams) ([]byte, error) {
+	return FECWrapContext(context.Background(), data, p)
+}
+
+// FECWrapContext is FECWrap, returning ctx.Err() soon after ctx is done.
+func FECWrapContext(ctx context.Context, data []byte, p FECParams) ([]byte, error) {
+	if p.Scheme == FECFountain {
+		return LTWrapContext(ctx, data, p.LT)
+	}
+	return ECCWrapRSParamsContext(ctx, data, p.RS)
+}
+
+func IsFECWrapped(blob []byte) bool {
+	return IsECCWrapped(blob) || IsLTWrapped(blob)
+}
+
+// FECUnwrap detects the scheme from the blob's magic and decodes it, using
+// erased (indexed like blob, may be nil) as known-bad byte hints. Blobs
+// without a known magic are returned unchanged.
+func FECUnwrap(blob []byte, erased []bool) ([]byte, error) {
+	return FECUnwrapContext(context.Background(), blob, erased)
+}
+
+// FECUnwrapContext is FECUnwrap, returning ctx.Err() soon after ctx is done.
+func FECUnwrapContext(ctx context.Context, blob []byte, erased []bool) ([]byte, error) {
+	var out []byte
+	var err error
+	if IsLTWrapped(blob) {
+		out, err = LTUnwrapContext(ctx, blob, erased)
+	} else {
+		out, err = ECCUnwrapRSErasuresContext(ctx, blob, erased)
+	}
+	if err != nil && ctx.Err() == nil {
+		return nil, fmt.Errorf("%w: %v", ErrFECFailed, err)
+	}
+	return out, err
+}
+
+// DetectFEC reports the parameters a blob was wrapped with, read from its
+// header, without decoding it.
+func DetectFEC(blob []byte) (FECParams, bool) {
+	if IsLTWrapped(blob) {
+		for i := 0; i < ltHeaderCopies; i++ {
+			if off := i * ltHeaderLen; off+ltHeaderLen <= len(blob) {
+				if h, ok := parseLTHeader(blob[off:]); ok {
+					return FECParams{Scheme: FECFountain, LT: LTParams{SymbolSize: h.symbolSize, Overhead: h.overhead}}, true
+				}
+			}
+		}
+		return FECParams{}, false
+	}
+	if IsECCWrapped(blob) && len(blob) >= eccHeaderLen {
+		p := RSParams{K: int(binary.LittleEndian.Uint16(blob[3:5])), NSym: int(binary.LittleEndian.Uint16(blob[5:7]))}
+		return FECParams{Scheme: FECReedSolomon, RS: p}, p.Validate() == nil
+	}
+	return FECParams{}, false
+}
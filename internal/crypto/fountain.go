@@ -0,0 +1,547 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+var ltMagic = []byte("LT1")
+
+// ltCheckEvery is how many symbols are processed between cancellation checks.
+const ltCheckEvery = 1024
+
+const (
+	DefaultLTSymbolSize = 64
+	DefaultLTOverhead   = 50
+
+	ltHeaderLen    = 3 + 2 + 2 + 4 + 4 + 4 + 4 + 4
+	ltHeaderCopies = 2
+	ltSymbolCRCLen = 4
+	ltMaxGaussian  = 1024
+
+	ltSolitonC     = 0.1
+	ltSolitonDelta = 0.05
+
+	// ltMinCover is how many repair symbols every source symbol sits in,
+	// besides the random soliton picks.
+	ltMinCover = 6
+)
+
+// LTParams configures the systematic LT fountain code: every source symbol is
+// sent as-is, followed by Overhead percent extra repair symbols, each the XOR
+// of a pseudo-random set of source symbols. Any large enough subset of intact
+// symbols reconstructs the payload, so loss degrades gracefully instead of
+// failing a whole block at once.
+type LTParams struct {
+	SymbolSize int
+	Overhead   int
+}
+
+func DefaultLTParams() LTParams {
+	return LTParams{SymbolSize: DefaultLTSymbolSize, Overhead: DefaultLTOverhead}
+}
+
+func (p LTParams) Validate() error {
+	if p.SymbolSize < 8 || p.SymbolSize > 4096 {
+		return fmt.Errorf("invalid fountain symbol size: %d", p.SymbolSize)
+	}
+	if p.Overhead < 1 || p.Overhead > 400 {
+		return fmt.Errorf("invalid fountain overhead: %d%%", p.Overhead)
+	}
+	return nil
+}
+
+func (p LTParams) String() string {
+	return fmt.Sprintf("LT(%d,%d)", p.Overhead, p.SymbolSize)
+}
+
+func (p LTParams) counts(dataLen int64) (k, n int64) {
+	k = (dataLen + int64(p.SymbolSize) - 1) / int64(p.SymbolSize)
+	if k == 0 {
+		k = 1
+	}
+	return k, k + (k*int64(p.Overhead)+99)/100
+}
+
+// LTWrappedLen is the exact size LTWrap produces for dataLen bytes.
+func LTWrappedLen(dataLen int64, p LTParams) int64 {
+	_, n := p.counts(dataLen)
+	return ltHeaderLen*ltHeaderCopies + n*int64(p.SymbolSize+ltSymbolCRCLen)
+}
+
+type ltHeader struct {
+	symbolSize int
+	overhead   int
+	dataLen    int
+	k          int
+	n          int
+	seed       uint32
+}
+
+func (h ltHeader) marshal() []byte {
+	out := make([]byte, 0, ltHeaderLen)
+	out = append(out, ltMagic...)
+	out = binary.LittleEndian.AppendUint16(out, uint16(h.symbolSize))
+	out = binary.LittleEndian.AppendUint16(out, uint16(h.overhead))
+	out = binary.LittleEndian.AppendUint32(out, uint32(h.dataLen))
+	out = binary.LittleEndian.AppendUint32(out, uint32(h.k))
+	out = binary.LittleEndian.AppendUint32(out, uint32(h.n))
+	out = binary.LittleEndian.AppendUint32(out, h.seed)
+	return binary.LittleEndian.AppendUint32(out, crc32.ChecksumIEEE(out))
+}
+
+func parseLTHeader(b []byte) (ltHeader, bool) {
+	if len(b) < ltHeaderLen || !bytes.HasPrefix(b, ltMagic) {
+		return ltHeader{}, false
+	}
+	if crc32.ChecksumIEEE(b[:ltHeaderLen-4]) != binary.LittleEndian.Uint32(b[ltHeaderLen-4:ltHeaderLen]) {
+		return ltHeader{}, false
+	}
+	h := ltHeader{
+		symbolSize: int(binary.LittleEndian.Uint16(b[3:5])),
+		overhead:   int(binary.LittleEndian.Uint16(b[5:7])),
+		dataLen:    int(binary.LittleEndian.Uint32(b[7:11])),
+		k:          int(binary.LittleEndian.Uint32(b[11:15])),
+		n:          int(binary.LittleEndian.Uint32(b[15:19])),
+		seed:       binary.LittleEndian.Uint32(b[19:23]),
+	}
+	if h.symbolSize == 0 || h.k <= 0 || h.n < h.k || h.dataLen > h.k*h.symbolSize {
+		return ltHeader{}, false
+	}
+	return h, true
+}
+
+// ltRand is splitmix64. The fountain graph must be reproducible on every
+// platform and Go release, so it does not use math/rand.
+type ltRand uint64
+
+func (r *ltRand) next() uint64 {
+	*r += 0x9e3779b97f4a7c15
+	z := uint64(*r)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (r *ltRand) float() float64 {
+	return float64(r.next()>>11) / (1 << 53)
+}
+
+func (r *ltRand) intn(n int) int {
+	hi, _ := bits.Mul64(r.next(), uint64(n))
+	return int(hi)
+}
+
+// ltSolitonCDF is the cumulative robust soliton distribution over degrees
+// 1..k; cdf[d-1] is P(degree <= d).
+func ltSolitonCDF(k int) []float64 {
+	kf := float64(k)
+	r := ltSolitonC * math.Log(kf/ltSolitonDelta) * math.Sqrt(kf)
+	spike := int(kf / r)
+	if spike < 1 {
+		spike = 1
+	}
+	if spike > k {
+		spike = k
+	}
+	cdf := make([]float64, k)
+	var total float64
+	for d := 1; d <= k; d++ {
+		var p float64
+		if d == 1 {
+			p = 1 / kf
+		} else {
+			p = 1 / (float64(d) * float64(d-1))
+		}
+		if d < spike {
+			p += r / (float64(d) * kf)
+		} else if d == spike {
+			p += r * math.Log(r/ltSolitonDelta) / kf
+		}
+		total += p
+		cdf[d-1] = total
+	}
+	for i := range cdf {
+		cdf[i] /= total
+	}
+	return cdf
+}
+
+// ltGraph generates the source neighbours of each repair symbol: a robust
+// soliton random set plus a deterministic "cover" share. The cover is
+// ltMinCover rounds, each an independent affine permutation of the sources
+// dealt out evenly across the repair symbols, so every source symbol sits in
+// at least ltMinCover repairs and no two sources are likely to share all of
+// them. A source the soliton picks left in one or two repairs would otherwise
+// be lost with them, well inside the overhead.
+type ltGraph struct {
+	k, n   int
+	seed   uint32
+	cdf    []float64
+	rounds [ltMinCover]ltAffine
+}
+
+// ltAffine maps j to (a*j + b) mod k, a permutation when gcd(a, k) == 1.
+type ltAffine struct{ a, b int }
+
+func newLTGraph(h ltHeader) *ltGraph {
+	g := &ltGraph{k: h.k, n: h.n, seed: h.seed, cdf: ltSolitonCDF(h.k)}
+	rng := ltRand(uint64(h.seed))
+	for t := range g.rounds {
+		g.rounds[t] = ltAffine{a: 1}
+		if h.k > 1 {
+			a := int(rng.next()%uint64(h.k)) | 1
+			for gcd(a, h.k) != 1 {
+				a = (a + 2) % h.k
+				if a == 0 {
+					a = 1
+				}
+			}
+			g.rounds[t] = ltAffine{a: a, b: rng.intn(h.k)}
+		}
+	}
+	return g
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// neighbors returns the sorted, distinct source indices of repair symbol id.
+func (g *ltGraph) neighbors(id int, dst []int) []int {
+	dst = dst[:0]
+	add := func(v int) {
+		for _, x := range dst {
+			if x == v {
+				return
+			}
+		}
+		dst = append(dst, v)
+	}
+	// Repair r of R takes positions [ceil(r*k/R), ceil((r+1)*k/R)) of every
+	// round, so each round hands out every source exactly once.
+	r, repairs, k := int64(id-g.k), int64(g.n-g.k), int64(g.k)
+	lo := (r*k + repairs - 1) / repairs
+	hi := ((r+1)*k + repairs - 1) / repairs
+	for _, p := range g.rounds {
+		for j := lo; j < hi; j++ {
+			add(int((int64(p.a)*j + int64(p.b)) % k))
+		}
+	}
+	rng := ltRand(uint64(g.seed)<<32 | uint64(uint32(id)))
+	degree := sort.SearchFloat64s(g.cdf, rng.float()) + 1
+	if degree > g.k {
+		degree = g.k
+	}
+	for picked := 0; picked < degree && len(dst) < g.k; {
+		before := len(dst)
+		add(rng.intn(g.k))
+		if len(dst) > before {
+			picked++
+		}
+	}
+	sort.Ints(dst)
+	return dst
+}
+
+func xorInto(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+func LTWrap(data []byte, p LTParams) ([]byte, error) {
+	return LTWrapContext(context.Background(), data, p)
+}
+
+// LTWrapContext is LTWrap, checking ctx every ltCheckEvery symbols.
+func LTWrapContext(ctx context.Context, data []byte, p LTParams) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	k64, n64 := p.counts(int64(len(data)))
+	if n64 > math.MaxUint32 {
+		return nil, errors.New("payload too large for fountain code")
+	}
+	k, n := int(k64), int(n64)
+	h := ltHeader{
+		symbolSize: p.SymbolSize,
+		overhead:   p.Overhead,
+		dataLen:    len(data),
+		k:          k,
+		n:          n,
+		seed:       crc32.ChecksumIEEE(data),
+	}
+	header := h.marshal()
+	slot := p.SymbolSize + ltSymbolCRCLen
+	out := make([]byte, 0, LTWrappedLen(int64(len(data)), p))
+	for i := 0; i < ltHeaderCopies; i++ {
+		out = append(out, header...)
+	}
+
+	source := make([]byte, k*p.SymbolSize)
+	copy(source, data)
+	sym := func(i int) []byte { return source[i*p.SymbolSize : (i+1)*p.SymbolSize] }
+	for i := 0; i < k; i++ {
+		out = append(out, sym(i)...)
+		out = binary.LittleEndian.AppendUint32(out, crc32.ChecksumIEEE(sym(i)))
+	}
+	g := newLTGraph(h)
+	var nbrs []int
+	// Progress counts repair bytes, the only part that takes real work.
+	progress := newProgressCounter(ctx, int64(n-k)*int64(p.SymbolSize))
+	reported := k
+	for id := k; id < n; id++ {
+		if id%ltCheckEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			progress.add(int64(id-reported) * int64(p.SymbolSize))
+			reported = id
+		}
+		start := len(out)
+		out = out[:start+slot]
+		repair := out[start : start+p.SymbolSize]
+		clear(repair)
+		nbrs = g.neighbors(id, nbrs)
+		for _, v := range nbrs {
+			xorInto(repair, sym(v))
+		}
+		binary.LittleEndian.PutUint32(out[start+p.SymbolSize:], crc32.ChecksumIEEE(repair))
+	}
+	progress.add(int64(n-reported) * int64(p.SymbolSize))
+	return out, nil
+}
+
+func IsLTWrapped(blob []byte) bool {
+	return bytes.HasPrefix(blob, ltMagic)
+}
+
+// LTUnwrap reassembles the payload from every symbol whose CRC matches and
+// that erased (same indexing as blob, may be nil) does not mark. Source
+// symbols are recovered by peeling; a small remainder is solved by Gaussian
+// elimination over GF(2).
+func LTUnwrap(blob []byte, erased []bool) ([]byte, error) {
+	return LTUnwrapContext(context.Background(), blob, erased)
+}
+
+// LTUnwrapContext is LTUnwrap, checking ctx every ltCheckEvery symbols.
+func LTUnwrapContext(ctx context.Context, blob []byte, erased []bool) ([]byte, error) {
+	var h ltHeader
+	ok := false
+	for i := 0; i < ltHeaderCopies && !ok; i++ {
+		off := i * ltHeaderLen
+		if off+ltHeaderLen <= len(blob) && !anyErased(erased, off, off+ltHeaderLen) {
+			h, ok = parseLTHeader(blob[off:])
+		}
+	}
+	if !ok {
+		return nil, errors.New("fountain header corrupted")
+	}
+	slot := h.symbolSize + ltSymbolCRCLen
+	body := ltHeaderLen * ltHeaderCopies
+	if int64(len(blob)-body) < int64(h.n)*int64(slot) {
+		return nil, errors.New("fountain payload length invalid")
+	}
+	intact := func(id int) []byte {
+		off := body + id*slot
+		if anyErased(erased, off, off+slot) {
+			return nil
+		}
+		s := blob[off : off+h.symbolSize]
+		if crc32.ChecksumIEEE(s) != binary.LittleEndian.Uint32(blob[off+h.symbolSize:off+slot]) {
+			return nil
+		}
+		return s
+	}
+
+	source := make([]byte, h.k*h.symbolSize)
+	known := make([]bool, h.k)
+	missing := h.k
+	// Progress counts the source symbols checked; recovery, when needed,
+	// reports nothing further.
+	progress := newProgressCounter(ctx, int64(h.k)*int64(h.symbolSize))
+	for i := 0; i < h.k; i++ {
+		if i%ltCheckEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			progress.add(int64(min(ltCheckEvery, h.k-i)) * int64(h.symbolSize))
+		}
+		if s := intact(i); s != nil {
+			copy(source[i*h.symbolSize:], s)
+			known[i] = true
+			missing--
+		}
+	}
+	if missing > 0 {
+		if err := ltRecover(ctx, h, source, known, missing, intact); err != nil {
+			return nil, err
+		}
+	}
+	return source[:h.dataLen], nil
+}
+
+type ltEquation struct {
+	nbrs  []int
+	value []byte
+}
+
+func ltRecover(ctx context.Context, h ltHeader, source []byte, known []bool, missing int, intact func(int) []byte) error {
+	sym := func(i int) []byte { return source[i*h.symbolSize : (i+1)*h.symbolSize] }
+	g := newLTGraph(h)
+	var eqs []*ltEquation
+	users := make(map[int][]*ltEquation)
+	for id := h.k; id < h.n; id++ {
+		if id%ltCheckEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		s := intact(id)
+		if s == nil {
+			continue
+		}
+		eq := &ltEquation{value: append([]byte{}, s...)}
+		for _, v := range g.neighbors(id, nil) {
+			if known[v] {
+				xorInto(eq.value, sym(v))
+			} else {
+				eq.nbrs = append(eq.nbrs, v)
+			}
+		}
+		if len(eq.nbrs) == 0 {
+			continue
+		}
+		eqs = append(eqs, eq)
+		for _, v := range eq.nbrs {
+			users[v] = append(users[v], eq)
+		}
+	}
+
+	queue := make([]*ltEquation, 0, len(eqs))
+	for _, eq := range eqs {
+		if len(eq.nbrs) == 1 {
+			queue = append(queue, eq)
+		}
+	}
+	for len(queue) > 0 {
+		eq := queue[0]
+		queue = queue[1:]
+		if len(eq.nbrs) != 1 {
+			continue
+		}
+		v := eq.nbrs[0]
+		if known[v] {
+			continue
+		}
+		copy(sym(v), eq.value)
+		known[v] = true
+		missing--
+		for _, other := range users[v] {
+			for i, x := range other.nbrs {
+				if x == v {
+					other.nbrs = append(other.nbrs[:i], other.nbrs[i+1:]...)
+					xorInto(other.value, sym(v))
+					if len(other.nbrs) == 1 {
+						queue = append(queue, other)
+					}
+					break
+				}
+			}
+		}
+		delete(users, v)
+	}
+	if missing == 0 {
+		return nil
+	}
+	if missing > ltMaxGaussian {
+		return fmt.Errorf("too many fountain symbols lost: %d of %d unrecoverable", missing, h.k)
+	}
+	return ltGaussian(h, source, known, eqs)
+}
+
+// ltGaussian solves the symbols peeling could not reach from the remaining
+// equations, using bitset rows over the unknown columns.
+func ltGaussian(h ltHeader, source []byte, known []bool, eqs []*ltEquation) error {
+	col := make(map[int]int)
+	var unknown []int
+	for i, k := range known {
+		if !k {
+			col[i] = len(unknown)
+			unknown = append(unknown, i)
+		}
+	}
+	words := (len(unknown) + 63) / 64
+	type row struct {
+		bits  []uint64
+		value []byte
+	}
+	var rows []row
+	for _, eq := range eqs {
+		if len(eq.nbrs) == 0 {
+			continue
+		}
+		r := row{bits: make([]uint64, words), value: eq.value}
+		for _, v := range eq.nbrs {
+			c := col[v]
+			r.bits[c/64] ^= 1 << (c % 64)
+		}
+		rows = append(rows, r)
+	}
+	pivotRow := make([]int, len(unknown))
+	next := 0
+	for c := range unknown {
+		p := -1
+		for i := next; i < len(rows); i++ {
+			if rows[i].bits[c/64]&(1<<(c%64)) != 0 {
+				p = i
+				break
+			}
+		}
+		if p < 0 {
+			return fmt.Errorf("too many fountain symbols lost: %d of %d unrecoverable", len(unknown), h.k)
+		}
+		rows[next], rows[p] = rows[p], rows[next]
+		for i := range rows {
+			if i != next && rows[i].bits[c/64]&(1<<(c%64)) != 0 {
+				for w := range rows[i].bits {
+					rows[i].bits[w] ^= rows[next].bits[w]
+				}
+				xorInto(rows[i].value, rows[next].value)
+			}
+		}
+		pivotRow[c] = next
+		next++
+	}
+	for c, v := range unknown {
+		copy(source[v*h.symbolSize:(v+1)*h.symbolSize], rows[pivotRow[c]].value)
+		known[v] = true
+	}
+	return nil
+}
+
+func anyErased(erased []bool, start, end int) bool {
+	if erased == nil {
+		return false
+	}
+	if end > len(erased) {
+		end = len(erased)
+	}
+	for i := start; i < end; i++ {
+		if erased[i] {
+			return true
+		}
+	}
+	return false
+}
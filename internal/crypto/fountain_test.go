@@ -0,0 +1,127 @@
+package crypto
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func corruptLTSymbols(blob []byte, p LTParams, ids []int) {
+	slot := p.SymbolSize + ltSymbolCRCLen
+	for _, id := range ids {
+		blob[ltHeaderLen*ltHeaderCopies+id*slot+3] ^= 0xff
+	}
+}
+
+func TestLTRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	p := DefaultLTParams()
+	for _, n := range []int{0, 1, 63, 64, 65, 10000} {
+		data := make([]byte, n)
+		rng.Read(data)
+		wrapped, err := LTWrap(data, p)
+		if err != nil {
+			t.Fatalf("%d: wrap failed: %v", n, err)
+		}
+		if int64(len(wrapped)) != LTWrappedLen(int64(n), p) {
+			t.Fatalf("%d: wrapped length %d, want %d", n, len(wrapped), LTWrappedLen(int64(n), p))
+		}
+		got, err := FECUnwrap(wrapped, nil)
+		if err != nil {
+			t.Fatalf("%d: unwrap failed: %v", n, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%d: unwrap mismatch", n)
+		}
+	}
+}
+
+func TestLTRecoversFromSymbolLoss(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	data := make([]byte, 64*1000)
+	rng.Read(data)
+	p := LTParams{SymbolSize: 64, Overhead: 50}
+	clean, err := LTWrap(data, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, n := p.counts(int64(len(data)))
+
+	random := append([]byte{}, clean...)
+	corruptLTSymbols(random, p, rng.Perm(int(n))[:int(n)/5])
+
+	burst := append([]byte{}, clean...)
+	ids := make([]int, 0, k/4)
+	for id := int(k) / 3; len(ids) < cap(ids); id++ {
+		ids = append(ids, id)
+	}
+	corruptLTSymbols(burst, p, ids)
+	burst[5] ^= 0xff
+
+	for name, blob := range map[string][]byte{"random": random, "burst": burst} {
+		got, err := LTUnwrap(blob, nil)
+		if err != nil {
+			t.Fatalf("%s: unwrap failed: %v", name, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%s: unwrap mismatch", name)
+		}
+	}
+
+	erased := make([]bool, len(clean))
+	for i := range erased[ltHeaderLen*ltHeaderCopies:] {
+		erased[ltHeaderLen*ltHeaderCopies+i] = i%(p.SymbolSize+ltSymbolCRCLen) == 0 && rng.Intn(2) == 0
+	}
+	if _, err := LTUnwrap(clean, erased); err == nil {
+		t.Fatalf("expected losing half the symbols to exceed a 50%% overhead")
+	}
+}
+
+func TestParseFEC(t *testing.T) {
+	cases := map[string]string{
+		"":           "RS(255,223)",
+		"64":         "RS(255,191)",
+		"RS(128,96)": "RS(128,96)",
+		"lt":         "LT(50,64)",
+		"LT(100)":    "LT(100,64)",
+		"LT(80,128)": "LT(80,128)",
+	}
+	for in, want := range cases {
+		p, err := ParseFEC(in)
+		if err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		if p.String() != want {
+			t.Fatalf("%q parsed as %s, want %s", in, p, want)
+		}
+	}
+	for _, bad := range []string{"LT(0)", "LT(50,4)", "LT(x)"} {
+		if _, err := ParseFEC(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestLTSurvivesRandomLoss(t *testing.T) {
+	// Losing 10% of the symbols is well inside the default 50% overhead, so
+	// every trial must decode, not just most.
+	rng := rand.New(rand.NewSource(3))
+	data := make([]byte, 50*1024)
+	p := DefaultLTParams()
+	_, n := p.counts(int64(len(data)))
+	for trial := 0; trial < 500; trial++ {
+		rng.Read(data)
+		blob, err := LTWrap(data, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		corruptLTSymbols(blob, p, rng.Perm(int(n))[:int(n)/10])
+		got, err := LTUnwrap(blob, nil)
+		if err != nil {
+			t.Fatalf("trial %d: %v", trial, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("trial %d: unwrap mismatch", trial)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	KDFPBKDF2SHA1 = "pbkdf2-sha1"
+	KDFArgon2id   = "argon2id"
+
+	MaxArgon2MemoryKiB = 4 * 1024 * 1024
+	MaxArgon2Time      = 64
+
+	// MaxPBKDF2Iterations is twenty times the default count, about a second
+	// of work, as PBKDF2 is only read from legacy images.
+	MaxPBKDF2Iterations = 1000000
+)
+
+type Argon2Params struct {
+	Time      uint32
+	MemoryKiB uint32
+	Threads   uint8
+}
+
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		Time:      3,
+		MemoryKiB: 64 * 1024,
+		Threads:   4,
+	}
+}
+
+func (p Argon2Params) Validate() error {
+	if p.Time == 0 || p.MemoryKiB == 0 || p.Threads == 0 {
+		return errors.New("argon2 parameters missing")
+	}
+	if p.Time > MaxArgon2Time || p.MemoryKiB > MaxArgon2MemoryKiB {
+		return errors.New("argon2 parameters out of range")
+	}
+	if p.MemoryKiB < 8*uint32(p.Threads) {
+		return errors.New("argon2 memory too small for parallelism")
+	}
+	return nil
+}
+
+func Argon2idKey(password string, salt []byte, p Argon2Params, keyLen int) ([]byte, error) {
+	return Argon2idKeyContext(context.Background(), password, salt, p, keyLen)
+}
+
+// Argon2idKeyContext is Argon2idKey, returning ctx.Err() as soon as ctx is
+// done.
+func Argon2idKeyContext(ctx context.Context, password string, salt []byte, p Argon2Params, keyLen int) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if keyLen <= 0 {
+		return nil, errors.New("invalid key length")
+	}
+	return deriveContext(ctx, func() []byte {
+		return argon2.IDKey([]byte(password), salt, p.Time, p.MemoryKiB, p.Threads, uint32(keyLen))
+	})
+}
+
+// maxDerivations bounds how many key derivations run at once, counting
+// abandoned ones that are still finishing, so repeated cancel-and-retry
+// cannot pile up Argon2 memory. Callers bound the cost of each derivation
+// before taking a slot, so an abandoned one frees it within seconds.
+const maxDerivations = 2
+
+var derivations = make(chan struct{}, maxDerivations)
+
+// deriveContext runs derive, which cannot be interrupted, on its own
+// goroutine once a derivation slot is free. If ctx is done first the
+// derivation is abandoned: it finishes in the background, still holding its
+// slot, and its key is discarded.
+func deriveContext(ctx context.Context, derive func() []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	select {
+	case derivations <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	done := make(chan []byte, 1)
+	go func() {
+		defer func() { <-derivations }()
+		done <- derive()
+	}()
+	select {
+	case key := <-done:
+		return key, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
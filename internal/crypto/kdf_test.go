@@ -0,0 +1,94 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCalibrateArgon2idRespectsBudget(t *testing.T) {
+	p, est, err := CalibrateArgon2id(50*time.Millisecond, 16*1024, 1)
+	if err != nil {
+		t.Fatalf("calibrate failed: %v", err)
+	}
+	if p.MemoryKiB > 16*1024 {
+		t.Fatalf("memory budget exceeded: %d KiB", p.MemoryKiB)
+	}
+	if p.Time < 1 || p.Threads != 1 || est <= 0 {
+		t.Fatalf("unexpected params: %+v est=%s", p, est)
+	}
+	if _, err := Argon2idKey("pw", []byte("0123456789abcdef"), p, 32); err != nil {
+		t.Fatalf("derive with calibrated params failed: %v", err)
+	}
+}
+
+func TestArgon2ParamsRejectsOutOfRange(t *testing.T) {
+	bad := Argon2Params{Time: 1, MemoryKiB: MaxArgon2MemoryKiB + 1, Threads: 1}
+	if err := bad.Validate(); err == nil {
+		t.Fatalf("expected oversized memory to be rejected")
+	}
+}
+
+func TestArgon2idKeyContextReturnsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := Argon2Params{Time: 16, MemoryKiB: 64 * 1024, Threads: 1}
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := Argon2idKeyContext(ctx, "pw", []byte("0123456789abcdef"), p, 32)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("cancel took %s", d)
+	}
+}
+
+func TestDeriveContextLimitsAbandonedWork(t *testing.T) {
+	release := make(chan struct{})
+	blocked := func() []byte { <-release; return nil }
+	for i := 0; i < maxDerivations; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		if _, err := deriveContext(ctx, blocked); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	}
+
+	// Every slot is held by abandoned work, so nothing new may start.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	started := false
+	_, err := deriveContext(ctx, func() []byte { started = true; return nil })
+	if !errors.Is(err, context.DeadlineExceeded) || started {
+		t.Fatalf("derivation started past the limit: err=%v started=%v", err, started)
+	}
+
+	close(release)
+	key, err := deriveContext(context.Background(), func() []byte { return []byte("key") })
+	if err != nil || string(key) != "key" {
+		t.Fatalf("derivation after release: %q, %v", key, err)
+	}
+}
+
+func TestOversizedDerivationsDoNotStarveLaterOnes(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	for i := 0; i < 2*maxDerivations; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		if _, err := PBKDF2CompatContext(ctx, "pw", salt, 1<<31-1, 32); err == nil || errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the iteration count to be refused, got %v", err)
+		}
+		big := Argon2Params{Time: MaxArgon2Time + 1, MemoryKiB: 64 * 1024, Threads: 1}
+		if _, err := Argon2idKeyContext(ctx, "pw", salt, big, 32); err == nil || errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the argon2 parameters to be refused, got %v", err)
+		}
+		cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := PBKDF2CompatContext(ctx, "pw", salt, 1000, 32); err != nil {
+		t.Fatalf("derivation after refused requests: %v", err)
+	}
+}
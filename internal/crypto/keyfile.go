@@ -0,0 +1,59 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+)
+
+const (
+	keyfileDomain   = "stego-keyfile-v1"
+	compositeDomain = "stego-composite-v1"
+)
+
+func HashKeyfile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, errors.New("keyfile must be a regular file")
+	}
+	if info.Size() == 0 {
+		return nil, errors.New("keyfile is empty")
+	}
+	return KeyfileDigest(f)
+}
+
+// KeyfileDigest hashes keyfile content read from r.
+func KeyfileDigest(r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	_, _ = h.Write([]byte(keyfileDomain))
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New("keyfile is empty")
+	}
+	return h.Sum(nil), nil
+}
+
+func CompositeSecret(password string, keyfileDigest []byte) string {
+	if len(keyfileDigest) == 0 {
+		return password
+	}
+	mac := hmac.New(sha256.New, keyfileDigest)
+	_, _ = mac.Write([]byte(compositeDomain))
+	_, _ = mac.Write([]byte(password))
+	return "kf1:" + hex.EncodeToString(mac.Sum(nil))
+}
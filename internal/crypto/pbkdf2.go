@@ -1,7 +1,9 @@
 package crypto
 
 import (
+	"context"
 	"crypto/sha1"
+	"errors"
 
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -10,6 +12,19 @@ func PBKDF2Compat(password string, salt []byte, iterations int, keyLen int) []by
 	return pbkdf2Key([]byte(password), salt, iterations, keyLen)
 }
 
+// PBKDF2CompatContext is PBKDF2Compat, returning ctx.Err() as soon as ctx
+// is done. Iteration counts above MaxPBKDF2Iterations are refused before
+// any work starts.
+func PBKDF2CompatContext(ctx context.Context, password string, salt []byte, iterations int, keyLen int) ([]byte, error) {
+	if iterations <= 0 || iterations > MaxPBKDF2Iterations {
+		return nil, errors.New("pbkdf2 iterations out of range")
+	}
+	if keyLen <= 0 {
+		return nil, errors.New("invalid key length")
+	}
+	return deriveContext(ctx, func() []byte { return pbkdf2Key([]byte(password), salt, iterations, keyLen) })
+}
+
 func pbkdf2Key(password []byte, salt []byte, iterations int, keyLen int) []byte {
 	return pbkdf2.Key(password, salt, iterations, keyLen, sha1.New)
 }
@@ -0,0 +1,37 @@
+package crypto
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// ProgressFunc receives done out of total bytes processed so far. It may be
+// called from several goroutines at once.
+type ProgressFunc func(done, total int64)
+
+type progressKey struct{}
+
+// WithProgress returns a context that makes FECWrapContext and
+// FECUnwrapContext report their progress to fn.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// progressCounter accumulates work from parallel workers and forwards the
+// running total to the ProgressFunc attached to a context, if any.
+type progressCounter struct {
+	fn    ProgressFunc
+	done  atomic.Int64
+	total int64
+}
+
+func newProgressCounter(ctx context.Context, total int64) *progressCounter {
+	fn, _ := ctx.Value(progressKey{}).(ProgressFunc)
+	return &progressCounter{fn: fn, total: total}
+}
+
+func (p *progressCounter) add(n int64) {
+	if p.fn != nil {
+		p.fn(p.done.Add(n), p.total)
+	}
+}
@@ -1,12 +1,23 @@
 package crypto
 
-import "errors"
+import (
+	"bytes"
+	"errors"
+	"sync"
+)
 
 var (
-	gfExp [512]byte
-	gfLog [256]byte
+	gfExp      [512]byte
+	gfLog      [256]byte
+	gfMulTable [256][256]byte
 )
 
+var rsGenerators [rsBlockMax + 1]struct {
+	once sync.Once
+	gen  []byte
+	mul  []byte
+}
+
 func init() {
 	x := byte(1)
 	for i := 0; i < 255; i++ {
@@ -17,6 +28,11 @@ func init() {
 	for i := 255; i < 512; i++ {
 		gfExp[i] = gfExp[i-255]
 	}
+	for x := 1; x < 256; x++ {
+		for y := 1; y < 256; y++ {
+			gfMulTable[x][y] = gfExp[int(gfLog[x])+int(gfLog[y])]
+		}
+	}
 }
 
 func gfMulNoLUT(x, y byte) byte {
@@ -36,10 +52,7 @@ func gfMulNoLUT(x, y byte) byte {
 }
 
 func gfMul(x, y byte) byte {
-	if x == 0 || y == 0 {
-		return 0
-	}
-	return gfExp[int(gfLog[x])+int(gfLog[y])]
+	return gfMulTable[x][y]
 }
 
 func gfDiv(x, y byte) byte {
@@ -107,31 +120,89 @@ func rsGeneratorPoly(nsym int) []byte {
 	return g
 }
 
+// rsGenerator returns the cached generator table for nsym parity symbols:
+// row c of the 256×nsym result holds c·gen[1:], so encoding needs one lookup
+// per feedback symbol instead of nsym field multiplications.
+func rsGenerator(nsym int) []byte {
+	g := &rsGenerators[nsym]
+	g.once.Do(func() {
+		g.gen = rsGeneratorPoly(nsym)
+		g.mul = make([]byte, 256*nsym)
+		for c := 1; c < 256; c++ {
+			for j := 0; j < nsym; j++ {
+				g.mul[c*nsym+j] = gfMul(byte(c), g.gen[j+1])
+			}
+		}
+	})
+	return g.mul
+}
+
 func rsEncode(msg []byte, nsym int) []byte {
-	gen := rsGeneratorPoly(nsym)
 	out := make([]byte, len(msg)+nsym)
 	copy(out, msg)
+	rsEncodeParity(msg, rsGenerator(nsym), out[len(msg):])
+	return out
+}
 
-	for i := 0; i < len(msg); i++ {
-		coef := out[i]
+// rsEncodeParity computes the len(parity) check symbols of msg into parity
+// without allocating. It is the shift-register form of dividing msg*x^nsym by
+// the generator, so the result is identical to long division.
+func rsEncodeParity(msg, genMul, parity []byte) {
+	nsym := len(parity)
+	for i := range parity {
+		parity[i] = 0
+	}
+	last := parity[nsym-1:]
+	for _, m := range msg {
+		coef := int(m ^ parity[0])
 		if coef == 0 {
+			copy(parity, parity[1:])
+			last[0] = 0
 			continue
 		}
-		for j := 1; j < len(gen); j++ {
-			out[i+j] ^= gfMul(gen[j], coef)
+		row := genMul[coef*nsym : coef*nsym+nsym]
+		for j := 0; j < nsym-1; j++ {
+			parity[j] = parity[j+1] ^ row[j]
 		}
+		last[0] = row[nsym-1]
 	}
-	copy(out, msg)
-	return out
 }
 
 func rsCalcSyndromes(msg []byte, nsym int) []byte {
 	synd := make([]byte, nsym+1)
+	rsSyndromesInto(synd, msg)
+	return synd
+}
+
+// rsSyndromesInto fills synd[1:] with the syndromes of msg (synd[0] stays 0)
+// and reports whether they are all zero, i.e. msg is a valid codeword.
+func rsSyndromesInto(synd, msg []byte) bool {
+	var rows [rsBlockMax]*[256]byte
+	n := len(synd) - 1
+	for i := 0; i < n; i++ {
+		rows[i] = &gfMulTable[gfPow2(i+1)]
+		synd[i+1] = 0
+	}
 	synd[0] = 0
-	for i := 0; i < nsym; i++ {
-		synd[i+1] = polyEval(msg, gfPow2(i+1))
+	y := synd[1:]
+	for _, c := range msg {
+		for i, row := range rows[:n] {
+			y[i] = row[y[i]] ^ c
+		}
 	}
-	return synd
+	for _, v := range y {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// rsCodewordValid reports whether cw is an unmodified codeword by re-encoding
+// its message part, which is far cheaper than computing syndromes.
+func rsCodewordValid(cw []byte, k int, genMul, parity []byte) bool {
+	rsEncodeParity(cw[:k], genMul, parity)
+	return bytes.Equal(parity, cw[k:])
 }
 
 func rsCheck(synd []byte) bool {
@@ -143,14 +214,18 @@ func rsCheck(synd []byte) bool {
 	return true
 }
 
-func rsFindErrorLocator(synd []byte, nsym int) ([]byte, error) {
+// rsFindErrorLocator runs Berlekamp-Massey over syndromes that carry a
+// leading zero. With eraseCount > 0 the syndromes must be the Forney
+// syndromes, so only the remaining nsym-eraseCount are available for errors.
+func rsFindErrorLocator(synd []byte, nsym, eraseCount int) ([]byte, error) {
 	errLoc := []byte{1}
 	oldLoc := []byte{1}
 
-	for i := 0; i < nsym; i++ {
-		delta := synd[i+1]
+	for i := 0; i < nsym-eraseCount; i++ {
+		k := i + 1
+		delta := synd[k]
 		for j := 1; j < len(errLoc); j++ {
-			delta ^= gfMul(errLoc[len(errLoc)-1-j], synd[i+1-j])
+			delta ^= gfMul(errLoc[len(errLoc)-1-j], synd[k-j])
 		}
 
 		oldLoc = append(oldLoc, 0)
@@ -168,22 +243,38 @@ func rsFindErrorLocator(synd []byte, nsym int) ([]byte, error) {
 		errLoc = errLoc[1:]
 	}
 	errCount := len(errLoc) - 1
-	if errCount*2 > nsym {
+	if errCount*2+eraseCount > nsym {
 		return nil, errors.New("too many errors to correct")
 	}
 	return errLoc, nil
 }
 
+// rsForneySyndromes removes the known erasures from the syndromes so that
+// Berlekamp-Massey only has to locate the unknown errors.
+func rsForneySyndromes(synd []byte, erasePos []int, nmess int) []byte {
+	fsynd := make([]byte, len(synd))
+	copy(fsynd, synd)
+	for _, p := range erasePos {
+		x := gfPow2(nmess - 1 - p)
+		for j := 1; j < len(fsynd)-1; j++ {
+			fsynd[j] = gfMul(fsynd[j], x) ^ fsynd[j+1]
+		}
+	}
+	return fsynd
+}
+
+// rsFindErrors runs a Chien search over the reversed locator and returns the
+// byte positions whose locator root was found.
 func rsFindErrors(errLoc []byte, nmess int) ([]int, error) {
 	errs := len(errLoc) - 1
 	if errs == 0 {
 		return nil, nil
 	}
-	errPos := make([]int, 0, errs)
 	loc := make([]byte, len(errLoc))
 	for i := range errLoc {
 		loc[i] = errLoc[len(errLoc)-1-i]
 	}
+	errPos := make([]int, 0, errs)
 	for i := 0; i < nmess; i++ {
 		if polyEval(loc, gfPow2(i)) == 0 {
 			errPos = append(errPos, nmess-1-i)
@@ -195,43 +286,54 @@ func rsFindErrors(errLoc []byte, nmess int) ([]int, error) {
 	return errPos, nil
 }
 
+func rsErrataLocator(coefPos []int) []byte {
+	loc := []byte{1}
+	for _, p := range coefPos {
+		loc = polyMul(loc, []byte{gfPow2(p), 1})
+	}
+	return loc
+}
+
 func rsErrorEvaluator(synd, errLoc []byte, nsym int) []byte {
 	product := polyMul(synd, errLoc)
-	if len(product) <= nsym {
+	if len(product) <= nsym+1 {
 		return product
 	}
-	return product[len(product)-nsym:]
+	return product[len(product)-(nsym+1):]
 }
 
-func polyDeriv(p []byte) []byte {
-	deg := len(p) - 1
-	if deg <= 0 {
-		return []byte{0}
-	}
-	out := make([]byte, 0, len(p)-1)
-	for i := 0; i < len(p)-1; i++ {
-		power := deg - i
-		if power%2 == 1 {
-			out = append(out, p[i])
-		}
-	}
-	if len(out) == 0 {
-		return []byte{0}
-	}
-	return out
+func rsDecode(codeword []byte, k, nsym int) ([]byte, error) {
+	return rsDecodeErasures(codeword, k, nsym, nil)
 }
 
-func rsDecode(codeword []byte, k, nsym int) ([]byte, error) {
+// rsDecodeErasures corrects e unknown errors and f known erasures as long as
+// 2e+f <= nsym.
+func rsDecodeErasures(codeword []byte, k, nsym int, erasePos []int) ([]byte, error) {
 	if len(codeword) != k+nsym {
 		return nil, errors.New("invalid codeword length")
 	}
+	if len(erasePos) > nsym {
+		return nil, errors.New("too many erasures to correct")
+	}
+	if len(erasePos) > 0 {
+		cw := make([]byte, len(codeword))
+		copy(cw, codeword)
+		for _, p := range erasePos {
+			cw[p] = 0
+		}
+		codeword = cw
+	}
 	synd := rsCalcSyndromes(codeword, nsym)
 	if rsCheck(synd) {
 		msg := make([]byte, k)
 		copy(msg, codeword[:k])
 		return msg, nil
 	}
-	errLoc, err := rsFindErrorLocator(synd, nsym)
+	fsynd := synd
+	if len(erasePos) > 0 {
+		fsynd = rsForneySyndromes(synd, erasePos, len(codeword))
+	}
+	errLoc, err := rsFindErrorLocator(fsynd, nsym, len(erasePos))
 	if err != nil {
 		return nil, err
 	}
@@ -239,12 +341,12 @@ func rsDecode(codeword []byte, k, nsym int) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	corrected, err := rsCorrect(codeword, synd, errLoc, errPos, nsym)
+	errata := append(append(make([]int, 0, len(erasePos)+len(errPos)), erasePos...), errPos...)
+	corrected, err := rsCorrect(codeword, synd, errata)
 	if err != nil {
 		return nil, err
 	}
-	synd2 := rsCalcSyndromes(corrected, nsym)
-	if !rsCheck(synd2) {
+	if !rsCheck(rsCalcSyndromes(corrected, nsym)) {
 		return nil, errors.New("could not correct message")
 	}
 	msg := make([]byte, k)
@@ -252,28 +354,42 @@ func rsDecode(codeword []byte, k, nsym int) ([]byte, error) {
 	return msg, nil
 }
 
-func rsCorrect(msg []byte, synd []byte, errLoc []byte, errPos []int, nsym int) ([]byte, error) {
+// rsCorrect applies Forney's algorithm for the first-consecutive-root 1 code
+// produced by rsGeneratorPoly.
+func rsCorrect(msg []byte, synd []byte, errPos []int) ([]byte, error) {
 	nmess := len(msg)
-	errEval := rsErrorEvaluator(synd, errLoc, nsym)
-	errLocDeriv := polyDeriv(errLoc)
-	if len(errLocDeriv) == 0 || (len(errLocDeriv) == 1 && errLocDeriv[0] == 0) {
-		return nil, errors.New("invalid error locator derivative")
+	coefPos := make([]int, len(errPos))
+	for i, p := range errPos {
+		coefPos[i] = nmess - 1 - p
+	}
+	errLoc := rsErrataLocator(coefPos)
+
+	rev := make([]byte, len(synd))
+	for i := range synd {
+		rev[i] = synd[len(synd)-1-i]
+	}
+	errEval := rsErrorEvaluator(rev, errLoc, len(errLoc)-1)
+
+	x := make([]byte, len(coefPos))
+	for i, p := range coefPos {
+		x[i] = gfPow2(p)
 	}
 
 	out := make([]byte, len(msg))
 	copy(out, msg)
-	for _, p := range errPos {
-		coefPos := nmess - 1 - p
-		x := gfPow2(coefPos + 1)
-		xInv := gfDiv(1, x)
-
-		y := polyEval(errEval, xInv)
-		d := polyEval(errLocDeriv, xInv)
-		if d == 0 {
+	for i, xi := range x {
+		xiInv := gfDiv(1, xi)
+		var locPrime byte = 1
+		for j, xj := range x {
+			if j != i {
+				locPrime = gfMul(locPrime, 1^gfMul(xiInv, xj))
+			}
+		}
+		if locPrime == 0 {
 			return nil, errors.New("division by zero during correction")
 		}
-		mag := gfDiv(y, d)
-		out[p] ^= mag
+		y := polyEval(errEval, xiInv)
+		out[errPos[i]] ^= gfDiv(y, locPrime)
 	}
 	return out, nil
 }
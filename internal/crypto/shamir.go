@@ -0,0 +1,146 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+)
+
+const (
+	SharePrefix = "stego-share"
+	MaxShares   = 255
+)
+
+type Share struct {
+	SetID     string
+	Threshold int
+	X         byte
+	Y         []byte
+}
+
+func SplitSecret(secret []byte, count, threshold int) ([]string, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("secret is empty")
+	}
+	if threshold < 2 || count < threshold || count > MaxShares {
+		return nil, fmt.Errorf("invalid share parameters: %d-of-%d", threshold, count)
+	}
+	setRaw, err := RandomBytes(4)
+	if err != nil {
+		return nil, err
+	}
+	setID := hex.EncodeToString(setRaw)
+
+	ys := make([][]byte, count)
+	for i := range ys {
+		ys[i] = make([]byte, len(secret))
+	}
+	coeffs := make([]byte, threshold)
+	for b, s := range secret {
+		rnd, err := RandomBytes(threshold - 1)
+		if err != nil {
+			return nil, err
+		}
+		coeffs[0] = s
+		copy(coeffs[1:], rnd)
+		for i := 0; i < count; i++ {
+			x := byte(i + 1)
+			var y byte
+			for d := threshold - 1; d >= 0; d-- {
+				y = gfMul(y, x) ^ coeffs[d]
+			}
+			ys[i][b] = y
+		}
+	}
+
+	out := make([]string, count)
+	for i := range ys {
+		out[i] = Share{SetID: setID, Threshold: threshold, X: byte(i + 1), Y: ys[i]}.String()
+	}
+	return out, nil
+}
+
+func CombineShares(encoded []string) ([]byte, error) {
+	var shares []Share
+	seen := map[byte]bool{}
+	for _, s := range encoded {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		sh, err := ParseShare(s)
+		if err != nil {
+			return nil, err
+		}
+		if len(shares) > 0 {
+			first := shares[0]
+			if sh.SetID != first.SetID || sh.Threshold != first.Threshold || len(sh.Y) != len(first.Y) {
+				return nil, errors.New("shares belong to different sets")
+			}
+		}
+		if seen[sh.X] {
+			continue
+		}
+		seen[sh.X] = true
+		shares = append(shares, sh)
+	}
+	if len(shares) == 0 {
+		return nil, errors.New("no shares provided")
+	}
+	threshold := shares[0].Threshold
+	if len(shares) < threshold {
+		return nil, fmt.Errorf("not enough shares: have %d, need %d", len(shares), threshold)
+	}
+	shares = shares[:threshold]
+
+	secret := make([]byte, len(shares[0].Y))
+	for i, si := range shares {
+		basis := byte(1)
+		for j, sj := range shares {
+			if i == j {
+				continue
+			}
+			basis = gfMul(basis, gfDiv(sj.X, sj.X^si.X))
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(si.Y[b], basis)
+		}
+	}
+	return secret, nil
+}
+
+func (s Share) String() string {
+	body := fmt.Sprintf("%s-%s-%d-%d-%s", SharePrefix, s.SetID, s.Threshold, s.X, hex.EncodeToString(s.Y))
+	return fmt.Sprintf("%s-%08x", body, crc32.ChecksumIEEE([]byte(body)))
+}
+
+func ParseShare(s string) (Share, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, SharePrefix+"-") {
+		return Share{}, errors.New("invalid share: missing " + SharePrefix + " prefix")
+	}
+	i := strings.LastIndex(s, "-")
+	body, sum := s[:i], s[i+1:]
+	if fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(body))) != strings.ToLower(sum) {
+		return Share{}, errors.New("invalid share: checksum mismatch")
+	}
+	parts := strings.Split(body[len(SharePrefix)+1:], "-")
+	if len(parts) != 4 {
+		return Share{}, errors.New("invalid share format")
+	}
+	threshold, err := strconv.Atoi(parts[1])
+	if err != nil || threshold < 2 || threshold > MaxShares {
+		return Share{}, errors.New("invalid share threshold")
+	}
+	x, err := strconv.Atoi(parts[2])
+	if err != nil || x < 1 || x > MaxShares {
+		return Share{}, errors.New("invalid share index")
+	}
+	y, err := hex.DecodeString(parts[3])
+	if err != nil || len(y) == 0 {
+		return Share{}, errors.New("invalid share data")
+	}
+	return Share{SetID: parts[0], Threshold: threshold, X: byte(x), Y: y}, nil
+}
@@ -0,0 +1,40 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestShamirSplitCombine(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("split failed: %v", err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("expected 5 shares, got %d", len(shares))
+	}
+
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var picked []string
+		for _, i := range subset {
+			picked = append(picked, shares[i])
+		}
+		got, err := CombineShares(picked)
+		if err != nil {
+			t.Fatalf("combine %v failed: %v", subset, err)
+		}
+		if !bytes.Equal(got, secret) {
+			t.Fatalf("combine %v: secret mismatch", subset)
+		}
+	}
+
+	if _, err := CombineShares(shares[:2]); err == nil {
+		t.Fatalf("expected error below threshold")
+	}
+	corrupted := []byte(shares[0])
+	corrupted[len(SharePrefix)+12] ^= 1
+	if _, err := CombineShares([]string{string(corrupted), shares[1], shares[2]}); err == nil {
+		t.Fatalf("expected checksum error for corrupted share")
+	}
+}
@@ -0,0 +1,235 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	streamCounterLen = 4
+	streamFlagLen    = 1
+	streamLastChunk  = 0x01
+
+	// MaxStreamChunkSize bounds the chunk buffer a stream allocates, as
+	// the chunk size of a container is read before any chunk is
+	// authenticated.
+	MaxStreamChunkSize = 64 << 20
+)
+
+var ErrStreamTruncated = errors.New("stream truncated: final chunk missing")
+
+func StreamNoncePrefixSize(spec CipherSpec) int {
+	return spec.NonceSize - streamCounterLen - streamFlagLen
+}
+
+func StreamCiphertextSize(plainLen int64, chunkSize int, tagSize int) int64 {
+	chunks := (plainLen + int64(chunkSize) - 1) / int64(chunkSize)
+	if chunks == 0 {
+		chunks = 1
+	}
+	return plainLen + chunks*int64(tagSize)
+}
+
+type streamState struct {
+	aead      cipher.AEAD
+	prefix    []byte
+	aad       []byte
+	chunkSize int
+	counter   uint32
+	nonce     []byte
+}
+
+func newStreamState(spec CipherSpec, key, noncePrefix, aad []byte, chunkSize int) (*streamState, error) {
+	if chunkSize <= 0 || chunkSize > MaxStreamChunkSize {
+		return nil, errors.New("invalid stream chunk size")
+	}
+	if len(noncePrefix) != StreamNoncePrefixSize(spec) {
+		return nil, errors.New("invalid stream nonce prefix length")
+	}
+	if len(key) != spec.KeySize {
+		return nil, errors.New("invalid key length")
+	}
+	aead, err := spec.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return &streamState{
+		aead:      aead,
+		prefix:    append([]byte{}, noncePrefix...),
+		aad:       aad,
+		chunkSize: chunkSize,
+		nonce:     make([]byte, spec.NonceSize),
+	}, nil
+}
+
+func (s *streamState) nextNonce(last bool) ([]byte, error) {
+	if s.counter == ^uint32(0) {
+		return nil, errors.New("stream chunk counter overflow")
+	}
+	copy(s.nonce, s.prefix)
+	binary.BigEndian.PutUint32(s.nonce[len(s.prefix):], s.counter)
+	s.nonce[len(s.nonce)-1] = 0
+	if last {
+		s.nonce[len(s.nonce)-1] = streamLastChunk
+	}
+	s.counter++
+	return s.nonce, nil
+}
+
+type StreamWriter struct {
+	st      *streamState
+	w       io.Writer
+	buf     []byte
+	out     []byte
+	closed  bool
+	done    int64
+	OnChunk func(plainBytes int64)
+}
+
+func NewStreamWriter(spec CipherSpec, key, noncePrefix, aad []byte, chunkSize int, w io.Writer) (*StreamWriter, error) {
+	st, err := newStreamState(spec, key, noncePrefix, aad, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamWriter{
+		st:  st,
+		w:   w,
+		buf: make([]byte, 0, chunkSize),
+		out: make([]byte, 0, chunkSize+st.aead.Overhead()),
+	}, nil
+}
+
+func (s *StreamWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("stream writer closed")
+	}
+	n := 0
+	for len(p) > 0 {
+		if len(s.buf) == s.st.chunkSize {
+			if err := s.flush(false); err != nil {
+				return n, err
+			}
+		}
+		k := copy(s.buf[len(s.buf):s.st.chunkSize], p)
+		s.buf = s.buf[:len(s.buf)+k]
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+func (s *StreamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.flush(true)
+}
+
+func (s *StreamWriter) flush(last bool) error {
+	nonce, err := s.st.nextNonce(last)
+	if err != nil {
+		return err
+	}
+	s.out = s.st.aead.Seal(s.out[:0], nonce, s.buf, s.st.aad)
+	if _, err := s.w.Write(s.out); err != nil {
+		return err
+	}
+	s.done += int64(len(s.buf))
+	s.buf = s.buf[:0]
+	if s.OnChunk != nil {
+		s.OnChunk(s.done)
+	}
+	return nil
+}
+
+type StreamReader struct {
+	st      *streamState
+	r       io.Reader
+	in      []byte
+	inLen   int
+	plain   []byte
+	pos     int
+	final   bool
+	done    int64
+	err     error
+	OnChunk func(plainBytes int64)
+}
+
+func NewStreamReader(spec CipherSpec, key, noncePrefix, aad []byte, chunkSize int, r io.Reader) (*StreamReader, error) {
+	st, err := newStreamState(spec, key, noncePrefix, aad, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamReader{
+		st: st,
+		r:  r,
+		in: make([]byte, chunkSize+st.aead.Overhead()+1),
+	}, nil
+}
+
+func (s *StreamReader) Read(p []byte) (int, error) {
+	for s.pos >= len(s.plain) {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.final {
+			return 0, io.EOF
+		}
+		s.err = s.next()
+	}
+	n := copy(p, s.plain[s.pos:])
+	s.pos += n
+	return n, nil
+}
+
+func (s *StreamReader) next() error {
+	sealedMax := s.st.chunkSize + s.st.aead.Overhead()
+	n, err := io.ReadFull(s.r, s.in[s.inLen:])
+	s.inLen += n
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	last := s.inLen <= sealedMax
+	chunkLen := s.inLen
+	if !last {
+		chunkLen = sealedMax
+	}
+	if chunkLen < s.st.aead.Overhead() {
+		return ErrStreamTruncated
+	}
+
+	nonce, nerr := s.st.nextNonce(last)
+	if nerr != nil {
+		return nerr
+	}
+	plain, oerr := s.st.aead.Open(s.plain[:0], nonce, s.in[:chunkLen], s.st.aad)
+	if oerr != nil {
+		if last {
+			if _, retry := s.st.aead.Open(nil, s.retryNonce(), s.in[:chunkLen], s.st.aad); retry == nil {
+				return ErrStreamTruncated
+			}
+		}
+		return ErrAuthFailed
+	}
+	s.plain = plain
+	s.pos = 0
+	s.done += int64(len(plain))
+	copy(s.in, s.in[chunkLen:s.inLen])
+	s.inLen -= chunkLen
+	if last {
+		s.final = true
+	}
+	if s.OnChunk != nil {
+		s.OnChunk(s.done)
+	}
+	return nil
+}
+
+func (s *StreamReader) retryNonce() []byte {
+	nonce := append([]byte{}, s.st.nonce...)
+	nonce[len(nonce)-1] = 0
+	return nonce
+}
@@ -0,0 +1,127 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+)
+
+func sealStream(t *testing.T, spec CipherSpec, key, prefix, plain []byte, chunkSize int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	sw, err := NewStreamWriter(spec, key, prefix, []byte("aad"), chunkSize, &buf)
+	if err != nil {
+		t.Fatalf("new writer: %v", err)
+	}
+	if _, err := sw.Write(plain); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func openStream(spec CipherSpec, key, prefix, sealed []byte, chunkSize int) ([]byte, error) {
+	sr, err := NewStreamReader(spec, key, prefix, []byte("aad"), chunkSize, bytes.NewReader(sealed))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(sr)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	const chunkSize = 64
+	for _, name := range CipherNames() {
+		spec, _ := LookupCipher(name)
+		key := bytes.Repeat([]byte{3}, spec.KeySize)
+		prefix := bytes.Repeat([]byte{5}, StreamNoncePrefixSize(spec))
+		for _, n := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 5 * chunkSize, 5*chunkSize + 17} {
+			plain := bytes.Repeat([]byte{0xA5}, n)
+			sealed := sealStream(t, spec, key, prefix, plain, chunkSize)
+			if want := StreamCiphertextSize(int64(n), chunkSize, spec.TagSize); int64(len(sealed)) != want {
+				t.Fatalf("%s/%d: sealed size %d, want %d", name, n, len(sealed), want)
+			}
+			got, err := openStream(spec, key, prefix, sealed, chunkSize)
+			if err != nil {
+				t.Fatalf("%s/%d: open failed: %v", name, n, err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatalf("%s/%d: plaintext mismatch", name, n)
+			}
+		}
+	}
+}
+
+func TestStreamDetectsTruncationAndTampering(t *testing.T) {
+	const chunkSize = 32
+	spec, _ := LookupCipher(CipherAESGCM)
+	key := bytes.Repeat([]byte{1}, spec.KeySize)
+	prefix := bytes.Repeat([]byte{2}, StreamNoncePrefixSize(spec))
+	sealed := sealStream(t, spec, key, prefix, bytes.Repeat([]byte{7}, 4*chunkSize+5), chunkSize)
+
+	cut := sealed[:2*(chunkSize+spec.TagSize)]
+	if _, err := openStream(spec, key, prefix, cut, chunkSize); !errors.Is(err, ErrStreamTruncated) {
+		t.Fatalf("expected truncation error, got %v", err)
+	}
+
+	tampered := append([]byte{}, sealed...)
+	tampered[chunkSize+spec.TagSize+3] ^= 1
+	if _, err := openStream(spec, key, prefix, tampered, chunkSize); err == nil {
+		t.Fatalf("expected tampered chunk to be rejected")
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// streamAlloc seals n bytes and opens them again through a pipe, returning
+// the bytes allocated on the way.
+func streamAlloc(t *testing.T, n int64, chunkSize int) uint64 {
+	t.Helper()
+	spec, _ := LookupCipher(CipherAESGCM)
+	key := bytes.Repeat([]byte{7}, spec.KeySize)
+	prefix := bytes.Repeat([]byte{9}, StreamNoncePrefixSize(spec))
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	pr, pw := io.Pipe()
+	go func() {
+		sw, err := NewStreamWriter(spec, key, prefix, nil, chunkSize, pw)
+		if err == nil {
+			_, err = io.Copy(sw, io.LimitReader(zeroReader{}, n))
+		}
+		if err == nil {
+			err = sw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	sr, err := NewStreamReader(spec, key, prefix, nil, chunkSize, pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.Copy(io.Discard, sr)
+	if err != nil || got != n {
+		t.Fatalf("streamed %d of %d bytes: %v", got, n, err)
+	}
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestStreamMemoryDoesNotGrowWithInput(t *testing.T) {
+	const chunkSize = 64 << 10
+	small := streamAlloc(t, 1<<20, chunkSize)
+	large := streamAlloc(t, 32<<20, chunkSize)
+	// Both ends reuse one chunk buffer each, so 32x the input may cost a
+	// few more small allocations but never another chunk per chunk.
+	if large > small+chunkSize {
+		t.Fatalf("allocations grew with input: %d bytes for 1 MiB, %d for 32 MiB", small, large)
+	}
+}
@@ -0,0 +1,164 @@
+package crypto
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	X25519PublicKeyPrefix = "stego-pub-"
+	X25519SecretKeyPrefix = "STEGO-SECRET-KEY-"
+	X25519StanzaType      = "X25519"
+
+	x25519WrapInfo = "stego-x25519-v1"
+)
+
+var ErrNoMatchingIdentity = errors.New("no recipient stanza matches this identity")
+
+var keyEncoding = base64.RawURLEncoding
+
+type RecipientStanza struct {
+	Type       string `json:"type"`
+	Ephemeral  string `json:"ephemeral"`
+	WrappedKey string `json:"wrapped_key"`
+}
+
+type X25519Recipient struct {
+	pub *ecdh.PublicKey
+}
+
+type X25519Identity struct {
+	priv *ecdh.PrivateKey
+}
+
+func GenerateX25519Identity() (*X25519Identity, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &X25519Identity{priv: priv}, nil
+}
+
+func ParseX25519Recipient(s string) (*X25519Recipient, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, X25519PublicKeyPrefix) {
+		return nil, errors.New("invalid recipient: missing " + X25519PublicKeyPrefix + " prefix")
+	}
+	raw, err := keyEncoding.DecodeString(s[len(X25519PublicKeyPrefix):])
+	if err != nil {
+		return nil, errors.New("invalid recipient encoding")
+	}
+	pub, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &X25519Recipient{pub: pub}, nil
+}
+
+func ParseX25519Identity(s string) (*X25519Identity, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, X25519SecretKeyPrefix) {
+		return nil, errors.New("invalid identity: missing " + X25519SecretKeyPrefix + " prefix")
+	}
+	raw, err := keyEncoding.DecodeString(s[len(X25519SecretKeyPrefix):])
+	if err != nil {
+		return nil, errors.New("invalid identity encoding")
+	}
+	priv, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &X25519Identity{priv: priv}, nil
+}
+
+func (r *X25519Recipient) String() string {
+	return X25519PublicKeyPrefix + keyEncoding.EncodeToString(r.pub.Bytes())
+}
+
+func (id *X25519Identity) String() string {
+	return X25519SecretKeyPrefix + keyEncoding.EncodeToString(id.priv.Bytes())
+}
+
+func (id *X25519Identity) Recipient() *X25519Recipient {
+	return &X25519Recipient{pub: id.priv.PublicKey()}
+}
+
+func (r *X25519Recipient) Wrap(fileKey []byte) (RecipientStanza, error) {
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return RecipientStanza{}, err
+	}
+	shared, err := eph.ECDH(r.pub)
+	if err != nil {
+		return RecipientStanza{}, err
+	}
+	wrapKey, err := x25519WrapKey(shared, eph.PublicKey().Bytes(), r.pub.Bytes())
+	if err != nil {
+		return RecipientStanza{}, err
+	}
+	aead, err := chacha20poly1305.New(wrapKey)
+	if err != nil {
+		return RecipientStanza{}, err
+	}
+	wrapped := aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil)
+	return RecipientStanza{
+		Type:       X25519StanzaType,
+		Ephemeral:  keyEncoding.EncodeToString(eph.PublicKey().Bytes()),
+		WrappedKey: keyEncoding.EncodeToString(wrapped),
+	}, nil
+}
+
+func (id *X25519Identity) Unwrap(stanzas []RecipientStanza) ([]byte, error) {
+	ownPub := id.priv.PublicKey().Bytes()
+	for _, st := range stanzas {
+		if st.Type != X25519StanzaType {
+			continue
+		}
+		ephRaw, err := keyEncoding.DecodeString(st.Ephemeral)
+		if err != nil {
+			continue
+		}
+		wrapped, err := keyEncoding.DecodeString(st.WrappedKey)
+		if err != nil {
+			continue
+		}
+		eph, err := ecdh.X25519().NewPublicKey(ephRaw)
+		if err != nil {
+			continue
+		}
+		shared, err := id.priv.ECDH(eph)
+		if err != nil {
+			continue
+		}
+		wrapKey, err := x25519WrapKey(shared, ephRaw, ownPub)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := chacha20poly1305.New(wrapKey)
+		if err != nil {
+			return nil, err
+		}
+		fileKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), wrapped, nil)
+		if err == nil {
+			return fileKey, nil
+		}
+	}
+	return nil, ErrNoMatchingIdentity
+}
+
+func x25519WrapKey(shared, ephPub, recipientPub []byte) ([]byte, error) {
+	salt := append(append([]byte{}, ephPub...), recipientPub...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(x25519WrapInfo)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
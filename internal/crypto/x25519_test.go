@@ -0,0 +1,53 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestX25519WrapUnwrapMultipleRecipients(t *testing.T) {
+	alice, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mallory, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileKey := bytes.Repeat([]byte{0x42}, 32)
+
+	var stanzas []RecipientStanza
+	for _, id := range []*X25519Identity{alice, bob} {
+		r, err := ParseX25519Recipient(id.Recipient().String())
+		if err != nil {
+			t.Fatalf("parse recipient failed: %v", err)
+		}
+		st, err := r.Wrap(fileKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stanzas = append(stanzas, st)
+	}
+
+	for _, id := range []*X25519Identity{alice, bob} {
+		parsed, err := ParseX25519Identity(id.String())
+		if err != nil {
+			t.Fatalf("parse identity failed: %v", err)
+		}
+		got, err := parsed.Unwrap(stanzas)
+		if err != nil {
+			t.Fatalf("unwrap failed: %v", err)
+		}
+		if !bytes.Equal(got, fileKey) {
+			t.Fatalf("file key mismatch")
+		}
+	}
+	if _, err := mallory.Unwrap(stanzas); !errors.Is(err, ErrNoMatchingIdentity) {
+		t.Fatalf("expected ErrNoMatchingIdentity, got %v", err)
+	}
+}
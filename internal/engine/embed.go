@@ -1,8 +1,10 @@
 package engine
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 )
 
 func embedBytes2bitAtSlot(rgb []byte, startSlot int, data []byte) {
@@ -13,83 +15,161 @@ func embedBytes2bitAtSlot(rgb []byte, startSlot int, data []byte) {
 	if startSlot+slotsNeeded > len(rgb) {
 		slotsNeeded = len(rgb) - startSlot
 	}
-	slot := 0
-	for i := 0; i < len(data) && slot < slotsNeeded; i++ {
-		b := data[i]
-		for shift := 6; shift >= 0 && slot < slotsNeeded; shift -= 2 {
-			two := (b >> uint(shift)) & 0x3
+	parallelFor(slotsNeeded, func(lo, hi int) {
+		for slot := lo; slot < hi; slot++ {
+			two := (data[slot>>2] >> uint(6-2*(slot&3))) & 0x3
 			idx := startSlot + slot
 			rgb[idx] = (rgb[idx] & 0xFC) | two
-			slot++
 		}
-	}
+	})
 }
 
-func embedScatteredBytes2bit(rgb []byte, startSlot int, data []byte, password string) error {
-	if startSlot < 0 {
-		startSlot = 0
-	}
-	available := len(rgb) - startSlot
-	if available <= 0 {
-		return errors.New("image capacity insufficient: no writable area")
-	}
-	slotsNeeded := len(data) * 4
-	if slotsNeeded > available {
-		return errors.New("image capacity insufficient: data exceeds available area")
-	}
-	a, b := scatterParams(password, available, []byte("scatter_body_v1"))
-	k := 0
-	for i := 0; i < len(data); i++ {
-		bt := data[i]
-		for shift := 6; shift >= 0; shift -= 2 {
-			two := (bt >> uint(shift)) & 0x3
-			idx := startSlot + scatterSlotIndex(k, available, a, b)
-			rgb[idx] = (rgb[idx] & 0xFC) | two
-			k++
-		}
-	}
-	return nil
+// hidePlan is what Hide writes: the prefix (header, a zeroed integrity
+// hash, geometry and the optional key check) from slot 0 and the body from
+// slot start.
+type hidePlan struct {
+	prefix  []byte
+	body    []byte
+	start   int
+	scatter bool
+	// Scatter permutation over the n slots after the prefix, and the
+	// inverse multiplier used to map a slot back to its body position.
+	a, b, n int
+	aInv    int
 }
 
-func (e *Engine) Hide(rgb []byte, width, height int, data []byte, password string, scatter bool) ([]byte, []byte, error) {
+func (e *Engine) planHide(width, height int, data []byte, password string, scatter bool) (*hidePlan, error) {
 	maxCap := e.CalculateMaxCapacity(width, height, false)
-	totalBitsNeeded := (HeaderLength + IntegrityHashLen + len(data) + CRCLength) * 8
-	if totalBitsNeeded > maxCap*8 {
-		return nil, nil, errors.New("image capacity insufficient")
+	totalBitsNeeded := EmbeddedLength(len(data)) * 8
+	if totalBitsNeeded > maxCap*8 || uint64(len(data)) > uint64(maxDataLength) {
+		return nil, ErrCapacity
 	}
 
 	crcBytes := calculateCRC32(data)
-	flags := uint32(IntegrityFlag)
+	flags := uint32(IntegrityFlag | BlockCheckFlag)
 	scatterEnabled := password != "" && scatter
 	if scatterEnabled {
 		flags |= ScatterFlag
 	}
+	if e.KeyCheck != nil && len(e.KeyCheck) != KeyCheckLength {
+		return nil, errors.New("invalid key check length")
+	}
+	if width > geometryWidthMask {
+		return nil, errors.New("image too wide")
+	}
 
-	dataLenWithFlags := uint32(len(data)) | flags
-	header := make([]byte, 4)
-	binary.LittleEndian.PutUint32(header, dataLenWithFlags)
-
-	complete := append(append(header, data...), crcBytes...)
+	prefix := make([]byte, prefixLength, maxPrefixLength)
+	binary.LittleEndian.PutUint32(prefix[0:4], uint32(len(data))|flags)
+	geometry := prefix[HeaderLength+IntegrityHashLen:]
+	binary.LittleEndian.PutUint32(geometry[0:4], uint32(width))
+	binary.LittleEndian.PutUint32(geometry[4:8], uint32(height))
+	if e.KeyCheck != nil {
+		geometry[3] = keyCheckVersion
+		prefix = append(prefix, e.KeyCheck...)
+	}
 
-	out := make([]byte, len(rgb))
-	copy(out, rgb)
+	body := make([]byte, 0, len(data)+CRCLength+blockChecksLength(len(data)))
+	body = append(append(append(body, data...), crcBytes...), blockChecks(data)...)
 
-	embedBytes2bitAtSlot(out, 0, header)
-	startSlot := ((HeaderLength + IntegrityHashLen) * 8) / 2
+	p := &hidePlan{prefix: prefix, body: body, start: len(prefix) * 4, scatter: scatterEnabled}
 	if scatterEnabled {
-		if err := embedScatteredBytes2bit(out, startSlot, complete[HeaderLength:], password); err != nil {
-			return nil, nil, err
+		p.n = width*height*3 - p.start
+		if p.n <= 0 {
+			return nil, fmt.Errorf("%w: no writable area", ErrCapacity)
+		}
+		if len(body)*4 > p.n {
+			return nil, fmt.Errorf("%w: data exceeds available area", ErrCapacity)
 		}
-	} else {
-		embedBytes2bitAtSlot(out, startSlot, complete[HeaderLength:])
+		p.a, p.b = scatterParams(password, p.n, []byte("scatter_body_v1"))
+		p.aInv = modInverse(p.a, p.n)
 	}
+	return p, nil
+}
 
-	integrity, err := embeddedPixelHash(out, width, height)
+func (e *Engine) Hide(rgb []byte, width, height int, data []byte, password string, scatter bool) ([]byte, []byte, error) {
+	return e.HideContext(context.Background(), rgb, width, height, data, password, scatter)
+}
+
+// HideContext is Hide, checking ctx between bands of about ChunkSize bytes.
+func (e *Engine) HideContext(ctx context.Context, rgb []byte, width, height int, data []byte, password string, scatter bool) ([]byte, []byte, error) {
+	plan, err := e.planHide(width, height, data, password, scatter)
 	if err != nil {
 		return nil, nil, err
 	}
-	integritySlotStart := (HeaderLength * 8) / 2
+	if len(rgb) != width*height*3 {
+		return nil, nil, errors.New("invalid rgb buffer size")
+	}
+
+	out := make([]byte, len(rgb))
+	copy(out, rgb)
+
+	step := e.bandRows(width) * width * 3
+	hasher := newPixelHasher(width, height)
+	for lo := 0; lo < len(out); lo += step {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		band := out[lo:min(lo+step, len(out))]
+		plan.embedBand(band, lo, nil)
+		hasher.Write(band)
+		e.progress(lo+len(band), len(out))
+	}
+	integrity := hasher.Sum()
 	embedBytes2bitAtSlot(out, integritySlotStart, integrity)
 
 	return out, integrity, nil
 }
+
+// embedBand applies the plan to band, which holds slots [lo, lo+len(band)).
+// integrity, when set, is written over the zeroed hash in the prefix.
+func (p *hidePlan) embedBand(band []byte, lo int, integrity []byte) {
+	embedRange(band, lo, 0, p.prefix)
+	if integrity != nil {
+		embedRange(band, lo, integritySlotStart, integrity)
+	}
+	if !p.scatter {
+		embedRange(band, lo, p.start, p.body)
+		return
+	}
+	from := max(lo, p.start)
+	hi := lo + len(band)
+	if from >= hi {
+		return
+	}
+	slots := len(p.body) * 4
+	parallelFor(hi-from, func(i0, i1 int) {
+		k := scatterInverse(from+i0-p.start, p.n, p.aInv, p.b)
+		for s := from + i0; s < from+i1; s++ {
+			if k < slots {
+				two := (p.body[k>>2] >> uint(6-2*(k&3))) & 0x3
+				band[s-lo] = (band[s-lo] & 0xFC) | two
+			}
+			k += p.aInv
+			if k >= p.n {
+				k -= p.n
+			}
+		}
+	})
+}
+
+// embedRange writes the part of data, embedded from slot start, that falls
+// inside band, which holds slots [lo, lo+len(band)).
+func embedRange(band []byte, lo, start int, data []byte) {
+	from, to := start, start+len(data)*4
+	if from < lo {
+		from = lo
+	}
+	if hi := lo + len(band); to > hi {
+		to = hi
+	}
+	if from >= to {
+		return
+	}
+	parallelFor(to-from, func(i0, i1 int) {
+		for s := from + i0; s < from+i1; s++ {
+			k := s - start
+			two := (data[k>>2] >> uint(6-2*(k&3))) & 0x3
+			band[s-lo] = (band[s-lo] & 0xFC) | two
+		}
+	})
+}
@@ -1,9 +1,11 @@
 package engine
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"hash"
 	"hash/crc32"
 )
 
@@ -13,12 +15,100 @@ const (
 	IntegrityHashLen   = 16
 	MetadataLengthSize = 4
 
-	IntegrityFlag = 0x80000000
-	ScatterFlag   = 0x40000000
+	GeometryLength = 8
+	KeyCheckLength = 27
+	BlockCheckSize = 256
+	BlockCheckLen  = 2
+
+	IntegrityFlag  = 0x80000000
+	ScatterFlag    = 0x40000000
+	BlockCheckFlag = 0x20000000
+)
+
+const (
+	prefixLength = HeaderLength + IntegrityHashLen + GeometryLength
+	// maxPrefixLength adds the key check that may follow the geometry.
+	maxPrefixLength    = prefixLength + KeyCheckLength
+	integritySlotStart = HeaderLength * 4
+
+	maxDataLength = ^uint32(IntegrityFlag | ScatterFlag | BlockCheckFlag)
+
+	// The top byte of the recorded width is the prefix version, zero in
+	// images written before key checks. keyCheckVersion means a key check
+	// follows the geometry.
+	geometryWidthMask = 0x00ffffff
+	keyCheckVersion   = 1
+)
+
+var (
+	// ErrNoPayload means the image holds no data this engine embedded: the
+	// header is missing or describes a payload the image cannot contain.
+	ErrNoPayload = errors.New("no hidden data found")
+	// ErrCapacity means the payload does not fit in the carrier.
+	ErrCapacity = errors.New("image capacity insufficient")
+	// ErrPasswordRequired means the payload is scattered and no password
+	// was given to locate it.
+	ErrPasswordRequired = errors.New("password required for scattered data")
+	// ErrWrongPassword means the password does not unlock the payload; a
+	// CheckKey hook reports it from the key check, before extraction.
+	ErrWrongPassword = errors.New("wrong password or key")
+	// ErrCorrupted matches every error caused by damaged embedded data.
+	ErrCorrupted = errors.New("data corrupted")
+
+	ErrCRCMismatch     error = corruption("crc32 verify failed")
+	ErrGeometryChanged error = corruption("image geometry changed: cannot locate payload")
 )
 
+// corruption is an error that also matches ErrCorrupted, so callers can test
+// for damage in general and still report what was found.
+type corruption string
+
+func (c corruption) Error() string { return string(c) }
+
+func (c corruption) Is(target error) bool { return target == ErrCorrupted }
+
+// EmbeddedLength is the most bytes Hide writes into a carrier for a payload
+// of dataLen bytes, including header, integrity hash, geometry, key check,
+// CRC and per-block checks.
+func EmbeddedLength(dataLen int) int {
+	return maxPrefixLength + dataLen + CRCLength + blockChecksLength(dataLen)
+}
+
+func blockChecksLength(dataLen int) int {
+	return (dataLen + BlockCheckSize - 1) / BlockCheckSize * BlockCheckLen
+}
+
+func blockChecks(data []byte) []byte {
+	out := make([]byte, 0, blockChecksLength(len(data)))
+	for off := 0; off < len(data); off += BlockCheckSize {
+		end := off + BlockCheckSize
+		if end > len(data) {
+			end = len(data)
+		}
+		out = binary.LittleEndian.AppendUint16(out, uint16(crc32.ChecksumIEEE(data[off:end])))
+	}
+	return out
+}
+
 type Engine struct {
 	ChunkSize int
+	// OnProgress, if set, is called after each band with the carrier bytes
+	// processed so far. HideStream reads the carrier twice and counts both
+	// passes in total.
+	OnProgress func(done, total int64)
+	// KeyCheck, if set, is stored after the geometry by Hide, so a wrong
+	// key can be turned away before extraction. It is KeyCheckLength bytes
+	// the caller derives from its key; the engine does not interpret it.
+	KeyCheck []byte
+	// CheckKey, if set, is given the key check of an image before its body
+	// is extracted; an error from it ends the extraction.
+	CheckKey func(ctx context.Context, keyCheck []byte) error
+}
+
+func (e *Engine) progress(done, total int) {
+	if e.OnProgress != nil {
+		e.OnProgress(int64(done), int64(total))
+	}
 }
 
 func New(chunkSize int) *Engine {
@@ -58,43 +148,52 @@ func embeddedPixelHash(rgb []byte, width, height int) ([]byte, error) {
 	if len(rgb) != width*height*3 {
 		return nil, errors.New("invalid rgb buffer size")
 	}
+	h := newPixelHasher(width, height)
+	h.Write(rgb)
+	return h.Sum(), nil
+}
 
-	hashBitOffset := HeaderLength * 8
-	hashSlotStart := hashBitOffset / 2
-	hashSlots := (IntegrityHashLen * 8) / 2
-	hashSlotEnd := hashSlotStart + hashSlots
-	if hashSlotEnd > len(rgb) {
-		hashSlotEnd = len(rgb)
-	}
+// pixelHasher computes embeddedPixelHash over pixels fed in order, with the
+// low bits of the integrity hash slots masked out.
+type pixelHasher struct {
+	h   hash.Hash
+	pos int
+	buf []byte
+}
 
+func newPixelHasher(width, height int) *pixelHasher {
 	header := make([]byte, 8)
 	binary.LittleEndian.PutUint32(header[0:4], uint32(width))
 	binary.LittleEndian.PutUint32(header[4:8], uint32(height))
-
 	h := sha256.New()
 	_, _ = h.Write(header)
-	if hashSlotStart > 0 {
-		_, _ = h.Write(rgb[:hashSlotStart])
+	return &pixelHasher{h: h}
+}
+
+func (p *pixelHasher) Write(b []byte) {
+	const maskStart, maskEnd = integritySlotStart, integritySlotStart + IntegrityHashLen*4
+	start := p.pos
+	p.pos += len(b)
+	if p.pos <= maskStart || start >= maskEnd {
+		_, _ = p.h.Write(b)
+		return
 	}
-	if hashSlotEnd > hashSlotStart {
-		buf := make([]byte, 32*1024)
-		for i := hashSlotStart; i < hashSlotEnd; {
-			n := hashSlotEnd - i
-			if n > len(buf) {
-				n = len(buf)
-			}
-			for j := 0; j < n; j++ {
-				buf[j] = rgb[i+j] & 0xFC
-			}
-			_, _ = h.Write(buf[:n])
-			i += n
-		}
+	from, to := maskStart-start, maskEnd-start
+	if from < 0 {
+		from = 0
+	}
+	if to > len(b) {
+		to = len(b)
 	}
-	if hashSlotEnd < len(rgb) {
-		_, _ = h.Write(rgb[hashSlotEnd:])
+	_, _ = p.h.Write(b[:from])
+	p.buf = append(p.buf[:0], b[from:to]...)
+	for i := range p.buf {
+		p.buf[i] &= 0xFC
 	}
-	sum := h.Sum(nil)
-	out := make([]byte, IntegrityHashLen)
-	copy(out, sum[:IntegrityHashLen])
-	return out, nil
+	_, _ = p.h.Write(p.buf)
+	_, _ = p.h.Write(b[to:])
+}
+
+func (p *pixelHasher) Sum() []byte {
+	return p.h.Sum(nil)[:IntegrityHashLen]
 }
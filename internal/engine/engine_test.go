@@ -1,6 +1,11 @@
 package engine
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+)
 
 func TestHideExtractRoundTrip(t *testing.T) {
 	w, h := 256, 256
@@ -24,6 +29,80 @@ func TestHideExtractRoundTrip(t *testing.T) {
 	}
 }
 
+func TestKeyCheckReachesCheckKey(t *testing.T) {
+	w, h := 128, 128
+	kc := make([]byte, KeyCheckLength)
+	for i := range kc {
+		kc[i] = byte(i + 1)
+	}
+	payload := []byte("key check")
+	for _, scatter := range []bool{true, false} {
+		eng := New(0)
+		eng.KeyCheck = kc
+		out, _, err := eng.Hide(make([]byte, w*h*3), w, h, payload, "pass", scatter)
+		if err != nil {
+			t.Fatalf("scatter=%v: hide failed: %v", scatter, err)
+		}
+
+		eng = New(0)
+		var seen []byte
+		eng.CheckKey = func(_ context.Context, got []byte) error {
+			seen = append([]byte(nil), got...)
+			return ErrWrongPassword
+		}
+		if _, _, _, _, err := eng.Extract(out, w, h, "pass"); err != ErrWrongPassword {
+			t.Fatalf("scatter=%v: expected ErrWrongPassword, got %v", scatter, err)
+		}
+		if !bytes.Equal(seen, kc) {
+			t.Fatalf("scatter=%v: CheckKey got %x, want %x", scatter, seen, kc)
+		}
+
+		eng.CheckKey = func(context.Context, []byte) error { return nil }
+		got, _, _, _, err := eng.Extract(out, w, h, "pass")
+		if err != nil || !bytes.Equal(got, payload) {
+			t.Fatalf("scatter=%v: extract: %q, %v", scatter, got, err)
+		}
+	}
+}
+
+func TestExtractWithoutKeyCheck(t *testing.T) {
+	w, h := 128, 128
+	payload := []byte("no key check")
+	out, _, err := New(0).Hide(make([]byte, w*h*3), w, h, payload, "pass", true)
+	if err != nil {
+		t.Fatalf("hide failed: %v", err)
+	}
+	eng := New(0)
+	eng.CheckKey = func(context.Context, []byte) error {
+		t.Fatal("CheckKey called for an image without a key check")
+		return nil
+	}
+	got, _, _, _, err := eng.Extract(out, w, h, "pass")
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("extract: %q, %v", got, err)
+	}
+}
+
+func TestParsePrefixKeepsLegacyLengthBits(t *testing.T) {
+	// Before key checks the length used every bit below the flags, so a
+	// payload of 256 MiB or more sets bit 28.
+	w, h := 20000, 20000
+	dataLen := uint32(1<<28 | 0x1234)
+	prefix := make([]byte, prefixLength)
+	binary.LittleEndian.PutUint32(prefix, dataLen|IntegrityFlag|BlockCheckFlag)
+	geometry := prefix[HeaderLength+IntegrityHashLen:]
+	binary.LittleEndian.PutUint32(geometry[0:4], uint32(w))
+	binary.LittleEndian.PutUint32(geometry[4:8], uint32(h))
+
+	_, plan, err := New(0).parsePrefix(prefix, w, h, "")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if plan.dataLen != int(dataLen) || plan.keyCheck != nil || plan.startSlot != prefixLength*4 {
+		t.Fatalf("legacy prefix misread: dataLen=%d keyCheck=%x start=%d", plan.dataLen, plan.keyCheck, plan.startSlot)
+	}
+}
+
 func TestHideExtractRoundTrip_NoScatter(t *testing.T) {
 	w, h := 128, 128
 	rgb := make([]byte, w*h*3)
@@ -59,3 +138,81 @@ func TestHideExtractRoundTrip_NoScatter(t *testing.T) {
 		}
 	}
 }
+
+func TestExtractDetailedFlagsDamagedBlocks(t *testing.T) {
+	w, h := 128, 128
+	rgb := make([]byte, w*h*3)
+	payload := make([]byte, 4096)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	eng := New(0)
+	out, _, err := eng.Hide(rgb, w, h, payload, "", false)
+	if err != nil {
+		t.Fatalf("hide failed: %v", err)
+	}
+	bodySlot := (HeaderLength + IntegrityHashLen + GeometryLength) * 4
+	for i := bodySlot + 4*1000; i < bodySlot+4*1100; i++ {
+		out[i] ^= 0x3
+	}
+
+	if _, _, _, _, err := eng.Extract(out, w, h, ""); err != ErrCRCMismatch {
+		t.Fatalf("expected crc mismatch, got %v", err)
+	}
+	r, err := eng.ExtractDetailed(out, w, h, "")
+	if err != nil {
+		t.Fatalf("extract detailed failed: %v", err)
+	}
+	if r.CRCValid || r.Cropped {
+		t.Fatalf("unexpected report: crcValid=%t cropped=%t", r.CRCValid, r.Cropped)
+	}
+	want := []ByteRange{{Start: 768, End: 1280}}
+	if len(r.Unreliable) != 1 || r.Unreliable[0] != want[0] {
+		t.Fatalf("unreliable = %v, want %v", r.Unreliable, want)
+	}
+}
+
+func TestExtractDetailedDetectsBottomCrop(t *testing.T) {
+	w, h := 128, 128
+	rgb := make([]byte, w*h*3)
+	payload := make([]byte, 11500)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	eng := New(0)
+	for _, scatter := range []bool{false, true} {
+		out, _, err := eng.Hide(rgb, w, h, payload, "pass", scatter)
+		if err != nil {
+			t.Fatalf("hide failed: %v", err)
+		}
+		croppedH := h - 10
+		r, err := eng.ExtractDetailed(out[:w*croppedH*3], w, croppedH, "pass")
+		if err != nil {
+			t.Fatalf("scatter=%t: extract detailed failed: %v", scatter, err)
+		}
+		if !r.Cropped || r.CRCValid {
+			t.Fatalf("scatter=%t: expected cropped, invalid crc", scatter)
+		}
+		flagged := 0
+		for _, rg := range r.Unreliable {
+			flagged += rg.End - rg.Start
+		}
+		for i := range payload {
+			if r.Data[i] != payload[i] && !inRanges(r.Unreliable, i) {
+				t.Fatalf("scatter=%t: byte %d damaged but not flagged", scatter, i)
+			}
+		}
+		if flagged == 0 || flagged == len(payload) {
+			t.Fatalf("scatter=%t: flagged %d of %d bytes", scatter, flagged, len(payload))
+		}
+	}
+}
+
+func inRanges(ranges []ByteRange, i int) bool {
+	for _, r := range ranges {
+		if i >= r.Start && i < r.End {
+			return true
+		}
+	}
+	return false
+}
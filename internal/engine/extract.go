@@ -1,8 +1,11 @@
 package engine
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash/crc32"
 )
 
 func extractBytes2bitAtSlot(rgb []byte, startSlot int, byteLen int) []byte {
@@ -32,118 +35,221 @@ func extractBytes2bitAtSlot(rgb []byte, startSlot int, byteLen int) []byte {
 	return out
 }
 
-func extractScatteredBytes2bit(rgb []byte, startSlot int, byteLen int, password string) []byte {
-	if byteLen <= 0 || startSlot < 0 || startSlot >= len(rgb) {
-		return nil
-	}
-	available := len(rgb) - startSlot
-	if available <= 0 {
-		return nil
-	}
-	if byteLen*4 > available {
-		byteLen = available / 4
-	}
-	if byteLen <= 0 {
-		return nil
-	}
-	a, b := scatterParams(password, available, []byte("scatter_body_v1"))
-	out := make([]byte, byteLen)
-	k := 0
-	for i := 0; i < byteLen; i++ {
-		var bt byte
-		for shift := 6; shift >= 0; shift -= 2 {
-			idx := startSlot + scatterSlotIndex(k, available, a, b)
-			two := rgb[idx] & 0x3
-			bt |= two << uint(shift)
-			k++
+// ByteRange is a half-open [Start, End) range of payload bytes.
+type ByteRange struct {
+	Start int
+	End   int
+}
+
+// ExtractReport describes an extraction that may have come from a damaged
+// image. When CRCValid is false, Unreliable lists the payload ranges that the
+// block checks or a detected crop flagged, so ECC can treat them as erasures.
+type ExtractReport struct {
+	Data          []byte
+	Integrity     bool
+	Scatter       bool
+	IntegrityHash []byte
+	CRCValid      bool
+	Cropped       bool
+	Unreliable    []ByteRange
+}
+
+func (e *Engine) Extract(rgb []byte, width, height int, password string) ([]byte, bool, bool, []byte, error) {
+	r, err := e.ExtractDetailed(rgb, width, height, password)
+	if err != nil {
+		if r != nil {
+			return nil, r.Integrity, r.Scatter, r.IntegrityHash, err
 		}
-		out[i] = bt
+		return nil, false, false, nil, err
 	}
-	return out
+	if !r.CRCValid {
+		return nil, r.Integrity, r.Scatter, r.IntegrityHash, ErrCRCMismatch
+	}
+	return r.Data, r.Integrity, r.Scatter, r.IntegrityHash, nil
 }
 
-func (e *Engine) Extract(rgb []byte, width, height int, password string) ([]byte, bool, bool, []byte, error) {
+// ExtractDetailed extracts the payload like Extract but does not give up on a
+// CRC mismatch: the data is returned with CRCValid false and, for images with
+// block checks, the ranges that are known to be damaged.
+func (e *Engine) ExtractDetailed(rgb []byte, width, height int, password string) (*ExtractReport, error) {
+	return e.ExtractDetailedContext(context.Background(), rgb, width, height, password)
+}
+
+// ExtractDetailedContext is ExtractDetailed, checking ctx between bands.
+func (e *Engine) ExtractDetailedContext(ctx context.Context, rgb []byte, width, height int, password string) (*ExtractReport, error) {
 	if len(rgb) != width*height*3 {
-		return nil, false, false, nil, errors.New("invalid rgb buffer size")
-	}
-	headerBytes := extractBytes2bitAtSlot(rgb, 0, HeaderLength)
-	if len(headerBytes) != HeaderLength {
-		return nil, false, false, nil, errors.New("invalid header")
+		return nil, errors.New("invalid rgb buffer size")
 	}
-	rawLen := binary.LittleEndian.Uint32(headerBytes)
-	integrityEnabled := (rawLen & IntegrityFlag) != 0
-	scatterEnabled := (rawLen & ScatterFlag) != 0
-	dataLen := int(rawLen & ^uint32(IntegrityFlag|ScatterFlag))
-	if !integrityEnabled {
+	return e.ExtractStreamContext(ctx, NewRGBRows(rgb, width, height), password)
+}
+
+// extractPlan locates the body described by a parsed prefix.
+type extractPlan struct {
+	dataLen    int
+	bodyLen    int
+	startSlot  int
+	virtualLen int
+	blockCheck bool
+	scatter    bool
+	keyCheck   []byte
+	// Scatter permutation over the n slots after startSlot.
+	a, b, n int
+	aInv    int
+}
+
+// maxGeometrySide bounds the image sides a recorded geometry may claim.
+const maxGeometrySide = 1 << 16
+
+// parsePrefix interprets the header, integrity hash and geometry read from
+// slot 0 of an image of the given size. prefix may be short for tiny images.
+func (e *Engine) parsePrefix(prefix []byte, width, height int, password string) (*ExtractReport, *extractPlan, error) {
+	if len(prefix) < HeaderLength {
+		return nil, nil, fmt.Errorf("%w: invalid header", ErrNoPayload)
+	}
+	rawLen := binary.LittleEndian.Uint32(prefix)
+	r := &ExtractReport{
+		Integrity: (rawLen & IntegrityFlag) != 0,
+		Scatter:   (rawLen & ScatterFlag) != 0,
+	}
+	blockCheck := r.Integrity && (rawLen&BlockCheckFlag) != 0
+	keyCheck := false
+	dataLen := int(rawLen & maxDataLength)
+	if !r.Integrity {
 		dataLen = int(rawLen)
 	}
 
-	maxSize := e.CalculateMaxCapacity(width, height, true)
+	origWidth, origHeight := width, height
+	if blockCheck {
+		if len(prefix) < prefixLength {
+			return r, nil, fmt.Errorf("%w: invalid geometry", ErrNoPayload)
+		}
+		geometry := prefix[HeaderLength+IntegrityHashLen:]
+		switch geometry[3] {
+		case 0:
+		case keyCheckVersion:
+			keyCheck = true
+		default:
+			return r, nil, fmt.Errorf("%w: invalid geometry", ErrNoPayload)
+		}
+		origWidth = int(binary.LittleEndian.Uint32(geometry[0:4]) & geometryWidthMask)
+		origHeight = int(binary.LittleEndian.Uint32(geometry[4:8]))
+		if origWidth != width || origHeight < height {
+			// Random pixels rarely spell out a believable size; only a
+			// plausible one means a stego image was resized or cropped.
+			if origWidth <= 0 || origWidth > maxGeometrySide || origHeight <= 0 || origHeight > maxGeometrySide {
+				return r, nil, fmt.Errorf("%w: invalid geometry", ErrNoPayload)
+			}
+			return r, nil, ErrGeometryChanged
+		}
+		r.Cropped = origHeight > height
+	}
+
+	maxSize := e.CalculateMaxCapacity(origWidth, origHeight, true)
 	maxSize = maxSize - HeaderLength - CRCLength + 32
-	if integrityEnabled {
+	if r.Integrity {
 		maxSize -= IntegrityHashLen
 	}
+	if blockCheck {
+		maxSize -= GeometryLength + blockChecksLength(dataLen)
+	}
+	if keyCheck {
+		maxSize -= KeyCheckLength
+	}
 	if dataLen <= 0 || dataLen > maxSize {
-		return nil, integrityEnabled, scatterEnabled, nil, errors.New("invalid data length")
+		return r, nil, fmt.Errorf("%w: invalid data length", ErrNoPayload)
 	}
 
-	var integrityBytes []byte
-	if integrityEnabled {
-		integritySlotStart := (HeaderLength * 8) / 2
-		integrityBytes = extractBytes2bitAtSlot(rgb, integritySlotStart, IntegrityHashLen)
-		if len(integrityBytes) != IntegrityHashLen {
-			return nil, integrityEnabled, scatterEnabled, nil, errors.New("invalid integrity")
+	if r.Integrity {
+		if len(prefix) < HeaderLength+IntegrityHashLen {
+			return r, nil, fmt.Errorf("%w: invalid integrity", ErrNoPayload)
 		}
+		r.IntegrityHash = append([]byte(nil), prefix[HeaderLength:HeaderLength+IntegrityHashLen]...)
 	}
 
 	fixedLen := HeaderLength
-	if integrityEnabled {
+	if r.Integrity {
 		fixedLen += IntegrityHashLen
 	}
-	startSlot := (fixedLen * 8) / 2
-
-	var extractedData []byte
-	var crcBytes []byte
-	if scatterEnabled {
-		if password == "" {
-			return nil, integrityEnabled, scatterEnabled, integrityBytes, errors.New("password required for scattered data")
-		}
-		body := extractScatteredBytes2bit(rgb, startSlot, dataLen+CRCLength, password)
-		if len(body) != dataLen+CRCLength {
-			return nil, integrityEnabled, scatterEnabled, integrityBytes, errors.New("invalid scattered payload length")
-		}
-		extractedData = body[:dataLen]
-		crcBytes = body[dataLen:]
-	} else {
-		body := extractBytes2bitAtSlot(rgb, startSlot, dataLen+CRCLength)
-		if len(body) != dataLen+CRCLength {
-			return nil, integrityEnabled, scatterEnabled, integrityBytes, errors.New("invalid payload length")
-		}
-		extractedData = body[:dataLen]
-		crcBytes = body[dataLen:]
+	if blockCheck {
+		fixedLen += GeometryLength
 	}
-	if !verifyCRC32(extractedData, crcBytes) {
-		return nil, integrityEnabled, scatterEnabled, integrityBytes, errors.New("crc32 verify failed")
+	if keyCheck {
+		fixedLen += KeyCheckLength
 	}
 
-	if integrityEnabled && len(integrityBytes) == IntegrityHashLen {
-		actual, err := embeddedPixelHash(rgb, width, height)
-		if err == nil {
-			_ = equalBytes(actual, integrityBytes)
+	if r.Scatter && password == "" {
+		return r, nil, ErrPasswordRequired
+	}
+	plan := &extractPlan{
+		dataLen:    dataLen,
+		bodyLen:    dataLen + CRCLength,
+		startSlot:  (fixedLen * 8) / 2,
+		virtualLen: origWidth * origHeight * 3,
+		blockCheck: blockCheck,
+		scatter:    r.Scatter,
+	}
+	if blockCheck {
+		plan.bodyLen += blockChecksLength(dataLen)
+	}
+	if keyCheck {
+		if len(prefix) < maxPrefixLength {
+			return r, nil, fmt.Errorf("%w: invalid key check", ErrNoPayload)
 		}
+		plan.keyCheck = prefix[prefixLength:maxPrefixLength]
+	}
+	plan.n = plan.virtualLen - plan.startSlot
+	if plan.scatter && plan.n > 0 {
+		plan.a, plan.b = scatterParams(password, plan.n, []byte("scatter_body_v1"))
+		plan.aInv = modInverse(plan.a, plan.n)
 	}
+	return r, plan, nil
+}
 
-	return extractedData, integrityEnabled, scatterEnabled, integrityBytes, nil
+// finish splits the extracted body into data and CRC and fills in the
+// verification fields of r.
+func (p *extractPlan) finish(r *ExtractReport, body []byte, missing []bool) {
+	r.Data = body[:p.dataLen]
+	crcBytes := body[p.dataLen : p.dataLen+CRCLength]
+	r.CRCValid = missing == nil && verifyCRC32(r.Data, crcBytes)
+	if !r.CRCValid && p.blockCheck {
+		r.Unreliable = unreliableBlocks(r.Data, body[p.dataLen+CRCLength:], missing)
+	}
 }
 
-func equalBytes(a, b []byte) bool {
-	if len(a) != len(b) {
-		return false
+// unreliableBlocks returns the merged payload ranges that cannot be trusted:
+// bytes that were cropped away, and BlockCheckSize blocks whose stored check
+// does not match. Blocks with cropped bytes or a cropped check are not
+// verified, only their missing bytes are flagged.
+func unreliableBlocks(data, checks []byte, missing []bool) []ByteRange {
+	var out []ByteRange
+	mark := func(start, end int) {
+		if n := len(out); n > 0 && out[n-1].End >= start {
+			if end > out[n-1].End {
+				out[n-1].End = end
+			}
+			return
+		}
+		out = append(out, ByteRange{Start: start, End: end})
 	}
-	for i := range a {
-		if a[i] != b[i] {
-			return false
+	checksOff := len(data) + CRCLength
+	for blk, off := 0, 0; off < len(data); blk, off = blk+1, off+BlockCheckSize {
+		end := off + BlockCheckSize
+		if end > len(data) {
+			end = len(data)
+		}
+		ci := blk * BlockCheckLen
+		verifiable := missing == nil || !(missing[checksOff+ci] || missing[checksOff+ci+1])
+		if missing != nil {
+			for i := off; i < end; i++ {
+				if missing[i] {
+					mark(i, i+1)
+					verifiable = false
+				}
+			}
+		}
+		if verifiable && binary.LittleEndian.Uint16(checks[ci:ci+BlockCheckLen]) != uint16(crc32.ChecksumIEEE(data[off:end])) {
+			mark(off, end)
 		}
 	}
-	return true
+	return out
 }
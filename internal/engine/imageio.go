@@ -2,37 +2,59 @@ package engine
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"image"
 	"image/draw"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"os"
 	"path/filepath"
-	"strings"
 )
 
 func LoadImageRGB(path string) ([]byte, int, int, error) {
-	f, err := os.Open(path)
+	rows, err := OpenImageRows(path)
 	if err != nil {
 		return nil, 0, 0, err
 	}
-	defer func() { _ = f.Close() }()
+	defer func() { _ = rows.Close() }()
+	if m, ok := rows.(*memoryRows); ok {
+		return m.rgb, m.width, m.height, nil
+	}
+	w, h := rows.Size()
+	rgb := make([]byte, w*h*3)
+	for off := 0; off < len(rgb); {
+		n, err := rows.ReadRows(rgb[off:])
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		off += n * w * 3
+	}
+	return rgb, w, h, nil
+}
 
-	ext := strings.ToLower(filepath.Ext(path))
+func decodeImageRGB(r io.Reader, ext string) ([]byte, int, int, error) {
 	var img image.Image
+	var err error
 	switch ext {
 	case ".png":
-		img, err = png.Decode(f)
+		img, err = png.Decode(r)
 	case ".jpg", ".jpeg":
-		img, err = jpeg.Decode(f)
+		img, err = jpeg.Decode(r)
 	default:
-		img, _, err = image.Decode(f)
+		img, _, err = image.Decode(r)
 	}
 	if err != nil {
 		return nil, 0, 0, err
 	}
 
+	return ImageToRGB(img)
+}
+
+// ImageToRGB flattens img into the packed RGB layout used by Hide and
+// Extract.
+func ImageToRGB(img image.Image) ([]byte, int, int, error) {
 	b := img.Bounds()
 	w, h := b.Dx(), b.Dy()
 	if w <= 0 || h <= 0 {
@@ -56,9 +78,10 @@ func LoadImageRGB(path string) ([]byte, int, int, error) {
 	return rgb, w, h, nil
 }
 
-func SaveRGBAsPNG(path string, rgb []byte, width, height int) error {
+// RGBToImage wraps a packed RGB buffer as an opaque RGBA image.
+func RGBToImage(rgb []byte, width, height int) (*image.RGBA, error) {
 	if len(rgb) != width*height*3 {
-		return errors.New("invalid rgb buffer size")
+		return nil, errors.New("invalid rgb buffer size")
 	}
 	img := image.NewRGBA(image.Rect(0, 0, width, height))
 	pix := img.Pix
@@ -68,7 +91,36 @@ func SaveRGBAsPNG(path string, rgb []byte, width, height int) error {
 		pix[dst+2] = rgb[src+2]
 		pix[dst+3] = 0xFF
 	}
+	return img, nil
+}
+
+func SaveRGBAsPNG(path string, rgb []byte, width, height int) error {
+	return SaveRGBAsPNGContext(context.Background(), path, rgb, width, height)
+}
+
+// SaveRGBAsPNGContext is SaveRGBAsPNG, encoding about 1 MiB of rows at a
+// time and checking ctx in between.
+func SaveRGBAsPNGContext(ctx context.Context, path string, rgb []byte, width, height int) error {
+	if len(rgb) != width*height*3 {
+		return errors.New("invalid rgb buffer size")
+	}
+	return WritePNGFile(path, width, height, func(w RowWriter) error {
+		step := New(0).bandRows(width) * width * 3
+		for lo := 0; lo < len(rgb); lo += step {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := w.WriteRows(rgb[lo:min(lo+step, len(rgb))]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
 
+// WritePNGFile creates path and lets fill write its rows through a
+// streaming PNG encoder. The file is removed if anything fails.
+func WritePNGFile(path string, width, height int, fill func(RowWriter) error) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return err
 	}
@@ -76,11 +128,28 @@ func SaveRGBAsPNG(path string, rgb []byte, width, height int) error {
 	if err != nil {
 		return err
 	}
-	defer func() { _ = f.Close() }()
-
-	w := bufio.NewWriterSize(f, 1<<20)
-	defer func() { _ = w.Flush() }()
+	bw := bufio.NewWriterSize(f, 1<<20)
+	pw, err := NewPNGRowWriter(bw, width, height)
+	if err == nil {
+		err = fill(pw)
+	}
+	if err == nil {
+		err = pw.Close()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	return err
+}
 
+// EncodePNG writes img with the fast compression level used for stego output.
+func EncodePNG(w io.Writer, img image.Image) error {
 	enc := png.Encoder{CompressionLevel: png.BestSpeed}
 	return enc.Encode(w, img)
 }
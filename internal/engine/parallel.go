@@ -0,0 +1,39 @@
+package engine
+
+import (
+	"runtime"
+	"sync"
+)
+
+// parallelThreshold is the smallest job, in loop iterations per worker,
+// worth spreading across goroutines.
+var parallelThreshold = 32 << 10
+
+// parallelFor splits [0, n) into contiguous ranges, at most one per
+// GOMAXPROCS, and runs fn on them concurrently. The ranges are disjoint, so
+// fn may write to its own part of a shared slice without locking, and the
+// combined result is identical to a single fn(0, n) call.
+func parallelFor(n int, fn func(lo, hi int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if max := n / parallelThreshold; workers > max {
+		workers = max
+	}
+	if workers <= 1 {
+		fn(0, n)
+		return
+	}
+	step := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for lo := 0; lo < n; lo += step {
+		hi := lo + step
+		if hi > n {
+			hi = n
+		}
+		wg.Add(1)
+		go func(lo, hi int) {
+			defer wg.Done()
+			fn(lo, hi)
+		}(lo, hi)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestParallelOutputMatchesSequential(t *testing.T) {
+	defer func(v int) { parallelThreshold = v }(parallelThreshold)
+	parallelThreshold = 64
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+
+	w, h := 211, 97
+	rgb := make([]byte, w*h*3)
+	rand.New(rand.NewSource(4)).Read(rgb)
+	payload := make([]byte, 12000)
+	rand.New(rand.NewSource(5)).Read(payload)
+	eng := New(1)
+
+	type result struct {
+		hidden, streamed, hash []byte
+		report, streamReport   *ExtractReport
+	}
+	run := func(procs int, scatter bool) result {
+		runtime.GOMAXPROCS(procs)
+		var r result
+		var err error
+		if r.hidden, r.hash, err = eng.Hide(rgb, w, h, payload, "pass", scatter); err != nil {
+			t.Fatal(err)
+		}
+		var out bufferRows
+		open := func() (RowReader, error) { return NewRGBRows(rgb, w, h), nil }
+		if _, err := eng.HideStream(open, &out, payload, "pass", scatter); err != nil {
+			t.Fatal(err)
+		}
+		r.streamed = out.Bytes()
+		// Crop the bottom so the missing-byte bookkeeping runs too.
+		cropped := h - 5
+		if r.report, err = eng.ExtractDetailed(r.hidden[:w*cropped*3], w, cropped, "pass"); err != nil {
+			t.Fatal(err)
+		}
+		if r.streamReport, err = eng.ExtractStream(NewRGBRows(r.hidden, w, h), "pass"); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	for _, scatter := range []bool{false, true} {
+		seq, par := run(1, scatter), run(4, scatter)
+		if !bytes.Equal(seq.hidden, par.hidden) || !bytes.Equal(seq.streamed, par.streamed) || !bytes.Equal(seq.hash, par.hash) {
+			t.Fatalf("scatter=%t: parallel embedding differs", scatter)
+		}
+		if !reflect.DeepEqual(seq.report, par.report) || !reflect.DeepEqual(seq.streamReport, par.streamReport) {
+			t.Fatalf("scatter=%t: parallel extraction differs", scatter)
+		}
+		if !bytes.Equal(par.streamReport.Data, payload) {
+			t.Fatalf("scatter=%t: payload mismatch", scatter)
+		}
+	}
+}
+
+// benchmarkCarrier returns a 3 MP carrier and a payload that nearly fills it.
+func benchmarkCarrier(b *testing.B) ([]byte, int, int, []byte) {
+	w, h := 2048, 1536
+	rgb := make([]byte, w*h*3)
+	rand.New(rand.NewSource(6)).Read(rgb)
+	payload := make([]byte, New(0).CalculateMaxCapacity(w, h, true)*9/10)
+	rand.New(rand.NewSource(7)).Read(payload)
+	b.SetBytes(int64(len(rgb)))
+	b.ResetTimer()
+	return rgb, w, h, payload
+}
+
+func benchmarkHide(b *testing.B, scatter bool) {
+	rgb, w, h, payload := benchmarkCarrier(b)
+	eng := New(0)
+	for i := 0; i < b.N; i++ {
+		if _, _, err := eng.Hide(rgb, w, h, payload, "bench", scatter); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkExtract(b *testing.B, scatter bool) {
+	rgb, w, h, payload := benchmarkCarrier(b)
+	eng := New(0)
+	b.StopTimer()
+	out, _, err := eng.Hide(rgb, w, h, payload, "bench", scatter)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := eng.ExtractDetailed(out, w, h, "bench"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Run the benchmarks with -cpu 1,2,4 to see the speedup over one worker.
+func BenchmarkHide(b *testing.B)           { benchmarkHide(b, false) }
+func BenchmarkHideScatter(b *testing.B)    { benchmarkHide(b, true) }
+func BenchmarkExtract(b *testing.B)        { benchmarkExtract(b, false) }
+func BenchmarkExtractScatter(b *testing.B) { benchmarkExtract(b, true) }
+
+func BenchmarkHideStreamScatter(b *testing.B) {
+	rgb, w, h, payload := benchmarkCarrier(b)
+	eng := New(0)
+	open := func() (RowReader, error) { return NewRGBRows(rgb, w, h), nil }
+	for i := 0; i < b.N; i++ {
+		if _, err := eng.HideStream(open, discardRows{}, payload, "bench", true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExtractStreamScatter(b *testing.B) {
+	rgb, w, h, payload := benchmarkCarrier(b)
+	eng := New(0)
+	b.StopTimer()
+	out, _, err := eng.Hide(rgb, w, h, payload, "bench", true)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := eng.ExtractStream(NewRGBRows(out, w, h), "bench"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type discardRows struct{}
+
+func (discardRows) WriteRows([]byte) error { return nil }
@@ -0,0 +1,472 @@
+package engine
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// The standard library decodes and encodes whole images. These types
+// stream non-interlaced 8-bit PNGs one row at a time so that huge carriers
+// never have to be held in memory; other files fall back to image.Decode.
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+var errPNGUnsupported = errors.New("png layout not supported for streaming")
+
+const (
+	pngGray      = 0
+	pngRGB       = 2
+	pngPalette   = 3
+	pngGrayAlpha = 4
+	pngRGBA      = 6
+)
+
+const (
+	pngFilterNone = iota
+	pngFilterSub
+	pngFilterUp
+	pngFilterAverage
+	pngFilterPaeth
+)
+
+// pngRowReader decodes a PNG into packed RGB rows with the same values
+// LoadImageRGB produces: colors with alpha are premultiplied, as draw.Draw
+// does when converting to RGBA.
+type pngRowReader struct {
+	width     int
+	height    int
+	colorType byte
+	bpp       int
+	palette   [][3]byte
+	zr        io.ReadCloser
+	idat      *pngIDATReader
+	cur, prev []byte
+	row       int
+}
+
+func newPNGRowReader(r io.Reader) (*pngRowReader, error) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(br, sig); err != nil || string(sig) != string(pngSignature) {
+		return nil, errPNGUnsupported
+	}
+	p := &pngRowReader{}
+	var alpha []byte
+	for first := true; ; first = false {
+		length, typ, err := readPNGChunkHeader(br)
+		if err != nil {
+			return nil, err
+		}
+		if first != (typ == "IHDR") {
+			return nil, errors.New("png: IHDR must come first")
+		}
+		if typ == "IDAT" {
+			if p.width == 0 {
+				return nil, errors.New("png: missing IHDR")
+			}
+			if p.colorType == pngPalette && p.palette == nil {
+				return nil, errors.New("png: missing palette")
+			}
+			p.idat = &pngIDATReader{r: br, remaining: length, crc: crc32.NewIEEE()}
+			_, _ = p.idat.crc.Write([]byte(typ))
+			break
+		}
+		if length > 1<<24 {
+			return nil, errors.New("png: chunk too large")
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+		if err := checkPNGChunkCRC(br, typ, data); err != nil {
+			return nil, err
+		}
+		switch typ {
+		case "IHDR":
+			if err := p.parseIHDR(data); err != nil {
+				return nil, err
+			}
+		case "PLTE":
+			if len(data)%3 != 0 || len(data) == 0 || len(data) > 256*3 {
+				return nil, errors.New("png: bad PLTE")
+			}
+			p.palette = make([][3]byte, len(data)/3)
+			for i := range p.palette {
+				copy(p.palette[i][:], data[i*3:])
+			}
+		case "tRNS":
+			if p.colorType != pngPalette {
+				return nil, errPNGUnsupported
+			}
+			alpha = data
+		case "IEND":
+			return nil, errors.New("png: no image data")
+		}
+	}
+	if alpha != nil {
+		if len(alpha) > len(p.palette) {
+			return nil, errors.New("png: bad tRNS")
+		}
+		for i, a := range alpha {
+			c := &p.palette[i]
+			c[0], c[1], c[2] = premultiply(c[0], a), premultiply(c[1], a), premultiply(c[2], a)
+		}
+	}
+	zr, err := zlib.NewReader(p.idat)
+	if err != nil {
+		return nil, err
+	}
+	p.zr = zr
+	p.cur = make([]byte, 1+p.width*p.bpp)
+	p.prev = make([]byte, 1+p.width*p.bpp)
+	return p, nil
+}
+
+func (p *pngRowReader) parseIHDR(data []byte) error {
+	if len(data) != 13 {
+		return errors.New("png: bad IHDR")
+	}
+	w, h := binary.BigEndian.Uint32(data[0:4]), binary.BigEndian.Uint32(data[4:8])
+	if w == 0 || h == 0 || w > 1<<24 || h > 1<<24 {
+		return errors.New("invalid image size")
+	}
+	depth, colorType, interlace := data[8], data[9], data[12]
+	if depth != 8 || interlace != 0 {
+		return errPNGUnsupported
+	}
+	switch colorType {
+	case pngGray, pngPalette:
+		p.bpp = 1
+	case pngGrayAlpha:
+		p.bpp = 2
+	case pngRGB:
+		p.bpp = 3
+	case pngRGBA:
+		p.bpp = 4
+	default:
+		return fmt.Errorf("png: bad color type %d", colorType)
+	}
+	p.width, p.height, p.colorType = int(w), int(h), colorType
+	return nil
+}
+
+func (p *pngRowReader) Size() (int, int) { return p.width, p.height }
+
+func (p *pngRowReader) ReadRows(dst []byte) (int, error) {
+	rowLen := p.width * 3
+	n := 0
+	for ; n < len(dst)/rowLen && p.row < p.height; n++ {
+		if err := p.readRow(dst[n*rowLen : (n+1)*rowLen]); err != nil {
+			return n, err
+		}
+	}
+	if n == 0 && p.row == p.height {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (p *pngRowReader) readRow(out []byte) error {
+	p.cur, p.prev = p.prev, p.cur
+	if _, err := io.ReadFull(p.zr, p.cur); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("png: %w", err)
+	}
+	if err := unfilterPNGRow(p.cur, p.prev, p.bpp); err != nil {
+		return err
+	}
+	p.row++
+	src := p.cur[1:]
+	switch p.colorType {
+	case pngRGB:
+		copy(out, src)
+	case pngRGBA:
+		for i, j := 0, 0; j < len(out); i, j = i+4, j+3 {
+			a := src[i+3]
+			out[j], out[j+1], out[j+2] = premultiply(src[i], a), premultiply(src[i+1], a), premultiply(src[i+2], a)
+		}
+	case pngGray:
+		for i, j := 0, 0; j < len(out); i, j = i+1, j+3 {
+			out[j], out[j+1], out[j+2] = src[i], src[i], src[i]
+		}
+	case pngGrayAlpha:
+		for i, j := 0, 0; j < len(out); i, j = i+2, j+3 {
+			y := premultiply(src[i], src[i+1])
+			out[j], out[j+1], out[j+2] = y, y, y
+		}
+	case pngPalette:
+		for i, j := 0, 0; j < len(out); i, j = i+1, j+3 {
+			if int(src[i]) >= len(p.palette) {
+				return errors.New("png: palette index out of range")
+			}
+			c := p.palette[src[i]]
+			out[j], out[j+1], out[j+2] = c[0], c[1], c[2]
+		}
+	}
+	return nil
+}
+
+func (p *pngRowReader) Close() error { return p.zr.Close() }
+
+// premultiply matches the NRGBA to RGBA conversion in image/draw.
+func premultiply(c, a byte) byte {
+	sa := uint32(a) * 0x101
+	return uint8(uint32(c) * sa / 0xff >> 8)
+}
+
+func unfilterPNGRow(cur, prev []byte, bpp int) error {
+	cdat, pdat := cur[1:], prev[1:]
+	switch cur[0] {
+	case pngFilterNone:
+	case pngFilterSub:
+		for i := bpp; i < len(cdat); i++ {
+			cdat[i] += cdat[i-bpp]
+		}
+	case pngFilterUp:
+		for i, p := range pdat {
+			cdat[i] += p
+		}
+	case pngFilterAverage:
+		for i := 0; i < bpp; i++ {
+			cdat[i] += pdat[i] / 2
+		}
+		for i := bpp; i < len(cdat); i++ {
+			cdat[i] += uint8((int(cdat[i-bpp]) + int(pdat[i])) / 2)
+		}
+	case pngFilterPaeth:
+		for i := 0; i < bpp; i++ {
+			cdat[i] += pdat[i]
+		}
+		for i := bpp; i < len(cdat); i++ {
+			cdat[i] += paeth(cdat[i-bpp], pdat[i], pdat[i-bpp])
+		}
+	default:
+		return errors.New("png: bad filter type")
+	}
+	return nil
+}
+
+func paeth(a, b, c uint8) uint8 {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := absInt(p-int(a)), absInt(p-int(b)), absInt(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func readPNGChunkHeader(r io.Reader) (uint32, string, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, "", fmt.Errorf("png: %w", err)
+	}
+	return binary.BigEndian.Uint32(hdr[0:4]), string(hdr[4:8]), nil
+}
+
+func checkPNGChunkCRC(r io.Reader, typ string, data []byte) error {
+	var sum [4]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return fmt.Errorf("png: %w", err)
+	}
+	h := crc32.NewIEEE()
+	_, _ = h.Write([]byte(typ))
+	_, _ = h.Write(data)
+	if h.Sum32() != binary.BigEndian.Uint32(sum[:]) {
+		return errors.New("png: invalid checksum")
+	}
+	return nil
+}
+
+// pngIDATReader concatenates the data of consecutive IDAT chunks.
+type pngIDATReader struct {
+	r         io.Reader
+	remaining uint32
+	crc       hash.Hash32
+	done      bool
+}
+
+func (d *pngIDATReader) Read(p []byte) (int, error) {
+	for d.remaining == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		var sum [4]byte
+		if _, err := io.ReadFull(d.r, sum[:]); err != nil {
+			return 0, fmt.Errorf("png: %w", err)
+		}
+		if d.crc.Sum32() != binary.BigEndian.Uint32(sum[:]) {
+			return 0, errors.New("png: invalid checksum")
+		}
+		length, typ, err := readPNGChunkHeader(d.r)
+		if err != nil {
+			return 0, err
+		}
+		if typ != "IDAT" {
+			d.done = true
+			return 0, io.EOF
+		}
+		d.remaining = length
+		d.crc.Reset()
+		_, _ = d.crc.Write([]byte(typ))
+	}
+	if uint32(len(p)) > d.remaining {
+		p = p[:d.remaining]
+	}
+	n, err := d.r.Read(p)
+	_, _ = d.crc.Write(p[:n])
+	d.remaining -= uint32(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// PNGRowWriter encodes packed RGB rows as an opaque 8-bit RGB PNG.
+type PNGRowWriter struct {
+	w      io.Writer
+	chunks *bufio.Writer
+	zw     *zlib.Writer
+	width  int
+	height int
+	row    int
+	prev   []byte
+	cr     [5][]byte
+}
+
+func NewPNGRowWriter(w io.Writer, width, height int) (*PNGRowWriter, error) {
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("invalid image size")
+	}
+	if _, err := w.Write(pngSignature); err != nil {
+		return nil, err
+	}
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(height))
+	ihdr[8], ihdr[9] = 8, pngRGB
+	if err := writePNGChunk(w, "IHDR", ihdr); err != nil {
+		return nil, err
+	}
+	p := &PNGRowWriter{w: w, width: width, height: height, prev: make([]byte, 1+width*3)}
+	for i := range p.cr {
+		p.cr[i] = make([]byte, 1+width*3)
+		p.cr[i][0] = byte(i)
+	}
+	p.chunks = bufio.NewWriterSize(pngIDATWriter{w}, 64<<10)
+	zw, err := zlib.NewWriterLevel(p.chunks, zlib.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	p.zw = zw
+	return p, nil
+}
+
+// WriteRows encodes whole rows; len(rows) must be a multiple of width*3.
+func (p *PNGRowWriter) WriteRows(rows []byte) error {
+	rowLen := p.width * 3
+	if len(rows)%rowLen != 0 || p.row+len(rows)/rowLen > p.height {
+		return errors.New("png: row data does not match image size")
+	}
+	for off := 0; off < len(rows); off += rowLen {
+		copy(p.cr[0][1:], rows[off:off+rowLen])
+		f := choosePNGFilter(&p.cr, p.prev, 3)
+		if _, err := p.zw.Write(p.cr[f]); err != nil {
+			return err
+		}
+		p.prev, p.cr[0] = p.cr[0], p.prev
+		p.prev[0], p.cr[0][0] = 0, 0
+		p.row++
+	}
+	return nil
+}
+
+// Close finishes the image data. It does not close the underlying writer.
+func (p *PNGRowWriter) Close() error {
+	if p.row != p.height {
+		return fmt.Errorf("png: wrote %d of %d rows", p.row, p.height)
+	}
+	if err := p.zw.Close(); err != nil {
+		return err
+	}
+	if err := p.chunks.Flush(); err != nil {
+		return err
+	}
+	return writePNGChunk(p.w, "IEND", nil)
+}
+
+type pngIDATWriter struct{ w io.Writer }
+
+func (c pngIDATWriter) Write(b []byte) (int, error) {
+	if err := writePNGChunk(c.w, "IDAT", b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func writePNGChunk(w io.Writer, typ string, data []byte) error {
+	buf := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data)))
+	copy(buf[4:8], typ)
+	buf = append(buf, data...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	_, err := w.Write(buf)
+	return err
+}
+
+// choosePNGFilter applies every filter to cr[0] and returns the one with
+// the smallest sum of absolute differences, the heuristic image/png uses.
+func choosePNGFilter(cr *[5][]byte, prev []byte, bpp int) int {
+	cdat, pdat := cr[0][1:], prev[1:]
+	sub, up, avg, pae := cr[pngFilterSub][1:], cr[pngFilterUp][1:], cr[pngFilterAverage][1:], cr[pngFilterPaeth][1:]
+	var sums [5]int
+	for i := range cdat {
+		var left, upLeft byte
+		if i >= bpp {
+			left, upLeft = cdat[i-bpp], pdat[i-bpp]
+		}
+		sub[i] = cdat[i] - left
+		up[i] = cdat[i] - pdat[i]
+		avg[i] = cdat[i] - uint8((int(left)+int(pdat[i]))/2)
+		pae[i] = cdat[i] - paeth(left, pdat[i], upLeft)
+		sums[pngFilterNone] += abs8(cdat[i])
+		sums[pngFilterSub] += abs8(sub[i])
+		sums[pngFilterUp] += abs8(up[i])
+		sums[pngFilterAverage] += abs8(avg[i])
+		sums[pngFilterPaeth] += abs8(pae[i])
+	}
+	best := pngFilterUp
+	for _, f := range []int{pngFilterPaeth, pngFilterNone, pngFilterSub, pngFilterAverage} {
+		if sums[f] < sums[best] {
+			best = f
+		}
+	}
+	return best
+}
+
+func abs8(d uint8) int {
+	if d < 128 {
+		return int(d)
+	}
+	return 256 - int(d)
+}
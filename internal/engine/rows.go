@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RowReader yields an image as packed RGB rows from top to bottom.
+type RowReader interface {
+	Size() (width, height int)
+	// ReadRows fills whole rows of dst and returns how many it read, or
+	// io.EOF once every row has been returned.
+	ReadRows(dst []byte) (int, error)
+	Close() error
+}
+
+// RowWriter receives packed RGB rows from top to bottom.
+type RowWriter interface {
+	WriteRows(rows []byte) error
+}
+
+// OpenImageRows streams a non-interlaced 8-bit PNG row by row. Any other
+// image is decoded in full and served from memory.
+func OpenImageRows(path string) (RowReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, len(pngSignature))
+	_, _ = io.ReadFull(f, head)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if bytes.Equal(head, pngSignature) {
+		p, err := newPNGRowReader(f)
+		if err == nil {
+			return &fileRows{pngRowReader: p, f: f}, nil
+		}
+		if !errors.Is(err, errPNGUnsupported) {
+			_ = f.Close()
+			return nil, err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	defer func() { _ = f.Close() }()
+	rgb, w, h, err := decodeImageRGB(f, strings.ToLower(filepath.Ext(path)))
+	if err != nil {
+		return nil, err
+	}
+	return NewRGBRows(rgb, w, h), nil
+}
+
+type fileRows struct {
+	*pngRowReader
+	f *os.File
+}
+
+func (r *fileRows) Close() error {
+	_ = r.pngRowReader.Close()
+	return r.f.Close()
+}
+
+// NewRGBRows serves an in-memory packed RGB buffer as a RowReader.
+func NewRGBRows(rgb []byte, width, height int) RowReader {
+	return &memoryRows{rgb: rgb, width: width, height: height}
+}
+
+type memoryRows struct {
+	rgb           []byte
+	width, height int
+	off           int
+}
+
+func (m *memoryRows) Size() (int, int) { return m.width, m.height }
+
+func (m *memoryRows) ReadRows(dst []byte) (int, error) {
+	if m.off >= len(m.rgb) {
+		return 0, io.EOF
+	}
+	rowLen := m.width * 3
+	n := copy(dst[:len(dst)/rowLen*rowLen], m.rgb[m.off:]) / rowLen
+	m.off += n * rowLen
+	return n, nil
+}
+
+func (m *memoryRows) Close() error { return nil }
+
+// bandRows is the number of rows processed at once: about ChunkSize bytes,
+// but never less than the fixed prefix so it can be parsed from one band.
+func (e *Engine) bandRows(width int) int {
+	rowLen := width * 3
+	rows := e.ChunkSize / rowLen
+	if min := (maxPrefixLength*4 + rowLen - 1) / rowLen; rows < min {
+		rows = min
+	}
+	return rows
+}
+
+// OpenImageSource probes path for HideStream, which reads the carrier
+// twice. Streamable PNGs are re-read from disk on each call to open; other
+// images are decoded once and served from memory.
+func OpenImageSource(path string) (open func() (RowReader, error), width, height int, err error) {
+	probe, err := OpenImageRows(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	width, height = probe.Size()
+	if m, ok := probe.(*memoryRows); ok {
+		return func() (RowReader, error) { return NewRGBRows(m.rgb, m.width, m.height), nil }, width, height, nil
+	}
+	_ = probe.Close()
+	return func() (RowReader, error) { return OpenImageRows(path) }, width, height, nil
+}
@@ -3,6 +3,7 @@ package engine
 import (
 	"crypto/sha256"
 	"encoding/binary"
+	"math/bits"
 )
 
 func gcd(a, b int) int {
@@ -41,3 +42,32 @@ func scatterParams(password string, n int, context []byte) (a, b int) {
 func scatterSlotIndex(k, n, a, b int) int {
 	return (a*k + b) % n
 }
+
+// modInverse returns x with a*x = 1 (mod n); a and n must be coprime.
+func modInverse(a, n int) int {
+	if n == 1 {
+		return 0
+	}
+	t, newT := 0, 1
+	r, newR := n, a%n
+	for newR != 0 {
+		q := r / newR
+		t, newT = newT, t-q*newT
+		r, newR = newR, r-q*newR
+	}
+	if t < 0 {
+		t += n
+	}
+	return t
+}
+
+// scatterInverse returns the k for which scatterSlotIndex(k, n, a, b) == j,
+// given aInv = modInverse(a, n).
+func scatterInverse(j, n, aInv, b int) int {
+	d := (j - b) % n
+	if d < 0 {
+		d += n
+	}
+	hi, lo := bits.Mul64(uint64(aInv), uint64(d))
+	return int(bits.Rem64(hi, lo, uint64(n)))
+}
@@ -0,0 +1,267 @@
+package engine
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// HideStream embeds data like Hide, but reads the carrier in bands of about
+// ChunkSize bytes and writes each finished band to dst, so memory use does
+// not depend on the image size. The integrity hash covers the finished
+// pixels yet is stored in the first row, so open is called twice: once to
+// compute the hash and once to produce the output.
+func (e *Engine) HideStream(open func() (RowReader, error), dst RowWriter, data []byte, password string, scatter bool) ([]byte, error) {
+	return e.HideStreamContext(context.Background(), open, dst, data, password, scatter)
+}
+
+// HideStreamContext is HideStream, checking ctx before each band.
+func (e *Engine) HideStreamContext(ctx context.Context, open func() (RowReader, error), dst RowWriter, data []byte, password string, scatter bool) ([]byte, error) {
+	src, err := open()
+	if err != nil {
+		return nil, err
+	}
+	width, height := src.Size()
+	plan, err := e.planHide(width, height, data, password, scatter)
+	if err != nil {
+		_ = src.Close()
+		return nil, err
+	}
+	band := make([]byte, e.bandRows(width)*width*3)
+	// SHA-256 cannot be split across cores, so hash each band in the
+	// background while the next one is read and embedded.
+	hasher := newPixelHasher(width, height)
+	spare := make([]byte, len(band))
+	var hashing sync.WaitGroup
+	total := 2 * width * height * 3
+	err = eachBand(ctx, src, band, func(b []byte, lo int) error {
+		plan.embedBand(b, lo, nil)
+		e.progress(lo+len(b), total)
+		hashing.Wait()
+		spare = append(spare[:0], b...)
+		hashing.Add(1)
+		go func(b []byte) {
+			defer hashing.Done()
+			hasher.Write(b)
+		}(spare)
+		return nil
+	})
+	hashing.Wait()
+	_ = src.Close()
+	if err != nil {
+		return nil, err
+	}
+	integrity := hasher.Sum()
+
+	if src, err = open(); err != nil {
+		return nil, err
+	}
+	defer func() { _ = src.Close() }()
+	if w, h := src.Size(); w != width || h != height {
+		return nil, errors.New("carrier changed between passes")
+	}
+	err = eachBand(ctx, src, band, func(b []byte, lo int) error {
+		plan.embedBand(b, lo, integrity)
+		if err := dst.WriteRows(b); err != nil {
+			return err
+		}
+		e.progress(total/2+lo+len(b), total)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return integrity, nil
+}
+
+// ExtractStream is ExtractDetailed over a RowReader. Only the payload is
+// held in memory, and sequential payloads stop reading after their last
+// slot.
+func (e *Engine) ExtractStream(src RowReader, password string) (*ExtractReport, error) {
+	return e.ExtractStreamContext(context.Background(), src, password)
+}
+
+// ExtractStreamContext is ExtractStream, checking ctx before each band.
+func (e *Engine) ExtractStreamContext(ctx context.Context, src RowReader, password string) (*ExtractReport, error) {
+	width, height := src.Size()
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("invalid image size")
+	}
+	total := width * height * 3
+	band := make([]byte, e.bandRows(width)*width*3)
+
+	n, err := readBand(src, band)
+	if err != nil {
+		return nil, err
+	}
+	r, plan, err := e.parsePrefix(extractBytes2bitAtSlot(band[:n], 0, maxPrefixLength), width, height, password)
+	if err != nil {
+		return r, err
+	}
+	if plan.n <= 0 || plan.bodyLen*4 > plan.n {
+		if r.Scatter {
+			return r, fmt.Errorf("%w: invalid scattered payload length", ErrNoPayload)
+		}
+		return r, fmt.Errorf("%w: invalid payload length", ErrNoPayload)
+	}
+	if plan.keyCheck != nil && e.CheckKey != nil {
+		if err := e.CheckKey(ctx, plan.keyCheck); err != nil {
+			return r, err
+		}
+	}
+	words := make([]uint32, (plan.bodyLen+3)/4)
+	end := plan.startSlot + plan.bodyLen*4
+	lo := 0
+	for {
+		plan.extractBand(band[:n], lo, words)
+		lo += n
+		if lo >= total || (!plan.scatter && lo >= end) {
+			e.progress(total, total)
+			break
+		}
+		e.progress(lo, total)
+		if err := ctx.Err(); err != nil {
+			return r, err
+		}
+		if n, err = readBand(src, band); err != nil {
+			return r, err
+		}
+		if n == 0 {
+			return r, io.ErrUnexpectedEOF
+		}
+	}
+
+	var missing []bool
+	if total < plan.virtualLen {
+		missing = plan.missingBytes(total)
+	}
+	plan.finish(r, unpackWords(words, plan.bodyLen), missing)
+	return r, nil
+}
+
+// extractBand reads the body bits that fall inside band, which holds slots
+// [lo, lo+len(band)), into words. Body bits are packed big-endian, sixteen
+// slots to a word, so workers can OR scattered bits in atomically.
+func (p *extractPlan) extractBand(band []byte, lo int, words []uint32) {
+	from, hi := lo, lo+len(band)
+	if from < p.startSlot {
+		from = p.startSlot
+	}
+	slots := p.bodyLen * 4
+	if !p.scatter {
+		if end := p.startSlot + slots; hi > end {
+			hi = end
+		}
+		if from >= hi {
+			return
+		}
+		// Split on word boundaries so no two workers share a word.
+		w0 := (from - p.startSlot) >> 4
+		parallelFor((hi-p.startSlot+15)>>4-w0, func(i0, i1 int) {
+			s0, s1 := p.startSlot+(w0+i0)<<4, p.startSlot+(w0+i1)<<4
+			s0, s1 = max(s0, from), min(s1, hi)
+			for s := s0; s < s1; s++ {
+				k := s - p.startSlot
+				words[k>>4] |= uint32(band[s-lo]&0x3) << uint(30-2*(k&15))
+			}
+		})
+		return
+	}
+	if from >= hi {
+		return
+	}
+	parallelFor(hi-from, func(i0, i1 int) {
+		k := scatterInverse(from+i0-p.startSlot, p.n, p.aInv, p.b)
+		for s := from + i0; s < from+i1; s++ {
+			if k < slots {
+				atomic.OrUint32(&words[k>>4], uint32(band[s-lo]&0x3)<<uint(30-2*(k&15)))
+			}
+			k += p.aInv
+			if k >= p.n {
+				k -= p.n
+			}
+		}
+	})
+}
+
+// unpackWords converts the packed body words back into n bytes.
+func unpackWords(words []uint32, n int) []byte {
+	buf := make([]byte, len(words)*4)
+	for i, w := range words {
+		binary.BigEndian.PutUint32(buf[i*4:], w)
+	}
+	return buf[:n]
+}
+
+// missingBytes flags the body bytes with a slot at or beyond available,
+// the slot count of a bottom-cropped image.
+func (p *extractPlan) missingBytes(available int) []bool {
+	var missing []bool
+	for k := 0; k < p.bodyLen*4; k++ {
+		idx := p.startSlot + k
+		if p.scatter {
+			idx = p.startSlot + scatterSlotIndex(k, p.n, p.a, p.b)
+		}
+		if idx >= available {
+			if missing == nil {
+				missing = make([]bool, p.bodyLen)
+			}
+			missing[k>>2] = true
+		}
+	}
+	return missing
+}
+
+// readBand fills band with whole rows and returns the bytes read; it is
+// short only at the end of the image.
+func readBand(src RowReader, band []byte) (int, error) {
+	width, _ := src.Size()
+	rowLen := width * 3
+	off := 0
+	for off < len(band) {
+		n, err := src.ReadRows(band[off:])
+		off += n * rowLen
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return off, err
+		}
+		if n == 0 {
+			break
+		}
+	}
+	return off, nil
+}
+
+// eachBand passes successive bands of src to fn along with the slot index
+// of their first byte, and checks that the whole image was read. It stops
+// with ctx.Err() once ctx is done.
+func eachBand(ctx context.Context, src RowReader, band []byte, fn func(b []byte, lo int) error) error {
+	width, height := src.Size()
+	lo := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := readBand(src, band)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		if err := fn(band[:n], lo); err != nil {
+			return err
+		}
+		lo += n
+	}
+	if lo != width*height*3 {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type bufferRows struct{ bytes.Buffer }
+
+func (b *bufferRows) WriteRows(rows []byte) error {
+	_, err := b.Write(rows)
+	return err
+}
+
+func TestHideStreamMatchesHide(t *testing.T) {
+	w, h := 97, 61
+	rgb := make([]byte, w*h*3)
+	rand.New(rand.NewSource(1)).Read(rgb)
+	payload := make([]byte, 3000)
+	rand.New(rand.NewSource(2)).Read(payload)
+
+	// A tiny chunk size forces many bands, including ones that split the
+	// prefix from the body.
+	eng := New(1)
+	for _, scatter := range []bool{false, true} {
+		want, wantHash, err := eng.Hide(rgb, w, h, payload, "pass", scatter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got bufferRows
+		open := func() (RowReader, error) { return NewRGBRows(rgb, w, h), nil }
+		gotHash, err := eng.HideStream(open, &got, payload, "pass", scatter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), want) || !bytes.Equal(gotHash, wantHash) {
+			t.Fatalf("scatter=%t: streamed output differs from Hide", scatter)
+		}
+
+		for _, croppedH := range []int{h, h - 7} {
+			in := want[:w*croppedH*3]
+			wantReport, wantErr := eng.ExtractDetailed(in, w, croppedH, "pass")
+			gotReport, gotErr := eng.ExtractStream(NewRGBRows(in, w, croppedH), "pass")
+			if wantErr != nil || gotErr != nil {
+				t.Fatalf("scatter=%t h=%d: %v / %v", scatter, croppedH, wantErr, gotErr)
+			}
+			if !reflect.DeepEqual(gotReport, wantReport) {
+				t.Fatalf("scatter=%t h=%d: stream report differs", scatter, croppedH)
+			}
+		}
+	}
+}
+
+func TestPNGRowsMatchImageDecode(t *testing.T) {
+	w, h := 37, 23
+	rnd := rand.New(rand.NewSource(3))
+	nrgba := image.NewNRGBA(image.Rect(0, 0, w, h))
+	rnd.Read(nrgba.Pix)
+	gray := image.NewGray(image.Rect(0, 0, w, h))
+	rnd.Read(gray.Pix)
+	// More than 16 entries so image/png writes 8-bit indices.
+	var palette color.Palette
+	for i := 0; i < 20; i++ {
+		palette = append(palette, color.NRGBA{uint8(i * 13), uint8(255 - i*7), uint8(i * 31), uint8(255 - i*12)})
+	}
+	pal := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+	for i := range pal.Pix {
+		pal.Pix[i] = uint8(rnd.Intn(len(palette)))
+	}
+	opaque := image.NewRGBA(image.Rect(0, 0, w, h))
+	rnd.Read(opaque.Pix)
+	for i := 3; i < len(opaque.Pix); i += 4 {
+		opaque.Pix[i] = 0xFF
+	}
+
+	dir := t.TempDir()
+	for name, img := range map[string]image.Image{"nrgba": nrgba, "gray": gray, "paletted": pal, "rgb": opaque} {
+		path := filepath.Join(dir, name+".png")
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := OpenImageRows(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := rows.(*fileRows); !ok {
+			t.Fatalf("%s: expected streaming decoder", name)
+		}
+		_ = rows.Close()
+		got, _, _, err := LoadImageRGB(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _, _, _ := ImageToRGB(img)
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s: streamed pixels differ from image/png", name)
+		}
+	}
+}
+
+func TestPNGRowWriterRoundTrip(t *testing.T) {
+	w, h := 64, 40
+	rgb := make([]byte, w*h*3)
+	for i := range rgb {
+		rgb[i] = byte(i*7 + i/97)
+	}
+	path := filepath.Join(t.TempDir(), "out.png")
+	if err := SaveRGBAsPNG(path, rgb, w, h); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, _, _ := ImageToRGB(img)
+	if !bytes.Equal(got, rgb) {
+		t.Fatal("image/png decodes different pixels")
+	}
+}
+
+func TestContextCancelStopsBetweenBands(t *testing.T) {
+	w, h := 97, 61
+	rgb := make([]byte, w*h*3)
+	rand.New(rand.NewSource(1)).Read(rgb)
+	payload := make([]byte, 2000)
+	eng := New(1)
+	hidden, _, err := eng.Hide(rgb, w, h, payload, "pass", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := eng.HideContext(ctx, rgb, w, h, payload, "pass", true); !errors.Is(err, context.Canceled) {
+		t.Fatalf("HideContext: %v", err)
+	}
+	open := func() (RowReader, error) { return NewRGBRows(rgb, w, h), nil }
+	if _, err := eng.HideStreamContext(ctx, open, &bufferRows{}, payload, "pass", true); !errors.Is(err, context.Canceled) {
+		t.Fatalf("HideStreamContext: %v", err)
+	}
+	if _, err := eng.ExtractDetailedContext(ctx, hidden, w, h, "pass"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ExtractDetailedContext: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "out.png")
+	if err := SaveRGBAsPNGContext(ctx, path, hidden, w, h); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SaveRGBAsPNGContext: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("partial PNG left behind")
+	}
+}
@@ -1,23 +1,66 @@
 package models
 
 type EncryptRequest struct {
-	DataSourcePath     string `json:"dataSourcePath"`
-	CarrierDir         string `json:"carrierDir"`
-	CarrierImagePath   string `json:"carrierImagePath"`
-	OutputDir          string `json:"outputDir"`
-	OutputFileName     string `json:"outputFileName"`
-	Password           string `json:"password"`
-	Scatter            *bool  `json:"scatter"`
-	Identifier         string `json:"identifier"`
-	AutoSelectCarrier  bool   `json:"autoSelectCarrier"`
-	PreferLargestImage bool   `json:"preferLargestImage"`
+	DataSourcePath     string   `json:"dataSourcePath"`
+	Text               string   `json:"text"`
+	CarrierDir         string   `json:"carrierDir"`
+	CarrierImagePath   string   `json:"carrierImagePath"`
+	OutputDir          string   `json:"outputDir"`
+	OutputFileName     string   `json:"outputFileName"`
+	Password           string   `json:"password"`
+	KeyfilePath        string   `json:"keyfilePath"`
+	Recipients         []string `json:"recipients"`
+	SigningKey         string   `json:"signingKey"`
+	ShareCount         int      `json:"shareCount"`
+	ShareThreshold     int      `json:"shareThreshold"`
+	Cipher             string   `json:"cipher"`
+	ECC                string   `json:"ecc"`
+	Compression        string   `json:"compression"`
+	Scatter            *bool    `json:"scatter"`
+	Identifier         string   `json:"identifier"`
+	AutoSelectCarrier  bool     `json:"autoSelectCarrier"`
+	PreferLargestImage bool     `json:"preferLargestImage"`
 }
 
 type DecryptRequest struct {
-	ImagePath  string `json:"imagePath"`
-	OutputDir  string `json:"outputDir"`
-	Password   string `json:"password"`
-	Identifier string `json:"identifier"`
+	ImagePath   string   `json:"imagePath"`
+	OutputDir   string   `json:"outputDir"`
+	Password    string   `json:"password"`
+	KeyfilePath string   `json:"keyfilePath"`
+	Identity    string   `json:"identity"`
+	Shares      []string `json:"shares"`
+	Identifier  string   `json:"identifier"`
+}
+
+type InspectRequest struct {
+	ImagePath   string `json:"imagePath"`
+	Password    string `json:"password"`
+	KeyfilePath string `json:"keyfilePath"`
+}
+
+type InspectResult struct {
+	Width           int  `json:"width"`
+	Height          int  `json:"height"`
+	CapacityBytes   int  `json:"capacityBytes"`
+	PayloadFound    bool `json:"payloadFound"`
+	EmbeddedBytes   int  `json:"embeddedBytes"`
+	Scatter         bool `json:"scatter"`
+	CRCValid        bool `json:"crcValid"`
+	Cropped         bool `json:"cropped"`
+	UnreliableBytes int  `json:"unreliableBytes"`
+
+	FEC            string `json:"fec,omitempty"`
+	Algorithm      string `json:"algorithm,omitempty"`
+	KDF            string `json:"kdf,omitempty"`
+	Stream         bool   `json:"stream,omitempty"`
+	ChunkSize      int    `json:"chunkSize,omitempty"`
+	Compression    string `json:"compression,omitempty"`
+	Manifest       bool   `json:"manifest,omitempty"`
+	Keyfile        bool   `json:"keyfile,omitempty"`
+	KeyMode        string `json:"keyMode,omitempty"`
+	Recipients     int    `json:"recipients,omitempty"`
+	ShareThreshold int    `json:"shareThreshold,omitempty"`
+	SignerKeyID    string `json:"signerKeyId,omitempty"`
 }
 
 type GenerateRequest struct {
@@ -29,6 +72,63 @@ type GenerateRequest struct {
 	NoiseEnabled bool   `json:"noiseEnabled"`
 }
 
+type EncryptResult struct {
+	OutputPath string   `json:"outputPath"`
+	Shares     []string `json:"shares,omitempty"`
+}
+
+type DecryptResult struct {
+	OutputPath      string `json:"outputPath"`
+	SignatureStatus string `json:"signatureStatus"`
+	SignerKeyID     string `json:"signerKeyId,omitempty"`
+	SignerName      string `json:"signerName,omitempty"`
+
+	Files       []ManifestEntry `json:"files,omitempty"`
+	PayloadType string          `json:"payloadType,omitempty"`
+	// Text holds a decrypted text payload. It is kept out of progress events
+	// and fetched once through App.GetDecryptedText.
+	Text string `json:"-"`
+}
+
+type ManifestEntry struct {
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	ModTime     int64  `json:"modTime"`
+	Mode        uint32 `json:"mode"`
+	ContentType string `json:"contentType"`
+	SHA256      string `json:"sha256"`
+}
+
+// Stages reported in ProgressEvent.Stage.
+const (
+	StageRead     = "read"
+	StageCompress = "compress"
+	StageCarrier  = "carrier"
+	StageKDF      = "kdf"
+	StageEncrypt  = "encrypt"
+	StageFEC      = "fec"
+	StageEmbed    = "embed"
+	StageExtract  = "extract"
+	StageDecrypt  = "decrypt"
+	StageWrite    = "write"
+	StageGenerate = "generate"
+	StageDone     = "done"
+)
+
+// Error codes reported in ProgressEvent.ErrorCode. They are stable, so the
+// frontend can localise them instead of the raw error text.
+const (
+	ErrorCodeCancelled        = "cancelled"
+	ErrorCodeWrongPassword    = "wrong_password"
+	ErrorCodeKeyRequired      = "key_required"
+	ErrorCodeNoPayload        = "no_payload"
+	ErrorCodeCorrupted        = "corrupted"
+	ErrorCodeCapacity         = "capacity"
+	ErrorCodeSignatureInvalid = "signature_invalid"
+	ErrorCodeKDFLimit         = "kdf_limit"
+	ErrorCodeUnknown          = "unknown"
+)
+
 type ProgressEvent struct {
 	TaskID   string `json:"taskId"`
 	Progress int    `json:"progress"`
@@ -37,6 +137,35 @@ type ProgressEvent struct {
 	Total    int    `json:"total"`
 	Error    string `json:"error,omitempty"`
 	Done     bool   `json:"done,omitempty"`
+
+	// ErrorCode classifies Error as one of the ErrorCode constants.
+	ErrorCode string `json:"errorCode,omitempty"`
+
+	// Stage is one of the Stage constants. Stages that work through a
+	// known number of bytes also report BytesDone/BytesTotal along with the
+	// stage's average throughput and the estimated seconds remaining.
+	Stage       string  `json:"stage,omitempty"`
+	BytesDone   int64   `json:"bytesDone,omitempty"`
+	BytesTotal  int64   `json:"bytesTotal,omitempty"`
+	BytesPerSec float64 `json:"bytesPerSec,omitempty"`
+	ETASeconds  float64 `json:"etaSeconds,omitempty"`
+
+	EncryptResult *EncryptResult `json:"encryptResult,omitempty"`
+	DecryptResult *DecryptResult `json:"decryptResult,omitempty"`
+}
+
+type KDFCalibration struct {
+	Algorithm  string `json:"algorithm"`
+	Time       uint32 `json:"time"`
+	MemoryKiB  uint32 `json:"memoryKiB"`
+	Threads    uint8  `json:"threads"`
+	TargetMs   int64  `json:"targetMs"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+type KeyPair struct {
+	PublicKey  string `json:"publicKey"`
+	PrivateKey string `json:"privateKey"`
 }
 
 type AppInfo struct {
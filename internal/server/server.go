@@ -0,0 +1,406 @@
+// Package server exposes the encrypt, decrypt, generate, config and log
+// operations of the desktop app as a token-protected HTTP JSON API for other
+// tools on the same machine. Task progress streams as Server-Sent Events.
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"stego/internal/app"
+	"stego/internal/config"
+	"stego/internal/log"
+	"stego/internal/models"
+)
+
+// feedRetention is how long a finished task's events stay available to late
+// subscribers.
+const feedRetention = 5 * time.Minute
+
+type Server struct {
+	cfg    *config.Store
+	logger *log.Store
+	token  string
+	tasks  *app.TaskManager
+
+	mu    sync.Mutex
+	feeds map[string]*taskFeed
+	texts map[string]string
+}
+
+// New creates a server over the given stores. logger may be nil. An empty
+// token generates a random one, available from Token.
+func New(cfg *config.Store, logger *log.Store, token string) (*Server, error) {
+	if token == "" {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		token = hex.EncodeToString(b)
+	}
+	return &Server{
+		cfg:    cfg,
+		logger: logger,
+		token:  token,
+		tasks:  app.NewTaskManager(),
+		feeds:  map[string]*taskFeed{},
+		texts:  map[string]string{},
+	}, nil
+}
+
+func (s *Server) Token() string {
+	return s.token
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/encrypt", s.handleEncrypt)
+	mux.HandleFunc("POST /api/decrypt", s.handleDecrypt)
+	mux.HandleFunc("POST /api/generate", s.handleGenerate)
+	mux.HandleFunc("GET /api/tasks/{id}/events", s.handleEvents)
+	mux.HandleFunc("GET /api/tasks/{id}/text", s.handleText)
+	mux.HandleFunc("POST /api/tasks/{id}/cancel", s.handleCancel)
+	mux.HandleFunc("GET /api/config", s.handleGetConfig)
+	mux.HandleFunc("PUT /api/config", s.handleSaveConfig)
+	mux.HandleFunc("GET /api/logs", s.handleGetLogs)
+	mux.HandleFunc("DELETE /api/logs", s.handleClearLogs)
+	return s.authorize(mux)
+}
+
+// authorize accepts a bearer token, or a token query parameter for
+// EventSource clients that cannot set headers, and only answers requests
+// addressed to a loopback host.
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLoopbackHost(r.Host) {
+			writeError(w, http.StatusForbidden, errors.New("only localhost requests are accepted"))
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid or missing token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isLoopbackHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+func (s *Server) logf(level, module, message, details string) {
+	if s.logger != nil {
+		_ = s.logger.Add(level, module, message, details)
+	}
+}
+
+func (s *Server) perfLogger() app.PerfLogger {
+	if s.logger == nil {
+		return nil
+	}
+	return func(module, action, details string) {
+		_ = s.logger.Add("INFO", module, action, details)
+	}
+}
+
+// start runs fn as a cancellable task whose progress is recorded in a feed.
+func (s *Server) start(module string, fn func(ctx context.Context, taskID string, emit func(models.ProgressEvent)) error) string {
+	taskID := uuid.NewString()
+	feed := newTaskFeed()
+	s.mu.Lock()
+	s.feeds[taskID] = feed
+	s.mu.Unlock()
+
+	s.tasks.Start(taskID, func(ctx context.Context) error {
+		err := fn(ctx, taskID, func(p models.ProgressEvent) {
+			p.TaskID = taskID
+			feed.publish(p)
+		})
+		if err != nil {
+			s.logf("ERROR", module, "API 任务失败", fmt.Sprintf("任务ID: %s, 错误: %s", taskID, err.Error()))
+			feed.publish(models.ProgressEvent{TaskID: taskID, Error: err.Error(), ErrorCode: app.ErrorCode(err), Done: true})
+		} else {
+			s.logf("INFO", module, "API 任务完成", "任务ID: "+taskID)
+		}
+		feed.close()
+		time.AfterFunc(feedRetention, func() {
+			s.mu.Lock()
+			delete(s.feeds, taskID)
+			delete(s.texts, taskID)
+			s.mu.Unlock()
+		})
+		return err
+	})
+	s.logf("INFO", module, "API 任务开始", "任务ID: "+taskID)
+	return taskID
+}
+
+func (s *Server) handleEncrypt(w http.ResponseWriter, r *http.Request) {
+	var req models.EncryptRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	taskID := s.start("encrypt", func(ctx context.Context, taskID string, emit func(models.ProgressEvent)) error {
+		_, err := app.RunEncrypt(ctx, s.cfg.GetAllWithDefaults(), req, emit, taskID, s.perfLogger())
+		return err
+	})
+	writeJSON(w, http.StatusAccepted, map[string]string{"taskId": taskID})
+}
+
+func (s *Server) handleDecrypt(w http.ResponseWriter, r *http.Request) {
+	var req models.DecryptRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	taskID := s.start("decrypt", func(ctx context.Context, taskID string, emit func(models.ProgressEvent)) error {
+		_, err := app.RunDecrypt(ctx, s.cfg.GetAllWithDefaults(), req, func(p models.ProgressEvent) {
+			if p.DecryptResult != nil && p.DecryptResult.PayloadType == app.PayloadText {
+				s.mu.Lock()
+				s.texts[taskID] = p.DecryptResult.Text
+				s.mu.Unlock()
+			}
+			emit(p)
+		}, taskID, s.perfLogger())
+		return err
+	})
+	writeJSON(w, http.StatusAccepted, map[string]string{"taskId": taskID})
+}
+
+func (s *Server) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	var req models.GenerateRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	taskID := s.start("generate", func(ctx context.Context, taskID string, emit func(models.ProgressEvent)) error {
+		return app.RunGenerateCarrier(ctx, req, emit, taskID, s.perfLogger())
+	})
+	writeJSON(w, http.StatusAccepted, map[string]string{"taskId": taskID})
+}
+
+func (s *Server) feed(id string) *taskFeed {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.feeds[id]
+}
+
+// handleEvents replays a task's progress so far and then streams new events
+// until the task is done or the client goes away.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	feed := s.feed(r.PathValue("id"))
+	if feed == nil {
+		writeError(w, http.StatusNotFound, errors.New("task not found"))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	next := 0
+	for {
+		events, closed, wait := feed.since(next)
+		for _, p := range events {
+			data, _ := json.Marshal(p)
+			if _, err := fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		next += len(events)
+		flusher.Flush()
+		if closed {
+			return
+		}
+		select {
+		case <-wait:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) handleText(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.mu.Lock()
+	text, ok := s.texts[id]
+	delete(s.texts, id)
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("no text payload for task"))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"text": text})
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.tasks.Cancel(id); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	s.logf("WARN", "api", "任务已取消", "任务ID: "+id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.cfg.GetAllWithDefaults())
+}
+
+func (s *Server) handleSaveConfig(w http.ResponseWriter, r *http.Request) {
+	var values map[string]string
+	if !decodeBody(w, r, &values) {
+		return
+	}
+	if err := s.cfg.SaveAll(values); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.logf("INFO", "config", "配置已保存", "via API")
+	writeJSON(w, http.StatusOK, s.cfg.GetAllWithDefaults())
+}
+
+// handleGetLogs mirrors App.GetLogs: level, start and end (unix seconds),
+// limit and offset are optional query parameters.
+func (s *Server) handleGetLogs(w http.ResponseWriter, r *http.Request) {
+	if s.logger == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"entries": []log.Entry{}, "total": 0})
+		return
+	}
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	startTime, _ := strconv.ParseInt(q.Get("start"), 10, 64)
+	endTime, _ := strconv.ParseInt(q.Get("end"), 10, 64)
+	level := q.Get("level")
+
+	var entries []log.Entry
+	var err error
+	switch {
+	case level != "" && level != "ALL":
+		entries, err = s.logger.GetByLevel(level, limit, offset)
+	case startTime > 0 && endTime > 0:
+		entries, err = s.logger.GetByTimeRange(time.Unix(startTime, 0), time.Unix(endTime, 0), limit, offset)
+	default:
+		entries, err = s.logger.Get(limit, offset)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	total, _ := s.logger.GetCount()
+	if entries == nil {
+		entries = []log.Entry{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"entries": entries, "total": total})
+}
+
+func (s *Server) handleClearLogs(w http.ResponseWriter, r *http.Request) {
+	if s.logger == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("logger not initialized"))
+		return
+	}
+	if err := s.logger.Clear(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.logf("INFO", "system", "日志已清空", "via API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// taskFeed keeps every event of one task so subscribers can join late.
+type taskFeed struct {
+	mu     sync.Mutex
+	events []models.ProgressEvent
+	closed bool
+	wake   chan struct{}
+}
+
+func newTaskFeed() *taskFeed {
+	return &taskFeed{wake: make(chan struct{})}
+}
+
+func (f *taskFeed) publish(p models.ProgressEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	// The app pipelines already report their own terminal error event;
+	// keep only the first one.
+	if n := len(f.events); n > 0 && f.events[n-1].Done {
+		return
+	}
+	f.events = append(f.events, p)
+	close(f.wake)
+	f.wake = make(chan struct{})
+}
+
+func (f *taskFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.closed = true
+		close(f.wake)
+	}
+}
+
+// since returns the events from index i on, whether the feed is finished,
+// and a channel that is closed on the next change.
+func (f *taskFeed) since(i int) ([]models.ProgressEvent, bool, <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]models.ProgressEvent(nil), f.events[i:]...), f.closed, f.wake
+}
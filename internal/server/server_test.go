@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"stego/internal/config"
+	"stego/internal/engine"
+	"stego/internal/models"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	s, err := New(config.NewInMemoryStore(), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return ts, s.Token()
+}
+
+func call(t *testing.T, ts *httptest.Server, token, method, path string, body any, out any) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, ts.URL+path, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decode response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// streamEvents reads the task's SSE stream until the server closes it.
+func streamEvents(t *testing.T, ts *httptest.Server, token, taskID string) []models.ProgressEvent {
+	t.Helper()
+	resp, err := ts.Client().Get(ts.URL + "/api/tasks/" + taskID + "/events?token=" + token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+	var events []models.ProgressEvent
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var p models.ProgressEvent
+		if err := json.Unmarshal([]byte(data), &p); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, p)
+	}
+	return events
+}
+
+func TestRejectsMissingOrWrongToken(t *testing.T) {
+	ts, token := newTestServer(t)
+	if code := call(t, ts, "", "GET", "/api/config", nil, nil); code != http.StatusUnauthorized {
+		t.Fatalf("no token: status %d", code)
+	}
+	if code := call(t, ts, token+"x", "GET", "/api/config", nil, nil); code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: status %d", code)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/api/config", nil)
+	req.Host = "attacker.example"
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("foreign host: status %d", resp.StatusCode)
+	}
+}
+
+func TestConfigAndLogs(t *testing.T) {
+	ts, token := newTestServer(t)
+	var cfg map[string]string
+	if code := call(t, ts, token, "PUT", "/api/config", map[string]string{config.KeyDefaultCipher: "ChaCha20-Poly1305"}, &cfg); code != http.StatusOK {
+		t.Fatalf("save config: status %d", code)
+	}
+	cfg = nil
+	call(t, ts, token, "GET", "/api/config", nil, &cfg)
+	if cfg[config.KeyDefaultCipher] != "ChaCha20-Poly1305" || cfg[config.KeyDefaultECC] == "" {
+		t.Fatalf("config not saved or defaults missing: %v", cfg)
+	}
+	var logs struct {
+		Entries []json.RawMessage `json:"entries"`
+		Total   int               `json:"total"`
+	}
+	if code := call(t, ts, token, "GET", "/api/logs", nil, &logs); code != http.StatusOK || logs.Entries == nil {
+		t.Fatalf("logs: status %d %+v", code, logs)
+	}
+	if code := call(t, ts, token, "POST", "/api/tasks/nope/cancel", nil, nil); code != http.StatusNotFound {
+		t.Fatalf("cancel unknown task: status %d", code)
+	}
+}
+
+func TestEncryptDecryptOverAPI(t *testing.T) {
+	ts, token := newTestServer(t)
+	dir := t.TempDir()
+	rgb := make([]byte, 200*200*3)
+	rand.New(rand.NewSource(1)).Read(rgb)
+	carrier := filepath.Join(dir, "carrier.png")
+	if err := engine.SaveRGBAsPNG(carrier, rgb, 200, 200); err != nil {
+		t.Fatal(err)
+	}
+
+	var started map[string]string
+	code := call(t, ts, token, "POST", "/api/encrypt", models.EncryptRequest{
+		Text:             "over http",
+		CarrierImagePath: carrier,
+		OutputDir:        dir,
+		Password:         "api",
+	}, &started)
+	if code != http.StatusAccepted || started["taskId"] == "" {
+		t.Fatalf("encrypt: status %d %v", code, started)
+	}
+	events := streamEvents(t, ts, token, started["taskId"])
+	last := events[len(events)-1]
+	if !last.Done || last.Error != "" || last.EncryptResult == nil {
+		t.Fatalf("encrypt did not finish cleanly: %+v", last)
+	}
+
+	code = call(t, ts, token, "POST", "/api/decrypt", models.DecryptRequest{
+		ImagePath: last.EncryptResult.OutputPath,
+		OutputDir: dir,
+		Password:  "api",
+	}, &started)
+	if code != http.StatusAccepted {
+		t.Fatalf("decrypt: status %d", code)
+	}
+	events = streamEvents(t, ts, token, started["taskId"])
+	last = events[len(events)-1]
+	if !last.Done || last.Error != "" || last.DecryptResult == nil || last.DecryptResult.PayloadType != "text" {
+		t.Fatalf("decrypt did not finish cleanly: %+v", last)
+	}
+	var text map[string]string
+	if code := call(t, ts, token, "GET", "/api/tasks/"+started["taskId"]+"/text", nil, &text); code != http.StatusOK || text["text"] != "over http" {
+		t.Fatalf("text: status %d %v", code, text)
+	}
+	if code := call(t, ts, token, "GET", "/api/tasks/"+started["taskId"]+"/text", nil, nil); code != http.StatusNotFound {
+		t.Fatalf("text should be handed out once, status %d", code)
+	}
+}
+
+func TestFailedTaskEndsStream(t *testing.T) {
+	ts, token := newTestServer(t)
+	var started map[string]string
+	call(t, ts, token, "POST", "/api/decrypt", models.DecryptRequest{ImagePath: filepath.Join(t.TempDir(), "missing.png")}, &started)
+	events := streamEvents(t, ts, token, started["taskId"])
+	if len(events) == 0 || !events[len(events)-1].Done || events[len(events)-1].Error == "" {
+		t.Fatalf("expected a final error event, got %+v", events)
+	}
+	for _, p := range events[:len(events)-1] {
+		if p.Done {
+			t.Fatalf("more than one terminal event: %+v", events)
+		}
+	}
+	if code := call(t, ts, token, "POST", "/api/decrypt", map[string]any{"bogus": 1}, nil); code != http.StatusBadRequest {
+		t.Fatalf("unknown field: status %d", code)
+	}
+}
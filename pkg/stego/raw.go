@@ -0,0 +1,68 @@
+package stego
+
+import (
+	"image"
+
+	"stego/internal/crypto"
+	"stego/internal/engine"
+)
+
+// Capacity is the largest byte slice Embed can store in an image of the
+// given size. Hide needs room for encryption and error-correction overhead
+// on top of the payload.
+func Capacity(width, height int) int {
+	max := engine.New(defaultChunkSize).CalculateMaxCapacity(width, height, false)
+	n := max - engine.EmbeddedLength(0)
+	for n > 0 && engine.EmbeddedLength(n) > max {
+		n--
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// Embed writes data into carrier without encryption or error correction.
+// With a password and scatter set, the bit positions are keyed by it.
+func Embed(carrier image.Image, data []byte, password string, scatter bool) (*image.RGBA, error) {
+	rgb, w, h, err := engine.ImageToRGB(carrier)
+	if err != nil {
+		return nil, err
+	}
+	out, _, err := engine.New(defaultChunkSize).Hide(rgb, w, h, data, password, scatter)
+	if err != nil {
+		return nil, err
+	}
+	return engine.RGBToImage(out, w, h)
+}
+
+// Extract reads bytes stored by Embed. It returns ErrCorrupted when they
+// fail their checksum.
+func Extract(img image.Image, password string) ([]byte, error) {
+	rgb, w, h, err := engine.ImageToRGB(img)
+	if err != nil {
+		return nil, err
+	}
+	data, _, _, _, err := engine.New(defaultChunkSize).Extract(rgb, w, h, password)
+	return data, err
+}
+
+// GenerateIdentity returns a new X25519 private key and the public key to
+// pass in HideOptions.Recipients.
+func GenerateIdentity() (identity, recipient string, err error) {
+	id, err := crypto.GenerateX25519Identity()
+	if err != nil {
+		return "", "", err
+	}
+	return id.String(), id.Recipient().String(), nil
+}
+
+// GenerateSigningKey returns a new Ed25519 signing key and the public key
+// to list in RevealOptions.TrustedSigners.
+func GenerateSigningKey() (signingKey, verifyKey string, err error) {
+	k, err := crypto.GenerateSigningKey()
+	if err != nil {
+		return "", "", err
+	}
+	return k.String(), k.Public().String(), nil
+}
@@ -0,0 +1,486 @@
+// Package stego hides encrypted payloads in images and recovers them.
+//
+// It is the public face of the engine, crypto and container code used by
+// the desktop app and the stego CLI, and produces images those tools can
+// read. Everything works on image.Image values and io streams; nothing
+// touches the filesystem or the Wails runtime.
+package stego
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"image"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
+
+	"stego/internal/app"
+	"stego/internal/crypto"
+	"stego/internal/engine"
+	"stego/internal/models"
+)
+
+const (
+	CompressionNone    = app.CompressionNone
+	CompressionDeflate = app.CompressionDeflate
+	CompressionZstd    = app.CompressionZstd
+	CompressionXZ      = app.CompressionXZ
+)
+
+const (
+	CipherAESGCM            = crypto.CipherAESGCM
+	CipherChaCha20Poly1305  = crypto.CipherChaCha20Poly1305
+	CipherXChaCha20Poly1305 = crypto.CipherXChaCha20Poly1305
+)
+
+const (
+	SignatureNone      = app.SignatureNone
+	SignatureValid     = app.SignatureValid
+	SignatureUntrusted = app.SignatureUntrusted
+	SignatureInvalid   = app.SignatureInvalid
+)
+
+var (
+	// ErrCorrupted is returned when the embedded data fails its checksum
+	// and error correction could not repair it.
+	ErrCorrupted = app.ErrCorrupted
+	// ErrHashMismatch is returned when a revealed file does not match the
+	// hash recorded when it was hidden.
+	ErrHashMismatch = app.ErrManifestHashMismatch
+	// ErrWrongPassword is returned when the data is intact but the password,
+	// keyfile or key shares do not unlock it.
+	ErrWrongPassword = app.ErrWrongPassword
+	// ErrKeyRequired is returned when the image needs a keyfile, identity
+	// or key shares that were not given.
+	ErrKeyRequired = app.ErrKeyRequired
+	// ErrNoPayload is returned when the image carries no hidden data.
+	ErrNoPayload = app.ErrNoPayload
+	// ErrCapacity is returned when the payload does not fit in the carrier.
+	ErrCapacity = app.ErrCapacity
+	// ErrKDFLimit is returned when the image asks for more Argon2id memory
+	// than RevealOptions.MaxArgon2MemoryKiB allows, or for an excessive
+	// PBKDF2 iteration count.
+	ErrKDFLimit = app.ErrKDFLimit
+)
+
+const defaultChunkSize = 1 << 20
+
+// Progress is reported to the optional callback in HideOptions and
+// RevealOptions. The callback may be called from several goroutines, but
+// never concurrently.
+type Progress struct {
+	Percent int
+	// Stage names the current step, such as "kdf", "encrypt", "fec",
+	// "embed", "extract" or "decrypt". Steps that work through a known
+	// amount of data also set BytesDone and BytesTotal.
+	Stage      string
+	BytesDone  int64
+	BytesTotal int64
+}
+
+// Argon2Params overrides the Argon2id cost. Zero values keep the defaults.
+type Argon2Params struct {
+	Time      uint32
+	MemoryKiB uint32
+	Threads   uint8
+}
+
+// HideOptions controls encryption and embedding. Only Password (or
+// Keyfile, Recipients or Shares) is required.
+type HideOptions struct {
+	Password string
+	// Keyfile is mixed into the password when set.
+	Keyfile []byte
+	// Cipher is one of the Cipher constants; empty selects AES-GCM.
+	Cipher string
+	// ECC is an error-correction setting such as "32" (RS parity bytes) or
+	// "LT(30)"; empty selects the default.
+	ECC string
+	// Compression is one of the Compression constants; empty selects zstd.
+	Compression string
+	// Sequential disables password-keyed scattering of the payload bits.
+	Sequential bool
+	Argon2     Argon2Params
+
+	// Recipients encrypts to X25519 public keys instead of a password.
+	Recipients []string
+	// Shares splits the key into that many custodian shares, any
+	// ShareThreshold of which unlock it.
+	Shares         int
+	ShareThreshold int
+	// SigningKey signs the container with an Ed25519 key.
+	SigningKey string
+
+	Progress func(Progress)
+}
+
+// Payload is a single file to hide. Data is read to the end.
+type Payload struct {
+	Name    string
+	ModTime time.Time
+	Mode    fs.FileMode
+	Data    io.Reader
+}
+
+// HideResult is the stego image and anything the caller has to keep.
+type HideResult struct {
+	Image *image.RGBA
+	// Shares holds the custodian shares when HideOptions.Shares was set.
+	Shares []string
+	// EmbeddedBytes is how much of the carrier capacity was used.
+	EmbeddedBytes int
+}
+
+// WritePNG encodes the stego image. Lossy formats destroy the payload.
+func (r *HideResult) WritePNG(w io.Writer) error {
+	return engine.EncodePNG(w, r.Image)
+}
+
+// Hide encrypts p and embeds it in carrier.
+func Hide(ctx context.Context, carrier image.Image, p Payload, opts HideOptions) (*HideResult, error) {
+	if p.Data == nil {
+		return nil, errors.New("payload data is nil")
+	}
+	data, err := io.ReadAll(p.Data)
+	if err != nil {
+		return nil, err
+	}
+	name := p.Name
+	if name == "" {
+		name = "payload.bin"
+	}
+	modTime := p.ModTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	mode := p.Mode
+	if mode == 0 {
+		mode = 0o644
+	}
+	payload, err := app.NewPayload(data, name, modTime, mode, compressionOrDefault(opts.Compression))
+	if err != nil {
+		return nil, err
+	}
+	return hide(ctx, carrier, payload, opts)
+}
+
+// HideText encrypts a UTF-8 message of up to 1 MiB and embeds it in carrier.
+func HideText(ctx context.Context, carrier image.Image, text string, opts HideOptions) (*HideResult, error) {
+	payload, err := app.NewTextPayload(text, compressionOrDefault(opts.Compression))
+	if err != nil {
+		return nil, err
+	}
+	return hide(ctx, carrier, payload, opts)
+}
+
+func hide(ctx context.Context, carrier image.Image, payload *app.Payload, opts HideOptions) (*HideResult, error) {
+	report := progressFunc(opts.Progress)
+	cipherName := opts.Cipher
+	if cipherName == "" {
+		cipherName = CipherAESGCM
+	}
+	spec, err := crypto.LookupCipher(cipherName)
+	if err != nil {
+		return nil, err
+	}
+	fec := crypto.DefaultFECParams()
+	if strings.TrimSpace(opts.ECC) != "" {
+		if fec, err = crypto.ParseFEC(opts.ECC); err != nil {
+			return nil, err
+		}
+	}
+	cryptoCfg := crypto.DefaultAESGCMConfig()
+	if opts.Argon2 != (Argon2Params{}) {
+		p := crypto.Argon2Params{Time: opts.Argon2.Time, MemoryKiB: opts.Argon2.MemoryKiB, Threads: opts.Argon2.Threads}
+		if p.Time == 0 {
+			p.Time = cryptoCfg.Argon2.Time
+		}
+		if p.MemoryKiB == 0 {
+			p.MemoryKiB = cryptoCfg.Argon2.MemoryKiB
+		}
+		if p.Threads == 0 {
+			p.Threads = cryptoCfg.Argon2.Threads
+		}
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		cryptoCfg.Argon2 = p
+	}
+	secret, usesKeyfile, err := compositeSecret(opts.Password, opts.Keyfile)
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" && len(opts.Recipients) == 0 && opts.Shares == 0 {
+		return nil, errors.New("a password, keyfile, recipients or shares are required")
+	}
+
+	rgb, w, h, err := engine.ImageToRGB(carrier)
+	if err != nil {
+		return nil, err
+	}
+	eng := engine.New(defaultChunkSize)
+	sealer, err := app.NewSealer(payload, app.SealOptions{
+		Secret:         secret,
+		Keyfile:        usesKeyfile,
+		Cipher:         spec,
+		Crypto:         cryptoCfg,
+		FEC:            fec,
+		ChunkSize:      eng.ChunkSize,
+		Recipients:     opts.Recipients,
+		ShareCount:     opts.Shares,
+		ShareThreshold: opts.ShareThreshold,
+		SigningKey:     opts.SigningKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if need, have := sealer.RequiredBytes(), eng.CalculateMaxCapacity(w, h, false); need > have {
+		return nil, fmt.Errorf("%w: need %d bytes, carrier holds %d", ErrCapacity, need, have)
+	}
+	wrapped, err := sealer.Seal(ctx, func(stage string, done, total int64) {
+		switch stage {
+		case models.StageKDF:
+			report.enter(stage, 10, 15)
+		case models.StageEncrypt:
+			report.enter(stage, 15, 60)
+		case models.StageFEC:
+			report.enter(stage, 60, 70)
+		}
+		report.bytes(done, total)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	report.enter(models.StageEmbed, 70, 100)
+	eng.OnProgress = report.bytes
+	eng.KeyCheck = sealer.KeyCheck()
+	out, _, err := eng.HideContext(ctx, rgb, w, h, wrapped, sealer.ScatterSecret(), !opts.Sequential)
+	if err != nil {
+		return nil, err
+	}
+	img, err := engine.RGBToImage(out, w, h)
+	if err != nil {
+		return nil, err
+	}
+	report.enter(models.StageDone, 100, 100)
+	return &HideResult{Image: img, Shares: sealer.Shares(), EmbeddedBytes: engine.EmbeddedLength(len(wrapped))}, nil
+}
+
+// RevealOptions supplies the key material for Reveal. Set whichever of
+// Password/Keyfile, Identity or Shares the image was hidden with.
+type RevealOptions struct {
+	Password string
+	Keyfile  []byte
+	// Identity is an X25519 private key for images hidden to Recipients.
+	Identity string
+	Shares   []string
+	// TrustedSigners lists Ed25519 public keys, optionally as "name=key",
+	// that make a signature count as SignatureValid.
+	TrustedSigners []string
+	// MaxArgon2MemoryKiB caps the Argon2id memory an image may ask for, as
+	// its parameters are read from the image itself; zero allows 256 MiB.
+	MaxArgon2MemoryKiB uint32
+
+	Progress func(Progress)
+}
+
+// File describes one file recorded in the payload manifest.
+type File struct {
+	Name        string
+	Size        int64
+	ModTime     time.Time
+	Mode        fs.FileMode
+	ContentType string
+	SHA256      string
+}
+
+// RevealResult describes what Reveal wrote.
+type RevealResult struct {
+	// Text is set for payloads hidden with HideText.
+	Text bool
+	// Archive means the output is a zip of Files; otherwise it is the
+	// single file Files[0], or raw bytes for images from old versions.
+	Archive bool
+	Files   []File
+
+	Signature   string
+	SignerKeyID string
+	SignerName  string
+}
+
+// Reveal extracts and decrypts the payload of img and writes it to w. A
+// single-file payload is checked against its recorded hash after it has been
+// written; on any error the data written to w must be discarded.
+func Reveal(ctx context.Context, img image.Image, w io.Writer, opts RevealOptions) (*RevealResult, error) {
+	report := progressFunc(opts.Progress)
+	opened, res, err := open(ctx, img, opts, report)
+	if err != nil {
+		return res, err
+	}
+	defer opened.Close()
+
+	var h hash.Hash
+	if !res.Archive && len(res.Files) == 1 {
+		h = sha256.New()
+		w = io.MultiWriter(w, h)
+	}
+	if _, err := io.Copy(w, opened); err != nil {
+		return res, err
+	}
+	if h != nil && hex.EncodeToString(h.Sum(nil)) != res.Files[0].SHA256 {
+		return res, fmt.Errorf("%w: %s", ErrHashMismatch, res.Files[0].Name)
+	}
+	report.enter(models.StageDone, 100, 100)
+	return res, nil
+}
+
+// RevealText extracts a message hidden with HideText.
+func RevealText(ctx context.Context, img image.Image, opts RevealOptions) (string, *RevealResult, error) {
+	report := progressFunc(opts.Progress)
+	opened, res, err := open(ctx, img, opts, report)
+	if err != nil {
+		return "", res, err
+	}
+	defer opened.Close()
+	text, err := opened.ReadText(ctx)
+	if err != nil {
+		return "", res, err
+	}
+	report.enter(models.StageDone, 100, 100)
+	return text, res, nil
+}
+
+func open(ctx context.Context, img image.Image, opts RevealOptions, report *progress) (*app.Opened, *RevealResult, error) {
+	secret, usesKeyfile, err := compositeSecret(opts.Password, opts.Keyfile)
+	if err != nil {
+		return nil, nil, err
+	}
+	rgb, w, h, err := engine.ImageToRGB(img)
+	if err != nil {
+		return nil, nil, err
+	}
+	openOpts := app.OpenOptions{
+		Secret:          secret,
+		Keyfile:         usesKeyfile,
+		Identity:        opts.Identity,
+		Shares:          opts.Shares,
+		TrustedSigners:  strings.Join(opts.TrustedSigners, "\n"),
+		MaxKDFMemoryKiB: opts.MaxArgon2MemoryKiB,
+	}
+	report.enter(models.StageExtract, 10, 40)
+	eng := engine.New(defaultChunkSize)
+	eng.OnProgress = report.bytes
+	eng.CheckKey = func(ctx context.Context, kc []byte) error {
+		report.enter(models.StageKDF, 10, 15)
+		if err := openOpts.CheckKey(ctx, kc); err != nil {
+			return err
+		}
+		report.enter(models.StageExtract, 15, 40)
+		return nil
+	}
+	extract, err := eng.ExtractDetailedContext(ctx, rgb, w, h, secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	var dr models.DecryptResult
+	opened, err := app.Open(ctx, extract, openOpts, &dr, report.forward)
+	res := &RevealResult{
+		Signature:   dr.SignatureStatus,
+		SignerKeyID: dr.SignerKeyID,
+		SignerName:  dr.SignerName,
+	}
+	if err != nil {
+		return nil, res, err
+	}
+	res.Text = opened.Type() == app.PayloadText
+	res.Archive = opened.Archive()
+	for _, e := range opened.Files() {
+		res.Files = append(res.Files, File{
+			Name:        e.Name,
+			Size:        e.Size,
+			ModTime:     time.Unix(e.ModTime, 0),
+			Mode:        fs.FileMode(e.Mode),
+			ContentType: e.ContentType,
+			SHA256:      e.SHA256,
+		})
+	}
+	return opened, res, nil
+}
+
+func compositeSecret(password string, keyfile []byte) (string, bool, error) {
+	if len(keyfile) == 0 {
+		return password, false, nil
+	}
+	digest, err := crypto.KeyfileDigest(bytes.NewReader(keyfile))
+	if err != nil {
+		return "", false, err
+	}
+	return crypto.CompositeSecret(password, digest), true, nil
+}
+
+func compressionOrDefault(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return CompressionZstd
+	}
+	return s
+}
+
+// progress maps stage byte counts onto a span of the overall percentage.
+type progress struct {
+	f        func(Progress)
+	mu       sync.Mutex
+	stage    string
+	from, to int
+}
+
+func progressFunc(f func(Progress)) *progress {
+	return &progress{f: f}
+}
+
+func (p *progress) enter(stage string, from, to int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stage == stage {
+		return
+	}
+	p.stage, p.from, p.to = stage, from, to
+	if p.f != nil {
+		p.f(Progress{Percent: from, Stage: stage})
+	}
+}
+
+func (p *progress) bytes(done, total int64) {
+	if done <= 0 || total <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.f != nil {
+		pct := p.from + int(int64(p.to-p.from)*min(done, total)/total)
+		p.f(Progress{Percent: pct, Stage: p.stage, BytesDone: done, BytesTotal: total})
+	}
+}
+
+// forward passes on an event from app.Open, which already carries a
+// percentage.
+func (p *progress) forward(ev models.ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stage = ev.Stage
+	if p.f != nil {
+		p.f(Progress{Percent: ev.Progress, Stage: ev.Stage, BytesDone: ev.BytesDone, BytesTotal: ev.BytesTotal})
+	}
+}
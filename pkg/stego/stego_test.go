@@ -0,0 +1,137 @@
+package stego
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func noiseImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	return img
+}
+
+func TestHideRevealRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	payload := bytes.Repeat([]byte("library payload "), 200)
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	res, err := Hide(ctx, noiseImage(256, 256), Payload{Name: "notes.txt", ModTime: mtime, Mode: 0o600, Data: bytes.NewReader(payload)}, HideOptions{Password: "lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Round-trip through PNG like a caller storing the result would.
+	var encoded bytes.Buffer
+	if err := res.WritePNG(&encoded); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	rr, err := Reveal(ctx, img, &out, RevealOptions{Password: "lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), payload) {
+		t.Fatal("payload mismatch")
+	}
+	if rr.Text || rr.Archive || len(rr.Files) != 1 {
+		t.Fatalf("unexpected result %+v", rr)
+	}
+	f := rr.Files[0]
+	if f.Name != "notes.txt" || f.Size != int64(len(payload)) || !f.ModTime.Equal(mtime) || f.Mode != 0o600 {
+		t.Fatalf("manifest entry %+v", f)
+	}
+
+	if _, err := Reveal(ctx, img, &bytes.Buffer{}, RevealOptions{Password: "wrong"}); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("expected ErrWrongPassword, got %v", err)
+	}
+}
+
+func TestHideTextWithKeyfileAndSignature(t *testing.T) {
+	ctx := context.Background()
+	signing, verify, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyfile := []byte("keyfile contents")
+	opts := HideOptions{Password: "pw", Keyfile: keyfile, Cipher: CipherChaCha20Poly1305, SigningKey: signing, Sequential: true}
+	var last Progress
+	opts.Progress = func(p Progress) { last = p }
+	res, err := HideText(ctx, noiseImage(200, 200), "hello from the library", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Percent != 100 {
+		t.Fatalf("final progress %d", last.Percent)
+	}
+
+	text, rr, err := RevealText(ctx, res.Image, RevealOptions{Password: "pw", Keyfile: keyfile, TrustedSigners: []string{"me=" + verify}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "hello from the library" || !rr.Text {
+		t.Fatalf("got %q %+v", text, rr)
+	}
+	if rr.Signature != SignatureValid || rr.SignerName != "me" {
+		t.Fatalf("signature %q signer %q", rr.Signature, rr.SignerName)
+	}
+	if _, _, err := RevealText(ctx, res.Image, RevealOptions{Password: "pw"}); err == nil {
+		t.Fatal("expected missing keyfile to fail")
+	}
+}
+
+func TestHideToRecipient(t *testing.T) {
+	ctx := context.Background()
+	identity, recipient, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := HideText(ctx, noiseImage(200, 200), "for your eyes", HideOptions{Recipients: []string{recipient}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, _, err := RevealText(ctx, res.Image, RevealOptions{Identity: identity})
+	if err != nil || text != "for your eyes" {
+		t.Fatalf("got %q, %v", text, err)
+	}
+}
+
+func TestHideRejectsSmallCarrier(t *testing.T) {
+	_, err := Hide(context.Background(), noiseImage(16, 16), Payload{Data: bytes.NewReader(make([]byte, 4096))}, HideOptions{Password: "x"})
+	if err == nil {
+		t.Fatal("expected capacity error")
+	}
+}
+
+func TestEmbedExtract(t *testing.T) {
+	carrier := noiseImage(64, 64)
+	data := make([]byte, Capacity(64, 64))
+	rand.New(rand.NewSource(2)).Read(data)
+	img, err := Embed(carrier, data, "raw", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Extract(img, "raw")
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("extract: %v", err)
+	}
+	if _, err := Embed(carrier, append(data, 0), "raw", true); err == nil {
+		t.Fatal("expected Capacity+1 bytes to be rejected")
+	}
+
+	img.Pix[len(img.Pix)/2] ^= 0x03
+	img.Pix[len(img.Pix)/2+4] ^= 0x03
+	if _, err := Extract(img, "raw"); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("expected ErrCorrupted, got %v", err)
+	}
+}